@@ -0,0 +1,9 @@
+package main
+
+import (
+	"github.com/ARTM2000/archivo/internal/client"
+)
+
+func main() {
+	client.CmdExecute()
+}
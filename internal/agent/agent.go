@@ -15,17 +15,20 @@ import (
 // var configFile *string
 var parsedConfig Config
 
-func agentConfigPreProcess(configPath string) {
-	finalConfigFile := strings.TrimSpace(configPath)
-	if finalConfigFile == "" {
-		// in this case, we set default configuration for config file
-		home, err := homedir.Dir()
-		if err != nil {
-			log.Fatalln(err)
-		}
-		log.Default().Printf("no config file path received. looking at '%s' for '.agent.yaml'", home)
-		finalConfigFile = filepath.Join(home, ".agent.yaml")
+func agentConfigPreProcess(configPath string) string {
+	finalConfigFile := parseAgentConfig(configPath)
+
+	// validate received config
+	if err := parsedConfig.Validate(); err != nil {
+		log.Fatalf(err.Error())
 	}
+
+	log.Default().Println("configuration is valid")
+	return finalConfigFile
+}
+
+func parseAgentConfig(configPath string) string {
+	finalConfigFile := resolveAgentConfigPath(configPath)
 	// check that finalConfigFile exists or not
 	if _, err := os.Stat(finalConfigFile); os.IsNotExist(err) {
 		log.Fatalf("no config file at '%s' found. error: %s\n", finalConfigFile, err.Error())
@@ -37,12 +40,21 @@ func agentConfigPreProcess(configPath string) {
 	}
 
 	log.Default().Println("agent configuration:", parsedConfig.String())
-	// validate received config
-	if err := parsedConfig.Validate(); err != nil {
-		log.Fatalf(err.Error())
-	}
+	return finalConfigFile
+}
 
-	log.Default().Println("configuration is valid")
+func resolveAgentConfigPath(configPath string) string {
+	finalConfigFile := strings.TrimSpace(configPath)
+	if finalConfigFile == "" {
+		// in this case, we set default configuration for config file
+		home, err := homedir.Dir()
+		if err != nil {
+			log.Fatalln(err)
+		}
+		log.Default().Printf("no config file path received. looking at '%s' for '.agent.yaml'", home)
+		finalConfigFile = filepath.Join(home, ".agent.yaml")
+	}
+	return finalConfigFile
 }
 
 var validateAgentCmd = &cobra.Command{
@@ -65,15 +77,30 @@ var agentCmd = &cobra.Command{
 		if err != nil {
 			log.Fatalf(err.Error())
 		}
-		agentConfigPreProcess(configPath)
-		agCron, err := registerCronJobs(&parsedConfig)
+		watchConfig, err := cmd.Flags().GetBool("watch-config")
+		if err != nil {
+			log.Fatalf(err.Error())
+		}
+		finalConfigFile := agentConfigPreProcess(configPath)
+		runner, err := startAgentRunner(finalConfigFile, &parsedConfig)
 		if err != nil {
 			log.Fatalf(err.Error())
 		}
 
+		// configuration is reloaded on SIGHUP, and on its change if requested
+		go processmng.OnHangup(runner.reload)
+		stopConfigWatcher := func() {}
+		if watchConfig {
+			stopConfigWatcher, err = watchConfigFile(finalConfigFile, runner.reload)
+			if err != nil {
+				log.Fatalf(err.Error())
+			}
+		}
+
 		eCh := make(chan int)
 		go processmng.OnInterrupt(func() {
-			agCron.Stop()
+			stopConfigWatcher()
+			runner.stop()
 			eCh <- 1
 		})
 
@@ -89,6 +116,11 @@ func init() {
 		"",
 		"path of agent1 config yaml file (default to $HOME/.agent.yaml)",
 	)
+	agentCmd.Flags().Bool(
+		"watch-config",
+		false,
+		"reload configuration when config file changes",
+	)
 
 	validateAgentCmd.Flags().StringP(
 		"config",
@@ -100,6 +132,8 @@ func init() {
 
 func CmdExecute() {
 	agentCmd.AddCommand(validateAgentCmd)
+	agentCmd.AddCommand(restoreAgentCmd)
+	agentCmd.AddCommand(enrollAgentCmd)
 	if err := agentCmd.Execute(); err != nil {
 		log.Fatalln(err.Error())
 	}
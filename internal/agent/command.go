@@ -0,0 +1,80 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+)
+
+const commandOutputFilePrefix = "archivo-command-"
+
+// runCommand runs command with environment of file and writes its stdout to
+// out. stderr is returned for logging
+func runCommand(file *File, command []string, out io.Writer) (string, int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), file.CommandTimeout())
+	defer cancel()
+
+	stderr := &bytes.Buffer{}
+	cmd := exec.CommandContext(ctx, command[0], command[1:]...)
+	cmd.Env = append(os.Environ(), file.Env...)
+	cmd.Stdout = out
+	cmd.Stderr = stderr
+
+	err := cmd.Run()
+	if ctx.Err() == context.DeadlineExceeded {
+		return stderr.String(), -1, fmt.Errorf("command timed out after '%s'", file.CommandTimeout())
+	}
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			return stderr.String(), exitErr.ExitCode(), fmt.Errorf("command exited with code %d", exitErr.ExitCode())
+		}
+		return stderr.String(), -1, err
+	}
+	return stderr.String(), 0, nil
+}
+
+// runHook runs pre or post hook of file and logs its output and exit code
+func runHook(name string, hook []string, file *File, correlationId string) error {
+	output := &bytes.Buffer{}
+	stderr, exitCode, err := runCommand(file, hook, output)
+
+	log.Default().Printf(
+		"request-id:'%s', %s of file '%s' exited with code %d. stdout: %q, stderr: %q",
+		correlationId, name, file.StoredFilename(), exitCode, output.String(), stderr,
+	)
+	return err
+}
+
+// commandOutputFile runs command of file and writes its stdout to a temporary
+// file, so it can be uploaded like a regular file. output of failed commands
+// is not uploaded, as it may be partial
+func commandOutputFile(file *File, correlationId string) (*os.File, error) {
+	out, err := os.CreateTemp("", commandOutputFilePrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	stderr, exitCode, err := runCommand(file, file.Command, out)
+	log.Default().Printf(
+		"request-id:'%s', command of file '%s' exited with code %d. stderr: %q",
+		correlationId, file.StoredFilename(), exitCode, stderr,
+	)
+	if err != nil {
+		out.Close()
+		os.Remove(out.Name())
+		return nil, err
+	}
+
+	if _, err := out.Seek(0, io.SeekStart); err != nil {
+		out.Close()
+		os.Remove(out.Name())
+		return nil, err
+	}
+	return out, nil
+}
@@ -5,16 +5,37 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
+	"time"
 
 	"github.com/ARTM2000/archivo/internal/validate"
 	"github.com/robfig/cron/v3"
 )
 
+const (
+	defaultCommandTimeout = time.Minute
+	defaultDebounce       = 2 * time.Second
+	defaultMinInterval    = time.Minute
+)
+
 type File struct {
-	Path     string `mapstructure:"path" json:"path" validate:"required,filepath"`
+	Path     string `mapstructure:"path" json:"path" validate:"omitempty,filepath"`
 	Interval string `mapstructure:"interval" json:"interval" validate:"required"`
 	Rotate   int64  `mapstructure:"rotate" json:"rotate" validate:"omitempty,required,number"`
 	Filename string `mapstructure:"filename" json:"filename" validate:"omitempty,required,filename,alphanum"`
+	// command which its stdout is uploaded instead of file at path
+	Command []string `mapstructure:"command" json:"command"`
+	// commands which run before and after upload of file
+	PreHook  []string `mapstructure:"pre_hook" json:"pre_hook"`
+	PostHook []string `mapstructure:"post_hook" json:"post_hook"`
+	// environment variables of command and hooks in KEY=VALUE format
+	Env     []string `mapstructure:"env" json:"-"`
+	Timeout string   `mapstructure:"timeout" json:"timeout"`
+	// trigger of uploads, cron or watch. in watch mode file is uploaded after
+	// it is changed and interval is used for fallback periodic uploads
+	Trigger     string `mapstructure:"trigger" json:"trigger" validate:"omitempty,oneof=cron watch"`
+	Debounce    string `mapstructure:"debounce" json:"debounce"`
+	MinInterval string `mapstructure:"min_interval" json:"min_interval"`
 }
 
 func (f *File) String() string {
@@ -23,20 +44,41 @@ func (f *File) String() string {
 }
 
 func (f *File) Validate() error {
-	// block relative paths
-	if !filepath.IsAbs(f.Path) {
+	if f.Path == "" && len(f.Command) == 0 {
+		return fmt.Errorf("either path or command should be set for every file")
+	}
+	if f.Path != "" && len(f.Command) != 0 {
+		return fmt.Errorf("only one of path or command can be set for file. path: %s", f.Path)
+	}
+
+	if len(f.Command) != 0 {
+		// there is no path to name snapshots of command output by
+		if f.Filename == "" {
+			return fmt.Errorf("filename is required for command output. command: %v", f.Command)
+		}
+	} else if !filepath.IsAbs(f.Path) {
+		// block relative paths
 		return fmt.Errorf("every paths should be absolute. invalid path: %s", f.Path)
 	}
 
-	// check file path existence
-	if _, err := os.Stat(f.Path); err != nil {
-		if os.IsNotExist(err) {
-			return fmt.Errorf("file not exists: %s", f.Path)
+	for _, env := range f.Env {
+		if !strings.Contains(env, "=") {
+			return fmt.Errorf("env should be in KEY=VALUE format. invalid env: %s", env)
 		}
+	}
 
-		if !os.IsPermission(err) {
-			return fmt.Errorf("file permission not granted: %s", f.Path)
+	durations := map[string]string{"timeout": f.Timeout, "debounce": f.Debounce, "min_interval": f.MinInterval}
+	for name, value := range durations {
+		if value == "" {
+			continue
 		}
+		if d, err := time.ParseDuration(value); err != nil || d <= 0 {
+			return fmt.Errorf("%s is invalid, it should be a positive duration like 30s: %s", name, value)
+		}
+	}
+
+	if f.Trigger == TriggerWatch && f.Path == "" {
+		return fmt.Errorf("watch trigger is only usable for files with path. filename: %s", f.Filename)
 	}
 
 	// check that received crontab is usable or not
@@ -51,11 +93,123 @@ func (f *File) Validate() error {
 	return nil
 }
 
+func (f *File) checkExistence() error {
+	if f.Path == "" {
+		return nil
+	}
+
+	// check file path existence
+	if _, err := os.Stat(f.Path); err != nil {
+		if os.IsNotExist(err) {
+			return fmt.Errorf("file not exists: %s", f.Path)
+		}
+
+		if !os.IsPermission(err) {
+			return fmt.Errorf("file permission not granted: %s", f.Path)
+		}
+	}
+
+	return nil
+}
+
+// CommandTimeout is the max run time of command and each of hooks
+func (f *File) CommandTimeout() time.Duration {
+	if f.Timeout == "" {
+		return defaultCommandTimeout
+	}
+	d, _ := time.ParseDuration(f.Timeout)
+	return d
+}
+
+// DebounceDuration is how long a watched file should stay unchanged before
+// its upload
+func (f *File) DebounceDuration() time.Duration {
+	if f.Debounce == "" {
+		return defaultDebounce
+	}
+	d, _ := time.ParseDuration(f.Debounce)
+	return d
+}
+
+// MinIntervalDuration is the min time between two uploads of a watched file
+func (f *File) MinIntervalDuration() time.Duration {
+	if f.MinInterval == "" {
+		return defaultMinInterval
+	}
+	d, _ := time.ParseDuration(f.MinInterval)
+	return d
+}
+
+// StoredFilename is the name which file snapshots are stored by on server
+func (f *File) StoredFilename() string {
+	if f.Filename != "" {
+		return f.Filename
+	}
+	return filepath.Base(f.Path)
+}
+
+const (
+	defaultRestorePollInterval   = 30 * time.Second
+	defaultManagedConfigInterval = 5 * time.Minute
+	defaultHeartbeatInterval     = time.Minute
+)
+
+const (
+	ManagedConfigMerge   = "merge"
+	ManagedConfigReplace = "replace"
+)
+
 type Config struct {
 	ArchiveServer string `mapstructure:"archivo_server" json:"archivo_server" validate:"required,url"`
 	AgentName     string `mapstructure:"agent_name" json:"agent_name" validate:"required"`
-	AgentKey      string `mapstructure:"agent_key" json:"-" validate:"required"`
-	Files         []File `mapstructure:"files" json:"files" validate:"required,min=1,dive"`
+	AgentKey      string `mapstructure:"agent_key" json:"-" validate:"required_without_all=AgentKeyFile TLSCert"`
+	// agent key can be read from a separate file, e.g. the one written by
+	// enroll command. it overrides agent_key
+	AgentKeyFile string `mapstructure:"agent_key_file" json:"agent_key_file" validate:"omitempty,filepath"`
+	// agent key is sent as is instead of signing requests by it, for archivo
+	// servers which do not verify signed requests yet
+	LegacyAuth bool `mapstructure:"legacy_auth" json:"legacy_auth"`
+	// client certificate of agent, for archivo servers which authorize agents
+	// by certificates. ca_file verifies certificate of archivo server
+	TLSCert             string `mapstructure:"tls_cert" json:"tls_cert" validate:"omitempty,filepath"`
+	TLSKey              string `mapstructure:"tls_key" json:"tls_key" validate:"required_with=TLSCert,omitempty,filepath"`
+	CAFile              string `mapstructure:"ca_file" json:"ca_file" validate:"omitempty,filepath"`
+	RestorePollInterval string `mapstructure:"restore_poll_interval" json:"restore_poll_interval"`
+	HeartbeatInterval   string `mapstructure:"heartbeat_interval" json:"heartbeat_interval"`
+	// files which are defined for source server in panel are merged with or
+	// replace the files of this config
+	ManagedConfig         string `mapstructure:"managed_config" json:"managed_config" validate:"omitempty,oneof=merge replace"`
+	ManagedConfigInterval string `mapstructure:"managed_config_interval" json:"managed_config_interval"`
+	Files                 []File `mapstructure:"files" json:"files" validate:"required_without=ManagedConfig,dive"`
+}
+
+// ManagedConfigDuration is how often agent refreshes its managed config
+func (c *Config) ManagedConfigDuration() time.Duration {
+	if c.ManagedConfigInterval == "" {
+		return defaultManagedConfigInterval
+	}
+	d, _ := time.ParseDuration(c.ManagedConfigInterval)
+	return d
+}
+
+// RestorePollDuration is how often agent asks server for restore jobs. zero
+// means polling is disabled
+func (c *Config) RestorePollDuration() time.Duration {
+	if c.RestorePollInterval == "" {
+		return defaultRestorePollInterval
+	}
+	d, _ := time.ParseDuration(c.RestorePollInterval)
+	return d
+}
+
+// HeartbeatDuration is how often agent reports its state to server. zero
+// means heartbeat is disabled
+func (c *Config) HeartbeatDuration() time.Duration {
+	if c.HeartbeatInterval == "" {
+		return defaultHeartbeatInterval
+	}
+	d, _ := time.ParseDuration(c.HeartbeatInterval)
+	return d
 }
 
 func (c *Config) String() string {
@@ -64,19 +218,77 @@ func (c *Config) String() string {
 }
 
 func (c *Config) Validate() error {
+	return c.validate(true)
+}
+
+// ValidateForRestore validates configuration without checking existence of
+// files, as restore can bring back a deleted file
+func (c *Config) ValidateForRestore() error {
+	return c.validate(false)
+}
+
+func (c *Config) validate(checkExistence bool) error {
 	errors, ok := validate.ValidateStruct[Config](c)
 	if !ok {
 		return fmt.Errorf("configuration validation error: %s", errors[0].Message)
 	}
 
+	// key of agent key file is used instead of agent_key
+	if c.AgentKeyFile != "" {
+		key, err := os.ReadFile(c.AgentKeyFile)
+		if err != nil {
+			return fmt.Errorf("unable to read agent key file: %s", err.Error())
+		}
+		c.AgentKey = strings.TrimSpace(string(key))
+		if c.AgentKey == "" {
+			return fmt.Errorf("agent key file '%s' is empty", c.AgentKeyFile)
+		}
+	}
+
+	if c.TLSCert != "" || c.CAFile != "" {
+		if _, err := c.httpClient(); err != nil {
+			return fmt.Errorf("tls configuration is invalid: %s", err.Error())
+		}
+	}
+
+	if c.RestorePollInterval != "" {
+		d, err := time.ParseDuration(c.RestorePollInterval)
+		if err != nil {
+			return fmt.Errorf("restore poll interval is invalid format: %s", err.Error())
+		}
+		if d < 0 {
+			return fmt.Errorf("restore poll interval should not be negative")
+		}
+	}
+
+	if c.HeartbeatInterval != "" {
+		d, err := time.ParseDuration(c.HeartbeatInterval)
+		if err != nil {
+			return fmt.Errorf("heartbeat interval is invalid format: %s", err.Error())
+		}
+		if d < 0 {
+			return fmt.Errorf("heartbeat interval should not be negative")
+		}
+	}
+
+	if c.ManagedConfigInterval != "" {
+		if d, err := time.ParseDuration(c.ManagedConfigInterval); err != nil || d <= 0 {
+			return fmt.Errorf("managed config interval is invalid, it should be a positive duration like 5m: %s", c.ManagedConfigInterval)
+		}
+	}
+
 	filenames := []string{}
 	for _, file := range c.Files {
 		if err := file.Validate(); err != nil {
 			return err
 		}
-		if file.Filename != "" {
-			filenames = append(filenames, file.Filename)
+		if checkExistence {
+			if err := file.checkExistence(); err != nil {
+				return err
+			}
 		}
+		// snapshots of files are stored by their filename on server
+		filenames = append(filenames, file.StoredFilename())
 	}
 
 	isUnique, dup := validate.ValidateSliceParamUniqueness[string](filenames)
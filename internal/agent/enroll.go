@@ -0,0 +1,252 @@
+package agent
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+const secretFilePerm = 0600
+
+type enrollRequest struct {
+	Token string `json:"token"`
+	Name  string `json:"name"`
+}
+
+type enrollResponse struct {
+	Data struct {
+		Name   string `json:"name"`
+		Group  string `json:"group"`
+		APIKey string `json:"api_key"`
+	} `json:"data"`
+}
+
+var enrollAgentCmd = &cobra.Command{
+	Use:   "enroll",
+	Short: "Register this host as a new source server on Archivo server by an enrollment token",
+	Run: func(cmd *cobra.Command, _ []string) {
+		configPath, err := cmd.Flags().GetString("config")
+		if err != nil {
+			log.Fatalf(err.Error())
+		}
+		server, err := cmd.Flags().GetString("server")
+		if err != nil {
+			log.Fatalf(err.Error())
+		}
+		token, err := cmd.Flags().GetString("token")
+		if err != nil {
+			log.Fatalf(err.Error())
+		}
+		name, err := cmd.Flags().GetString("name")
+		if err != nil {
+			log.Fatalf(err.Error())
+		}
+		keyFile, err := cmd.Flags().GetString("key-file")
+		if err != nil {
+			log.Fatalf(err.Error())
+		}
+		caFile, err := cmd.Flags().GetString("ca-file")
+		if err != nil {
+			log.Fatalf(err.Error())
+		}
+
+		if name == "" {
+			hostname, err := os.Hostname()
+			if err != nil {
+				log.Fatalf("unable to get hostname, use --name instead. error: %s", err.Error())
+			}
+			name = sourceServerName(hostname)
+			if name == "" {
+				log.Fatalf("no source server name can be made of hostname '%s', use --name instead", hostname)
+			}
+		}
+		server = strings.TrimRight(server, "/")
+
+		client, err := (&Config{CAFile: caFile}).httpClient()
+		if err != nil {
+			log.Fatalf(err.Error())
+		}
+		enrolled, err := enrollToArchivoServer(client, server, token, name)
+		if err != nil {
+			log.Fatalf(err.Error())
+		}
+		log.Default().Printf("enrolled as source server '%s' in group '%s'\n", enrolled.Data.Name, enrolled.Data.Group)
+
+		finalConfigFile := resolveAgentConfigPath(configPath)
+		values := map[string]string{
+			"archivo_server": server,
+			"agent_name":     enrolled.Data.Name,
+		}
+		if caFile != "" {
+			values["ca_file"] = caFile
+		}
+		if keyFile != "" {
+			if err := writeSecretFile(keyFile, []byte(enrolled.Data.APIKey+"\n")); err != nil {
+				log.Fatalf("unable to write agent key file. error: %s", err.Error())
+			}
+			log.Default().Printf("agent key is written to '%s'\n", keyFile)
+			values["agent_key_file"] = keyFile
+		} else {
+			values["agent_key"] = enrolled.Data.APIKey
+		}
+
+		if err := writeEnrolledConfig(finalConfigFile, values, keyFile != ""); err != nil {
+			log.Fatalf("unable to write agent configuration. error: %s", err.Error())
+		}
+		log.Default().Printf("agent configuration is written to '%s'\n", finalConfigFile)
+	},
+}
+
+func init() {
+	enrollAgentCmd.Flags().StringP(
+		"config",
+		"c",
+		"",
+		"path of agent1 config yaml file which is created or updated (default to $HOME/.agent.yaml)",
+	)
+	enrollAgentCmd.Flags().String("server", "", "address of Archivo server, e.g. https://server.archivo.io")
+	enrollAgentCmd.Flags().String("token", "", "enrollment token which is created in Archivo panel")
+	enrollAgentCmd.Flags().String("name", "", "name of source server (default to hostname without non alphanumeric characters)")
+	enrollAgentCmd.Flags().String("key-file", "", "write agent key to this file instead of config file")
+	enrollAgentCmd.Flags().String("ca-file", "", "ca certificate which certificate of Archivo server is verified by")
+	enrollAgentCmd.MarkFlagRequired("server")
+	enrollAgentCmd.MarkFlagRequired("token")
+}
+
+// sourceServerName makes a source server name of hostname, as names can only
+// have letters and digits. domain of hostname is not used
+func sourceServerName(hostname string) string {
+	hostname = strings.SplitN(hostname, ".", 2)[0]
+	return strings.Map(func(r rune) rune {
+		if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+			return r
+		}
+		return -1
+	}, hostname)
+}
+
+func enrollToArchivoServer(client *http.Client, server, token, name string) (*enrollResponse, error) {
+	correlationId := uuid.New().String()
+
+	log.Default().Printf("request-id:'%s', enrolling as source server '%s'\n", correlationId, name)
+
+	body, err := json.Marshal(enrollRequest{Token: token, Name: name})
+	if err != nil {
+		return nil, fmt.Errorf("request-id:'%s', error: %s", correlationId, err.Error())
+	}
+
+	req, err := http.NewRequest(http.MethodPost, fmt.Sprintf("%s%s", server, "/api/v1/servers/enroll"), bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("request-id:'%s', error: %s", correlationId, err.Error())
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Request-ID", correlationId)
+
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("request-id:'%s', error: %s", correlationId, err.Error())
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != 200 {
+		resBody, _ := io.ReadAll(res.Body)
+		return nil, fmt.Errorf("request-id:'%s', error: non 200 status code received. response: %s", correlationId, resBody)
+	}
+
+	enrolled := enrollResponse{}
+	if err := json.NewDecoder(res.Body).Decode(&enrolled); err != nil {
+		return nil, fmt.Errorf("request-id:'%s', error: %s", correlationId, err.Error())
+	}
+	return &enrolled, nil
+}
+
+// writeSecretFile writes data to path, readable only by its owner
+func writeSecretFile(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, data, secretFilePerm); err != nil {
+		return err
+	}
+	// permission of an existing file is not changed by WriteFile
+	return os.Chmod(path, secretFilePerm)
+}
+
+// writeEnrolledConfig sets values in agent config file and keeps the rest of
+// it, comments included. a new config uses managed files of source server,
+// as it has no files of its own
+func writeEnrolledConfig(configPath string, values map[string]string, keyInFile bool) error {
+	doc := yaml.Node{}
+	content, err := os.ReadFile(configPath)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := yaml.Unmarshal(content, &doc); err != nil {
+		return err
+	}
+
+	newConfig := len(doc.Content) == 0
+	if newConfig {
+		doc = yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{{Kind: yaml.MappingNode}}}
+	}
+	root := doc.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return fmt.Errorf("config file '%s' is not a yaml map", configPath)
+	}
+
+	for _, key := range []string{"archivo_server", "agent_name", "agent_key", "agent_key_file", "ca_file"} {
+		if value, exists := values[key]; exists {
+			setYamlValue(root, key, value)
+		}
+	}
+	if keyInFile {
+		removeYamlKey(root, "agent_key")
+	} else {
+		removeYamlKey(root, "agent_key_file")
+	}
+	if newConfig {
+		setYamlValue(root, "managed_config", ManagedConfigMerge)
+	}
+
+	out, err := yaml.Marshal(&doc)
+	if err != nil {
+		return err
+	}
+	return writeSecretFile(configPath, out)
+}
+
+func setYamlValue(mapping *yaml.Node, key, value string) {
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			mapping.Content[i+1] = &yaml.Node{
+				Kind:        yaml.ScalarNode,
+				Value:       value,
+				LineComment: mapping.Content[i+1].LineComment,
+			}
+			return
+		}
+	}
+	mapping.Content = append(mapping.Content,
+		&yaml.Node{Kind: yaml.ScalarNode, Value: key},
+		&yaml.Node{Kind: yaml.ScalarNode, Value: value},
+	)
+}
+
+func removeYamlKey(mapping *yaml.Node, key string) {
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			mapping.Content = append(mapping.Content[:i], mapping.Content[i+2:]...)
+			return
+		}
+	}
+}
@@ -0,0 +1,26 @@
+//go:build !windows
+
+package agent
+
+import (
+	"os"
+	"syscall"
+)
+
+// fileOwner returns uid and gid of owner of file
+func fileOwner(info os.FileInfo) (int, int, bool) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, 0, false
+	}
+	return int(stat.Uid), int(stat.Gid), true
+}
+
+// chownLike sets owner of file to owner of received file info
+func chownLike(path string, info os.FileInfo) error {
+	uid, gid, ok := fileOwner(info)
+	if !ok {
+		return nil
+	}
+	return os.Lchown(path, uid, gid)
+}
@@ -0,0 +1,15 @@
+//go:build windows
+
+package agent
+
+import "os"
+
+// fileOwner reports no owner on windows, as files have no unix owner
+func fileOwner(info os.FileInfo) (int, int, bool) {
+	return 0, 0, false
+}
+
+// chownLike does nothing on windows, as files have no unix owner
+func chownLike(path string, info os.FileInfo) error {
+	return nil
+}
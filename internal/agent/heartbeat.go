@@ -0,0 +1,178 @@
+package agent
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"runtime"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Version of agent, which is reported in heartbeats. it is set on build by
+// -ldflags "-X github.com/ARTM2000/archivo/internal/agent.Version=<version>"
+var Version = "dev"
+
+type heartbeatFile struct {
+	Filename    string     `json:"filename"`
+	Path        string     `json:"path"`
+	Command     bool       `json:"command"`
+	Trigger     string     `json:"trigger"`
+	Interval    string     `json:"interval"`
+	Rotate      int64      `json:"rotate"`
+	NextRun     *time.Time `json:"next_run"`
+	LastRun     *time.Time `json:"last_run"`
+	LastSuccess *time.Time `json:"last_success"`
+	LastError   string     `json:"last_error"`
+	Reachable   bool       `json:"reachable"`
+	Pending     bool       `json:"pending"`
+	Running     bool       `json:"running"`
+}
+
+// heartbeat is the state of agent which is sent to server periodically.
+// pending uploads are the ones which are triggered by file changes and are
+// waiting for debounce or min interval
+type heartbeat struct {
+	Version        string          `json:"version"`
+	Hostname       string          `json:"hostname"`
+	OS             string          `json:"os"`
+	Arch           string          `json:"arch"`
+	ConfigRevision string          `json:"config_revision"`
+	PendingUploads int             `json:"pending_uploads"`
+	RunningUploads int             `json:"running_uploads"`
+	Files          []heartbeatFile `json:"files"`
+}
+
+func timeOrNil(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
+// state returns file of job with the result of its last upload
+func (j *fileJob) state() heartbeatFile {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	trigger := j.file.Trigger
+	if trigger == "" {
+		trigger = TriggerCron
+	}
+	return heartbeatFile{
+		Filename:    j.file.StoredFilename(),
+		Path:        j.file.Path,
+		Command:     len(j.file.Command) > 0,
+		Trigger:     trigger,
+		Interval:    j.file.Interval,
+		Rotate:      j.file.Rotate,
+		LastRun:     timeOrNil(j.lastRun),
+		LastSuccess: timeOrNil(j.lastSuccess),
+		LastError:   j.lastError,
+		Reachable:   fileReachable(j.file),
+		Pending:     j.pending,
+		Running:     j.running,
+	}
+}
+
+// fileReachable checks that file can be read by agent, or command of file
+// can be found
+func fileReachable(file *File) bool {
+	if len(file.Command) > 0 {
+		_, err := exec.LookPath(file.Command[0])
+		return err == nil
+	}
+	f, err := os.Open(file.Path)
+	if err != nil {
+		return false
+	}
+	f.Close()
+	return true
+}
+
+// startHeartbeat sends heartbeat which collect returns to server, once at
+// start and then every heartbeat interval. returned function stops it
+func startHeartbeat(config *Config, collect func() heartbeat) func() {
+	interval := config.HeartbeatDuration()
+	if interval == 0 {
+		log.Default().Println("heartbeat is disabled")
+		return func() {}
+	}
+
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			if err := sendHeartbeat(config, collect()); err != nil {
+				log.Default().Printf("unable to send heartbeat, error: %s", err.Error())
+			}
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+
+	return func() {
+		close(done)
+	}
+}
+
+func sendHeartbeat(config *Config, hb heartbeat) error {
+	correlationId := uuid.New().String()
+	client, err := config.httpClient()
+	if err != nil {
+		return fmt.Errorf("request-id:'%s', error: %s", correlationId, err.Error())
+	}
+	requestUrl := fmt.Sprintf("%s%s", config.ArchiveServer, "/api/v1/servers/store/heartbeat")
+
+	body, err := json.Marshal(hb)
+	if err != nil {
+		return fmt.Errorf("request-id:'%s', error: %s", correlationId, err.Error())
+	}
+
+	req, err := http.NewRequest(http.MethodPost, requestUrl, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("request-id:'%s', error: %s", correlationId, err.Error())
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Request-ID", correlationId)
+	if err := config.authorize(req, hashBody(body)); err != nil {
+		return fmt.Errorf("request-id:'%s', error: %s", correlationId, err.Error())
+	}
+
+	res, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("request-id:'%s', error: %s", correlationId, err.Error())
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != 200 {
+		resBody, _ := io.ReadAll(res.Body)
+		return fmt.Errorf("request-id:'%s', error: non 200 status code received. response: %s", correlationId, resBody)
+	}
+	return nil
+}
+
+func newHeartbeat(revision string) heartbeat {
+	hostname, err := os.Hostname()
+	if err != nil {
+		log.Default().Printf("unable to get hostname, error: %s", err.Error())
+	}
+	return heartbeat{
+		Version:        Version,
+		Hostname:       hostname,
+		OS:             runtime.GOOS,
+		Arch:           runtime.GOARCH,
+		ConfigRevision: revision,
+		Files:          []heartbeatFile{},
+	}
+}
@@ -1,83 +1,160 @@
 package agent
 
 import (
-	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
 	"io"
 	"log"
 	"mime/multipart"
 	"net/http"
 	"os"
-	"path/filepath"
+	"os/user"
+	"sort"
 	"strconv"
 
 	"github.com/google/uuid"
-	"github.com/robfig/cron/v3"
 )
 
-func registerCronJobs(config *Config) (*cron.Cron, error) {
-	c := cron.New(cron.WithLogger(cron.DefaultLogger))
-	for i := range config.Files {
-		file := &config.Files[i]
-		log.Default().Printf("register cron for file '%s' with interval '%s'\n", file.Path, file.Interval)
-		_, err := c.AddFunc(file.Interval, func() {
-			log.Default().Printf("running job for file '%s'", file.Path)
-			err := sendFileToArchivoServer(config.ArchiveServer, config.AgentName, config.AgentKey, file)
-			if err != nil {
-				log.Default().Printf("job fails. file: %s, error: [%s]", file.String(), err.Error())
-			}
-		})
+type uploadLimits struct {
+	Data struct {
+		MaxUploadSize int64 `json:"max_upload_size"`
+	} `json:"data"`
+}
 
-		if err != nil {
-			return nil, err
-		}
+func fetchUploadLimits(client *http.Client, config *Config, correlationId string) (*uploadLimits, error) {
+	requestUrl := fmt.Sprintf("%s%s", config.ArchiveServer, "/api/v1/servers/store/limits")
+
+	req, err := http.NewRequest(http.MethodGet, requestUrl, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Request-ID", correlationId)
+	if err := config.authorize(req, emptyBodyHash); err != nil {
+		return nil, err
+	}
+
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != 200 {
+		resBody, _ := io.ReadAll(res.Body)
+		return nil, fmt.Errorf("non 200 status code received. response: %s", resBody)
+	}
+
+	limits := uploadLimits{}
+	if err := json.NewDecoder(res.Body).Decode(&limits); err != nil {
+		return nil, err
 	}
-	c.Start()
-	return c, nil
+	return &limits, nil
 }
 
-func sendFileToArchivoServer(server, name, key string, file *File) error {
-	client := &http.Client{}
+func sendFileToArchivoServer(client *http.Client, config *Config, file *File) error {
 	correlationId := uuid.New().String()
 
-	log.Default().Printf("request-id:'%s', target-file: '%+v'\n", correlationId, file)
+	log.Default().Printf("request-id:'%s', target-file: '%s'\n", correlationId, file.String())
 
-	// read file
-	f, err := os.Open(file.Path)
+	if len(file.PreHook) != 0 {
+		if err := runHook("pre_hook", file.PreHook, file, correlationId); err != nil {
+			return fmt.Errorf("request-id:'%s', pre_hook fails, error: %s", correlationId, err.Error())
+		}
+	}
+	if len(file.PostHook) != 0 {
+		// post hook runs even if upload fails, e.g. to release a lock taken by pre hook
+		defer func() {
+			if err := runHook("post_hook", file.PostHook, file, correlationId); err != nil {
+				log.Default().Printf("request-id:'%s', post_hook fails, error: %s", correlationId, err.Error())
+			}
+		}()
+	}
+
+	// read file or output of command
+	var f *os.File
+	var err error
+	if len(file.Command) != 0 {
+		f, err = commandOutputFile(file, correlationId)
+		if f != nil {
+			defer os.Remove(f.Name())
+		}
+	} else {
+		f, err = os.Open(file.Path)
+	}
 	if err != nil {
 		return fmt.Errorf("request-id:'%s', error: %s", correlationId, err.Error())
 	}
 	defer f.Close()
 
-	body := &bytes.Buffer{}
-	writer := multipart.NewWriter(body)
-	if file.Filename != "" {
-		writer.WriteField("filename", file.Filename)
+	stat, err := f.Stat()
+	if err != nil {
+		return fmt.Errorf("request-id:'%s', error: %s", correlationId, err.Error())
 	}
-	writer.WriteField("rotate", strconv.FormatInt(file.Rotate, 10))
-	part, err := writer.CreateFormFile("file", filepath.Base(f.Name()))
+
+	// files bigger than server limit are rejected by server, so there is
+	// no reason to upload them
+	limits, err := fetchUploadLimits(client, config, correlationId)
 	if err != nil {
 		return fmt.Errorf("request-id:'%s', error: %s", correlationId, err.Error())
 	}
-	io.Copy(part, f)
-	writer.Close()
+	if limits.Data.MaxUploadSize > 0 && stat.Size() > limits.Data.MaxUploadSize {
+		return fmt.Errorf(
+			"request-id:'%s', error: file size %d is more than server max upload size %d",
+			correlationId,
+			stat.Size(),
+			limits.Data.MaxUploadSize,
+		)
+	}
 
-	requestUrl := fmt.Sprintf("%s%s", server, "/api/v1/servers/store/file")
+	// body is written with the same boundary and only the size of file at
+	// start, so it is the same every time it is written
+	metadata := fileMetadata(file, stat)
+	boundary := multipart.NewWriter(nil).Boundary()
+	newBodyWriter := func(w io.Writer) *multipart.Writer {
+		writer := multipart.NewWriter(w)
+		writer.SetBoundary(boundary)
+		return writer
+	}
+
+	bodyHash := ""
+	if config.signsRequests() {
+		// signed body is hashed before it is streamed
+		hash := sha256.New()
+		if err := writeMultipartFile(newBodyWriter(hash), file, io.NewSectionReader(f, 0, stat.Size()), metadata); err != nil {
+			return fmt.Errorf("request-id:'%s', error: %s", correlationId, err.Error())
+		}
+		bodyHash = hex.EncodeToString(hash.Sum(nil))
+	}
+
+	// multipart body is streamed to server, so file is not loaded into memory
+	body, bodyWriter := io.Pipe()
+	writer := newBodyWriter(bodyWriter)
+	go func() {
+		bodyWriter.CloseWithError(writeMultipartFile(writer, file, io.NewSectionReader(f, 0, stat.Size()), metadata))
+	}()
+
+	requestUrl := fmt.Sprintf("%s%s", config.ArchiveServer, "/api/v1/servers/store/file")
 
 	req, err := http.NewRequest(http.MethodPost, requestUrl, body)
 	if err != nil {
+		body.Close()
 		return fmt.Errorf("request-id:'%s', error: %s", correlationId, err.Error())
 	}
 
 	req.Header.Set("Content-Type", writer.FormDataContentType())
-	req.Header.Set("Authorization", key)
-	req.Header.Set("X-Agent1-Name", name)
 	req.Header.Set("X-Request-ID", correlationId)
+	if err := config.authorize(req, bodyHash); err != nil {
+		body.Close()
+		return fmt.Errorf("request-id:'%s', error: %s", correlationId, err.Error())
+	}
 
 	res, err := client.Do(req)
 	if err != nil {
 		return fmt.Errorf("request-id:'%s', error: %s", correlationId, err.Error())
 	}
+	defer res.Body.Close()
 
 	resBody, err := io.ReadAll(res.Body)
 	if err != nil {
@@ -97,3 +174,71 @@ func sendFileToArchivoServer(server, name, key string, file *File) error {
 
 	return nil
 }
+
+func writeMultipartFile(writer *multipart.Writer, file *File, content io.Reader, metadata map[string]string) error {
+	if file.Filename != "" {
+		if err := writer.WriteField("filename", file.Filename); err != nil {
+			return err
+		}
+	}
+	if err := writer.WriteField("rotate", strconv.FormatInt(file.Rotate, 10)); err != nil {
+		return err
+	}
+	// fields are written in order, so body is the same every time it is written
+	fields := make([]string, 0, len(metadata))
+	for field := range metadata {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	for _, field := range fields {
+		if err := writer.WriteField(field, metadata[field]); err != nil {
+			return err
+		}
+	}
+	part, err := writer.CreateFormFile("file", file.StoredFilename())
+	if err != nil {
+		return err
+	}
+	// checksum is sent along with file, so server can verify the received content
+	hash := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(part, hash), content); err != nil {
+		return err
+	}
+	if err := writer.WriteField("checksum", hex.EncodeToString(hash.Sum(nil))); err != nil {
+		return err
+	}
+	return writer.Close()
+}
+
+// fileMetadata collects metadata of file which is stored along with its
+// snapshot, so file can be restored faithfully
+func fileMetadata(file *File, stat os.FileInfo) map[string]string {
+	if len(file.Command) != 0 {
+		// output of command has no source file
+		metadata := map[string]string{}
+		if host, err := os.Hostname(); err == nil {
+			metadata["host"] = host
+		}
+		return metadata
+	}
+
+	metadata := map[string]string{
+		"path":  file.Path,
+		"mode":  fmt.Sprintf("%04o", stat.Mode().Perm()),
+		"mtime": strconv.FormatInt(stat.ModTime().UnixMilli(), 10),
+	}
+	if host, err := os.Hostname(); err == nil {
+		metadata["host"] = host
+	}
+	if uid, gid, ok := fileOwner(stat); ok {
+		metadata["uid"] = strconv.Itoa(uid)
+		metadata["gid"] = strconv.Itoa(gid)
+		if u, err := user.LookupId(strconv.Itoa(uid)); err == nil {
+			metadata["owner"] = u.Username
+		}
+		if g, err := user.LookupGroupId(strconv.Itoa(gid)); err == nil {
+			metadata["group"] = g.Name
+		}
+	}
+	return metadata
+}
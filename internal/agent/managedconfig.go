@@ -0,0 +1,95 @@
+package agent
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+
+	"github.com/google/uuid"
+)
+
+type managedConfigResponse struct {
+	Data struct {
+		Revision string `json:"revision"`
+		Files    []File `json:"files"`
+	} `json:"data"`
+}
+
+// fetchManagedConfig gets files of source server which are defined in panel.
+// revision which agent currently runs is sent, so server can show it
+func fetchManagedConfig(config *Config, appliedRevision string) (string, []File, error) {
+	correlationId := uuid.New().String()
+	client, err := config.httpClient()
+	if err != nil {
+		return "", nil, fmt.Errorf("request-id:'%s', error: %s", correlationId, err.Error())
+	}
+
+	query := url.Values{}
+	query.Set("revision", appliedRevision)
+	requestUrl := fmt.Sprintf("%s%s?%s", config.ArchiveServer, "/api/v1/servers/store/config", query.Encode())
+
+	req, err := http.NewRequest(http.MethodGet, requestUrl, nil)
+	if err != nil {
+		return "", nil, fmt.Errorf("request-id:'%s', error: %s", correlationId, err.Error())
+	}
+	req.Header.Set("X-Request-ID", correlationId)
+	if err := config.authorize(req, emptyBodyHash); err != nil {
+		return "", nil, fmt.Errorf("request-id:'%s', error: %s", correlationId, err.Error())
+	}
+
+	res, err := client.Do(req)
+	if err != nil {
+		return "", nil, fmt.Errorf("request-id:'%s', error: %s", correlationId, err.Error())
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != 200 {
+		resBody, _ := io.ReadAll(res.Body)
+		return "", nil, fmt.Errorf("request-id:'%s', error: non 200 status code received. response: %s", correlationId, resBody)
+	}
+
+	managed := managedConfigResponse{}
+	if err := json.NewDecoder(res.Body).Decode(&managed); err != nil {
+		return "", nil, fmt.Errorf("request-id:'%s', error: %s", correlationId, err.Error())
+	}
+	return managed.Data.Revision, managed.Data.Files, nil
+}
+
+// composeConfig returns local config with managed files merged into or
+// replacing its files. in merge mode, local files override managed files by
+// filename. managed files which are not valid on this host are skipped
+func composeConfig(local *Config, managedFiles []File) *Config {
+	if local.ManagedConfig == "" {
+		return local
+	}
+
+	composed := *local
+	composed.Files = []File{}
+	localFilenames := map[string]bool{}
+	if local.ManagedConfig == ManagedConfigMerge {
+		for _, file := range local.Files {
+			composed.Files = append(composed.Files, file)
+			localFilenames[file.StoredFilename()] = true
+		}
+	}
+
+	for _, file := range managedFiles {
+		if localFilenames[file.StoredFilename()] {
+			continue
+		}
+		if err := file.Validate(); err != nil {
+			log.Default().Printf("managed file '%s' is skipped, error: %s", file.String(), err.Error())
+			continue
+		}
+		if err := file.checkExistence(); err != nil {
+			log.Default().Printf("managed file '%s' is skipped, error: %s", file.String(), err.Error())
+			continue
+		}
+		composed.Files = append(composed.Files, file)
+	}
+
+	return &composed
+}
@@ -0,0 +1,301 @@
+package agent
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/spf13/cobra"
+)
+
+const (
+	snapshotNameHeader     = "X-Snapshot-Name"
+	snapshotChecksumHeader = "X-Snapshot-Checksum"
+	snapshotModeHeader     = "X-Snapshot-Mode"
+	snapshotUidHeader      = "X-Snapshot-Uid"
+	snapshotGidHeader      = "X-Snapshot-Gid"
+	snapshotMtimeHeader    = "X-Snapshot-Mtime"
+	restoreTempFilePrefix  = ".archivo-restore-"
+	backupTimeFormat       = "20060102150405"
+)
+
+// snapshotMetadata is metadata of source file which is recorded with snapshot
+type snapshotMetadata struct {
+	Mode    *os.FileMode
+	Uid     *int
+	Gid     *int
+	ModTime *time.Time
+}
+
+func parseSnapshotMetadata(header http.Header) snapshotMetadata {
+	metadata := snapshotMetadata{}
+	if mode, err := strconv.ParseUint(header.Get(snapshotModeHeader), 8, 32); err == nil {
+		fileMode := os.FileMode(mode).Perm()
+		metadata.Mode = &fileMode
+	}
+	if uid, err := strconv.Atoi(header.Get(snapshotUidHeader)); err == nil {
+		metadata.Uid = &uid
+	}
+	if gid, err := strconv.Atoi(header.Get(snapshotGidHeader)); err == nil {
+		metadata.Gid = &gid
+	}
+	if mtime, err := strconv.ParseInt(header.Get(snapshotMtimeHeader), 10, 64); err == nil {
+		modTime := time.UnixMilli(mtime)
+		metadata.ModTime = &modTime
+	}
+	return metadata
+}
+
+var restoreAgentCmd = &cobra.Command{
+	Use:   "restore",
+	Short: "Restore a snapshot of file from Archivo server to its original path",
+	Run: func(cmd *cobra.Command, _ []string) {
+		configPath, err := cmd.Flags().GetString("config")
+		if err != nil {
+			log.Fatalf(err.Error())
+		}
+		filename, err := cmd.Flags().GetString("file")
+		if err != nil {
+			log.Fatalf(err.Error())
+		}
+		snapshot, err := cmd.Flags().GetString("snapshot")
+		if err != nil {
+			log.Fatalf(err.Error())
+		}
+		atFlag, err := cmd.Flags().GetString("at")
+		if err != nil {
+			log.Fatalf(err.Error())
+		}
+
+		var at *time.Time
+		if atFlag != "" {
+			atTime, err := time.Parse(time.RFC3339, atFlag)
+			if err != nil {
+				log.Fatalf("invalid 'at' timestamp, it should be in RFC3339 format. error: %s", err.Error())
+			}
+			at = &atTime
+		}
+
+		parseAgentConfig(configPath)
+		if err := parsedConfig.ValidateForRestore(); err != nil {
+			log.Fatalf(err.Error())
+		}
+
+		var file *File
+		for i := range parsedConfig.Files {
+			if parsedConfig.Files[i].StoredFilename() == filename {
+				file = &parsedConfig.Files[i]
+				break
+			}
+		}
+		if file == nil {
+			log.Fatalf("no file by filename '%s' found in agent configuration", filename)
+		}
+
+		client, err := parsedConfig.httpClient()
+		if err != nil {
+			log.Fatalf(err.Error())
+		}
+		err = restoreFileFromArchivoServer(client, &parsedConfig, file, snapshot, at)
+		if err != nil {
+			log.Fatalf("restore fails. file: %s, error: [%s]", file.String(), err.Error())
+		}
+	},
+}
+
+func init() {
+	restoreAgentCmd.Flags().StringP(
+		"config",
+		"c",
+		"",
+		"path of agent1 config yaml file (default to $HOME/.agent.yaml)",
+	)
+	restoreAgentCmd.Flags().StringP("file", "f", "", "filename of file which should be restored")
+	restoreAgentCmd.Flags().StringP("snapshot", "s", "", "name of snapshot which should be restored (default to last snapshot)")
+	restoreAgentCmd.Flags().String("at", "", "restore last snapshot taken at or before this time. RFC3339 format, e.g. 2023-08-01T10:00:00Z")
+	restoreAgentCmd.MarkFlagRequired("file")
+	restoreAgentCmd.MarkFlagsMutuallyExclusive("snapshot", "at")
+}
+
+func restoreFileFromArchivoServer(client *http.Client, config *Config, file *File, snapshot string, at *time.Time) error {
+	correlationId := uuid.New().String()
+
+	log.Default().Printf("request-id:'%s', restore-file: '%s'\n", correlationId, file.String())
+
+	if file.Path == "" {
+		return fmt.Errorf("request-id:'%s', error: file '%s' is output of command and has no path to restore to", correlationId, file.StoredFilename())
+	}
+
+	query := url.Values{}
+	query.Set("filename", file.StoredFilename())
+	if snapshot != "" {
+		query.Set("snapshot", snapshot)
+	}
+	if at != nil {
+		query.Set("at", strconv.FormatInt(at.UnixMilli(), 10))
+	}
+	requestUrl := fmt.Sprintf("%s%s?%s", config.ArchiveServer, "/api/v1/servers/store/file", query.Encode())
+
+	req, err := http.NewRequest(http.MethodGet, requestUrl, nil)
+	if err != nil {
+		return fmt.Errorf("request-id:'%s', error: %s", correlationId, err.Error())
+	}
+
+	req.Header.Set("X-Request-ID", correlationId)
+	if err := config.authorize(req, emptyBodyHash); err != nil {
+		return fmt.Errorf("request-id:'%s', error: %s", correlationId, err.Error())
+	}
+
+	res, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("request-id:'%s', error: %s", correlationId, err.Error())
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != 200 {
+		resBody, _ := io.ReadAll(res.Body)
+		return fmt.Errorf("request-id:'%s', error: %s",
+			correlationId,
+			fmt.Sprintf(
+				"non 200 status code received. response: %s",
+				resBody,
+			),
+		)
+	}
+
+	snapshotName := res.Header.Get(snapshotNameHeader)
+	log.Default().Printf("request-id:'%s', restoring snapshot '%s' to '%s'\n", correlationId, snapshotName, file.Path)
+
+	metadata := parseSnapshotMetadata(res.Header)
+	if err := restoreFile(file.Path, res.Body, res.Header.Get(snapshotChecksumHeader), metadata); err != nil {
+		return fmt.Errorf("request-id:'%s', error: %s", correlationId, err.Error())
+	}
+
+	log.Default().Printf("request-id:'%s', snapshot '%s' restored to '%s'\n", correlationId, snapshotName, file.Path)
+	return nil
+}
+
+// restoreFile writes content to a temporary file next to target and verifies
+// it by checksum. then current file is backed up and replaced by temporary
+// file in a single rename, so target is never left half written. mode and
+// ownership of current file are kept, recorded ones are used if it not exists
+func restoreFile(target string, content io.Reader, checksum string, metadata snapshotMetadata) error {
+	// in case of symlink, file which it points to is restored
+	if resolved, err := filepath.EvalSymlinks(target); err == nil {
+		target = resolved
+	}
+	dir := filepath.Dir(target)
+
+	tmp, err := os.CreateTemp(dir, restoreTempFilePrefix)
+	if err != nil {
+		return err
+	}
+	tmpPath := tmp.Name()
+	defer os.Remove(tmpPath)
+
+	hash := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(tmp, hash), content); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	received := hex.EncodeToString(hash.Sum(nil))
+	if checksum == "" || received != checksum {
+		return fmt.Errorf("checksum mismatch. expected: '%s', received: '%s'", checksum, received)
+	}
+
+	mode := os.FileMode(0644)
+	if metadata.Mode != nil {
+		mode = *metadata.Mode
+	}
+	info, err := os.Stat(target)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err == nil {
+		mode = info.Mode().Perm()
+		backupPath, err := backupFile(target, info)
+		if err != nil {
+			return fmt.Errorf("unable to backup current file: %s", err.Error())
+		}
+		log.Default().Printf("current file '%s' is backed up to '%s'\n", target, backupPath)
+	}
+
+	if err := os.Chmod(tmpPath, mode); err != nil {
+		return err
+	}
+	if info != nil {
+		if err := chownLike(tmpPath, info); err != nil {
+			return err
+		}
+	} else if metadata.Uid != nil && metadata.Gid != nil {
+		if err := os.Lchown(tmpPath, *metadata.Uid, *metadata.Gid); err != nil {
+			log.Default().Printf("unable to set recorded owner of '%s', error: %s\n", target, err.Error())
+		}
+	}
+	if metadata.ModTime != nil {
+		if err := os.Chtimes(tmpPath, time.Now(), *metadata.ModTime); err != nil {
+			return err
+		}
+	}
+
+	if err := os.Rename(tmpPath, target); err != nil {
+		return err
+	}
+	syncDir(dir)
+
+	return nil
+}
+
+func backupFile(target string, info os.FileInfo) (string, error) {
+	backupPath := fmt.Sprintf("%s.archivo-backup-%s", target, time.Now().Format(backupTimeFormat))
+
+	src, err := os.Open(target)
+	if err != nil {
+		return "", err
+	}
+	defer src.Close()
+
+	dst, err := os.OpenFile(backupPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, info.Mode().Perm())
+	if err != nil {
+		return "", err
+	}
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		return "", err
+	}
+	if err := dst.Sync(); err != nil {
+		dst.Close()
+		return "", err
+	}
+	if err := dst.Close(); err != nil {
+		return "", err
+	}
+
+	return backupPath, chownLike(backupPath, info)
+}
+
+func syncDir(dir string) {
+	d, err := os.Open(dir)
+	if err != nil {
+		return
+	}
+	defer d.Close()
+	d.Sync()
+}
@@ -0,0 +1,161 @@
+package agent
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type restoreJob struct {
+	ID       uint   `json:"id"`
+	Filename string `json:"filename"`
+	Snapshot string `json:"snapshot"`
+}
+
+type nextRestoreJobResponse struct {
+	Data struct {
+		Job *restoreJob `json:"job"`
+	} `json:"data"`
+}
+
+type restoreJobReport struct {
+	Succeeded bool   `json:"succeeded"`
+	Message   string `json:"message"`
+}
+
+// startRestoreJobPoller asks server for restore jobs which are queued from
+// panel and applies them like a local restore. returned function stops it
+func startRestoreJobPoller(config *Config) func() {
+	interval := config.RestorePollDuration()
+	if interval == 0 {
+		log.Default().Println("restore job polling is disabled")
+		return func() {}
+	}
+
+	log.Default().Printf("polling restore jobs every '%s'\n", interval)
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				if err := runNextRestoreJob(config); err != nil {
+					log.Default().Printf("restore job fails. error: [%s]", err.Error())
+				}
+			}
+		}
+	}()
+
+	return func() {
+		close(done)
+	}
+}
+
+func runNextRestoreJob(config *Config) error {
+	correlationId := uuid.New().String()
+	client, err := config.httpClient()
+	if err != nil {
+		return fmt.Errorf("request-id:'%s', error: %s", correlationId, err.Error())
+	}
+
+	job, err := fetchNextRestoreJob(client, config, correlationId)
+	if err != nil {
+		return fmt.Errorf("request-id:'%s', error: %s", correlationId, err.Error())
+	}
+	if job == nil {
+		return nil
+	}
+
+	log.Default().Printf("request-id:'%s', restore job '%d' received for file '%s' snapshot '%s'\n", correlationId, job.ID, job.Filename, job.Snapshot)
+
+	report := restoreJobReport{Succeeded: true, Message: "restored"}
+	var file *File
+	for i := range config.Files {
+		if config.Files[i].StoredFilename() == job.Filename {
+			file = &config.Files[i]
+			break
+		}
+	}
+	if file == nil {
+		report = restoreJobReport{Message: fmt.Sprintf("no file by filename '%s' found in agent configuration", job.Filename)}
+	} else if err := restoreFileFromArchivoServer(client, config, file, job.Snapshot, nil); err != nil {
+		report = restoreJobReport{Message: err.Error()}
+	}
+
+	if err := sendRestoreJobReport(client, config, correlationId, job.ID, report); err != nil {
+		return fmt.Errorf("request-id:'%s', error: %s", correlationId, err.Error())
+	}
+
+	log.Default().Printf("request-id:'%s', restore job '%d' reported. succeeded: %t, message: %s\n", correlationId, job.ID, report.Succeeded, report.Message)
+	return nil
+}
+
+func fetchNextRestoreJob(client *http.Client, config *Config, correlationId string) (*restoreJob, error) {
+	requestUrl := fmt.Sprintf("%s%s", config.ArchiveServer, "/api/v1/servers/store/restore-jobs/next")
+
+	req, err := http.NewRequest(http.MethodGet, requestUrl, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Request-ID", correlationId)
+	if err := config.authorize(req, emptyBodyHash); err != nil {
+		return nil, err
+	}
+
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != 200 {
+		resBody, _ := io.ReadAll(res.Body)
+		return nil, fmt.Errorf("non 200 status code received. response: %s", resBody)
+	}
+
+	next := nextRestoreJobResponse{}
+	if err := json.NewDecoder(res.Body).Decode(&next); err != nil {
+		return nil, err
+	}
+	return next.Data.Job, nil
+}
+
+func sendRestoreJobReport(client *http.Client, config *Config, correlationId string, jobId uint, report restoreJobReport) error {
+	requestUrl := fmt.Sprintf("%s/api/v1/servers/store/restore-jobs/%d/report", config.ArchiveServer, jobId)
+
+	body, err := json.Marshal(report)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, requestUrl, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Request-ID", correlationId)
+	if err := config.authorize(req, hashBody(body)); err != nil {
+		return err
+	}
+
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != 200 {
+		resBody, _ := io.ReadAll(res.Body)
+		return fmt.Errorf("non 200 status code received. response: %s", resBody)
+	}
+	return nil
+}
@@ -0,0 +1,261 @@
+package agent
+
+import (
+	"log"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ARTM2000/archivo/internal/config"
+	"github.com/robfig/cron/v3"
+)
+
+// agentRunner runs upload jobs of configured files and applies new
+// configuration to them without restart. config is the local config composed
+// with managed files
+type agentRunner struct {
+	mu         sync.Mutex
+	configPath string
+	local      *Config
+	config     *Config
+	cron       *cron.Cron
+	jobs       map[string]*fileJob
+	entries    map[string]cron.EntryID
+
+	managedFiles    []File
+	managedRevision string
+	appliedRevision string
+
+	stopFileWatcher    func()
+	stopRestorePoller  func()
+	stopManagedRefresh func()
+	stopHeartbeat      func()
+}
+
+func startAgentRunner(configPath string, localConfig *Config) (*agentRunner, error) {
+	r := &agentRunner{
+		configPath: configPath,
+		local:      localConfig,
+		config:     localConfig,
+		cron:       cron.New(cron.WithLogger(cron.DefaultLogger)),
+		jobs:       map[string]*fileJob{},
+		entries:    map[string]cron.EntryID{},
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if localConfig.ManagedConfig != "" {
+		r.refreshManagedFiles()
+	}
+	if err := r.apply(composeConfig(localConfig, r.managedFiles)); err != nil {
+		return nil, err
+	}
+	r.appliedRevision = r.managedRevision
+	r.cron.Start()
+	r.startManagedRefresh()
+	return r, nil
+}
+
+// refreshManagedFiles fetches managed files from server. last fetched files
+// are kept if server is not available
+func (r *agentRunner) refreshManagedFiles() bool {
+	revision, files, err := fetchManagedConfig(r.local, r.appliedRevision)
+	if err != nil {
+		log.Default().Printf("unable to fetch managed config, error: %s", err.Error())
+		return false
+	}
+	if revision == r.managedRevision {
+		return false
+	}
+
+	log.Default().Printf("managed config revision '%s' received with %d files\n", revision, len(files))
+	r.managedRevision = revision
+	r.managedFiles = files
+	return true
+}
+
+func (r *agentRunner) startManagedRefresh() {
+	if r.local.ManagedConfig == "" {
+		r.stopManagedRefresh = func() {}
+		return
+	}
+
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(r.local.ManagedConfigDuration())
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				r.mu.Lock()
+				if r.refreshManagedFiles() {
+					if err := r.apply(composeConfig(r.local, r.managedFiles)); err != nil {
+						log.Default().Printf("unable to apply managed config, current configuration is kept: %s", err.Error())
+					} else {
+						r.appliedRevision = r.managedRevision
+					}
+				}
+				r.mu.Unlock()
+			}
+		}
+	}()
+	r.stopManagedRefresh = func() {
+		close(done)
+	}
+}
+
+// apply schedules files of config. files are matched with running jobs by
+// their filename, so only added, removed and changed files are rescheduled.
+// schedules are parsed before any change, so running jobs are kept as they
+// are if config can not be applied
+func (r *agentRunner) apply(agentConfig *Config) error {
+	connectionChanged := r.config.ArchiveServer != agentConfig.ArchiveServer ||
+		r.config.AgentName != agentConfig.AgentName ||
+		r.config.AgentKey != agentConfig.AgentKey ||
+		r.config.LegacyAuth != agentConfig.LegacyAuth ||
+		r.config.TLSCert != agentConfig.TLSCert ||
+		r.config.TLSKey != agentConfig.TLSKey ||
+		r.config.CAFile != agentConfig.CAFile
+
+	files := map[string]*File{}
+	schedules := map[string]cron.Schedule{}
+	for i := range agentConfig.Files {
+		file := &agentConfig.Files[i]
+		schedule, err := cron.ParseStandard(file.Interval)
+		if err != nil {
+			return err
+		}
+		files[file.StoredFilename()] = file
+		schedules[file.StoredFilename()] = schedule
+	}
+
+	for key, job := range r.jobs {
+		file, exists := files[key]
+		if exists && !connectionChanged && reflect.DeepEqual(*job.file, *file) {
+			continue
+		}
+		r.cron.Remove(r.entries[key])
+		job.stop()
+		delete(r.jobs, key)
+		delete(r.entries, key)
+		if !exists {
+			log.Default().Printf("file '%s' removed from schedule\n", key)
+		}
+	}
+
+	for key, file := range files {
+		if _, exists := r.jobs[key]; exists {
+			continue
+		}
+		job := &fileJob{config: agentConfig, file: file}
+		r.entries[key] = r.cron.Schedule(schedules[key], cron.FuncJob(job.run))
+		r.jobs[key] = job
+		log.Default().Printf("register cron for file '%s' with interval '%s'\n", key, file.Interval)
+	}
+	r.config = agentConfig
+
+	// watcher and poller are restarted by new jobs and config
+	if r.stopFileWatcher != nil {
+		r.stopFileWatcher()
+	}
+	jobs := []*fileJob{}
+	for _, job := range r.jobs {
+		jobs = append(jobs, job)
+	}
+	stopFileWatcher, err := startFileWatcher(jobs)
+	if err != nil {
+		log.Default().Printf("unable to watch files, only cron uploads are running. error: %s", err.Error())
+		stopFileWatcher = func() {}
+	}
+	r.stopFileWatcher = stopFileWatcher
+
+	if r.stopRestorePoller != nil {
+		r.stopRestorePoller()
+	}
+	r.stopRestorePoller = startRestoreJobPoller(agentConfig)
+
+	if r.stopHeartbeat != nil {
+		r.stopHeartbeat()
+	}
+	r.stopHeartbeat = startHeartbeat(agentConfig, r.heartbeat)
+
+	return nil
+}
+
+// heartbeat collects configured files with their schedule and last upload
+// result
+func (r *agentRunner) heartbeat() heartbeat {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	hb := newHeartbeat(r.appliedRevision)
+	for key, job := range r.jobs {
+		file := job.state()
+		if next := r.cron.Entry(r.entries[key]).Next; !next.IsZero() {
+			file.NextRun = &next
+		}
+		if file.Pending {
+			hb.PendingUploads++
+		}
+		if file.Running {
+			hb.RunningUploads++
+		}
+		hb.Files = append(hb.Files, file)
+	}
+	sort.Slice(hb.Files, func(i, j int) bool {
+		return hb.Files[i].Filename < hb.Files[j].Filename
+	})
+	return hb
+}
+
+// reload reads configuration file again and applies it. current config is
+// kept if the new one is not valid
+func (r *agentRunner) reload() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	log.Default().Printf("reloading configuration from '%s'\n", r.configPath)
+
+	newConfig := Config{}
+	if err := config.Parse[Config](r.configPath, &newConfig); err != nil {
+		log.Default().Printf("error on reading configuration, current configuration is kept: %s", err.Error())
+		return
+	}
+	if err := newConfig.Validate(); err != nil {
+		log.Default().Printf("new configuration is not valid, current configuration is kept: %s", err.Error())
+		return
+	}
+	prevLocal := r.local
+	r.local = &newConfig
+	if newConfig.ManagedConfig != "" && prevLocal.ManagedConfig == "" {
+		r.refreshManagedFiles()
+	}
+	composed := composeConfig(&newConfig, r.managedFiles)
+	if err := r.apply(composed); err != nil {
+		r.local = prevLocal
+		log.Default().Printf("unable to apply new configuration, current configuration is kept: %s", err.Error())
+		return
+	}
+	r.appliedRevision = r.managedRevision
+
+	r.stopManagedRefresh()
+	r.startManagedRefresh()
+	log.Default().Println("configuration reloaded:", composed.String())
+}
+
+func (r *agentRunner) stop() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.cron.Stop()
+	r.stopManagedRefresh()
+	r.stopFileWatcher()
+	r.stopRestorePoller()
+	r.stopHeartbeat()
+	for _, job := range r.jobs {
+		job.stop()
+	}
+}
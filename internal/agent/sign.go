@@ -0,0 +1,77 @@
+package agent
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	timestampHeader     = "X-Archivo-Timestamp"
+	nonceHeader         = "X-Archivo-Nonce"
+	contentSHA256Header = "X-Archivo-Content-SHA256"
+	signatureHeader     = "X-Archivo-Signature"
+)
+
+// emptyBodyHash is sha256 of requests without body
+var emptyBodyHash = hashBody(nil)
+
+func hashBody(body []byte) string {
+	sum := sha256.Sum256(body)
+	return hex.EncodeToString(sum[:])
+}
+
+// authorize sets authorization headers of agent on request. request is signed
+// by a key which is derived from agent key, so agent key is never sent and a
+// captured request can not be replayed. bodyHash is sha256 of request body in
+// hex
+func (c *Config) authorize(req *http.Request, bodyHash string) error {
+	req.Header.Set("X-Agent1-Name", c.AgentName)
+	if !c.signsRequests() {
+		if c.AgentKey != "" {
+			req.Header.Set("Authorization", c.AgentKey)
+		}
+		// otherwise agent is authorized by its client certificate
+		return nil
+	}
+
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	nonceHex := hex.EncodeToString(nonce)
+
+	req.Header.Set(timestampHeader, timestamp)
+	req.Header.Set(nonceHeader, nonceHex)
+	req.Header.Set(contentSHA256Header, bodyHash)
+	req.Header.Set(signatureHeader, signRequest(c.AgentKey, req.Method, req.URL.RequestURI(), timestamp, nonceHex, bodyHash))
+	return nil
+}
+
+func (c *Config) signsRequests() bool {
+	return c.AgentKey != "" && !c.LegacyAuth
+}
+
+// requestSigningLabel derives signing key from agent key. server derives the
+// same key when api key is created, apart from the hash of api key which it
+// verifies unsigned requests by
+const requestSigningLabel = "archivo-request-signing"
+
+func requestSigningKey(agentKey string) []byte {
+	mac := hmac.New(sha256.New, []byte(agentKey))
+	mac.Write([]byte(requestSigningLabel))
+	return mac.Sum(nil)
+}
+
+// signRequest signs request by the key which is derived from agent key
+func signRequest(agentKey, method, uri, timestamp, nonce, bodyHash string) string {
+	mac := hmac.New(sha256.New, requestSigningKey(agentKey))
+	mac.Write([]byte(strings.Join([]string{method, uri, timestamp, nonce, bodyHash}, "\n")))
+	return hex.EncodeToString(mac.Sum(nil))
+}
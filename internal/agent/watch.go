@@ -0,0 +1,215 @@
+package agent
+
+import (
+	"log"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+const (
+	TriggerCron  = "cron"
+	TriggerWatch = "watch"
+)
+
+// fileJob uploads a file to archivo server. uploads of the same file never run
+// at the same time, whether they are triggered by cron or by file changes
+type fileJob struct {
+	config *Config
+	file   *File
+
+	runMu       sync.Mutex
+	mu          sync.Mutex
+	lastRun     time.Time
+	lastSuccess time.Time
+	lastError   string
+	running     bool
+	pending     bool
+	timer       *time.Timer
+}
+
+func (j *fileJob) run() {
+	j.runMu.Lock()
+	defer j.runMu.Unlock()
+
+	j.mu.Lock()
+	j.lastRun = time.Now()
+	j.running = true
+	j.pending = false
+	j.mu.Unlock()
+
+	log.Default().Printf("running job for file '%s'", j.file.StoredFilename())
+	client, err := j.config.httpClient()
+	if err == nil {
+		err = sendFileToArchivoServer(client, j.config, j.file)
+	}
+
+	j.mu.Lock()
+	j.running = false
+	if err != nil {
+		j.lastError = err.Error()
+	} else {
+		j.lastError = ""
+		j.lastSuccess = time.Now()
+	}
+	j.mu.Unlock()
+
+	if err != nil {
+		log.Default().Printf("job fails. file: %s, error: [%s]", j.file.String(), err.Error())
+	}
+}
+
+// notify schedules an upload after file is changed. upload waits until file
+// is not changed for debounce duration, and until min interval is passed
+// since the last upload
+func (j *fileJob) notify() {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	delay := j.file.DebounceDuration()
+	if untilNext := time.Until(j.lastRun.Add(j.file.MinIntervalDuration())); untilNext > delay {
+		delay = untilNext
+	}
+
+	if j.timer != nil {
+		j.timer.Stop()
+	}
+	j.timer = time.AfterFunc(delay, j.run)
+	j.pending = true
+}
+
+func (j *fileJob) stop() {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if j.timer != nil {
+		j.timer.Stop()
+	}
+	j.pending = false
+}
+
+// startFileWatcher uploads files with watch trigger shortly after they are
+// changed. directory of each file is watched instead of the file itself, so
+// editors which replace file by renaming a new one over it are handled too.
+// returned function stops it
+func startFileWatcher(jobs []*fileJob) (func(), error) {
+	jobsByPath := map[string][]*fileJob{}
+	for _, job := range jobs {
+		if job.file.Trigger != TriggerWatch {
+			continue
+		}
+		path := filepath.Clean(job.file.Path)
+		jobsByPath[path] = append(jobsByPath[path], job)
+	}
+	if len(jobsByPath) == 0 {
+		return func() {}, nil
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+
+	watchedDirs := map[string]bool{}
+	for path := range jobsByPath {
+		dir := filepath.Dir(path)
+		if watchedDirs[dir] {
+			continue
+		}
+		if err := watcher.Add(dir); err != nil {
+			watcher.Close()
+			return nil, err
+		}
+		watchedDirs[dir] = true
+		log.Default().Printf("watching directory '%s' for file changes\n", dir)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				// removed or renamed files are uploaded when they are created again
+				if !event.Has(fsnotify.Write) && !event.Has(fsnotify.Create) {
+					continue
+				}
+				for _, job := range jobsByPath[filepath.Clean(event.Name)] {
+					job.notify()
+				}
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				log.Default().Printf("file watcher error: %s", err.Error())
+			}
+		}
+	}()
+
+	return func() {
+		close(done)
+		watcher.Close()
+	}, nil
+}
+
+// watchConfigFile calls onChange after config file is changed. like watched
+// files, directory of config file is watched
+func watchConfigFile(configPath string, onChange func()) (func(), error) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	configPath = filepath.Clean(configPath)
+	if err := watcher.Add(filepath.Dir(configPath)); err != nil {
+		watcher.Close()
+		return nil, err
+	}
+	log.Default().Printf("watching config file '%s' for changes\n", configPath)
+
+	var mu sync.Mutex
+	var timer *time.Timer
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if filepath.Clean(event.Name) != configPath ||
+					(!event.Has(fsnotify.Write) && !event.Has(fsnotify.Create)) {
+					continue
+				}
+				mu.Lock()
+				if timer != nil {
+					timer.Stop()
+				}
+				timer = time.AfterFunc(defaultDebounce, onChange)
+				mu.Unlock()
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				log.Default().Printf("config watcher error: %s", err.Error())
+			}
+		}
+	}()
+
+	return func() {
+		close(done)
+		watcher.Close()
+		mu.Lock()
+		if timer != nil {
+			timer.Stop()
+		}
+		mu.Unlock()
+	}, nil
+}
@@ -0,0 +1,542 @@
+package archive
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/ARTM2000/archivo/internal/archive/auth"
+	"github.com/ARTM2000/archivo/internal/archive/sourceserver"
+	"github.com/ARTM2000/archivo/internal/archive/xerrors"
+	"github.com/ARTM2000/archivo/internal/validate"
+	"github.com/spf13/cobra"
+	"gorm.io/gorm"
+)
+
+const (
+	outputTable = "table"
+	outputJSON  = "json"
+)
+
+// adminCommandDB reads config of command and connects to its database. logs
+// of database go to stderr, so output of command can be parsed by scripts
+func adminCommandDB(cmd *cobra.Command) *gorm.DB {
+	configPath, err := cmd.Flags().GetString("config")
+	if err != nil {
+		log.Fatalln(err.Error())
+	}
+	// output is checked before anything is done
+	commandOutput(cmd)
+	archiveConfigPreProcess(configPath)
+
+	dbConfig := NewDBConfig(&parsedConfig)
+	dbConfig.DBQuiet = true
+	return NewDBConnection(dbConfig)
+}
+
+func adminSrvConfig() sourceserver.SrvConfig {
+	return sourceserver.SrvConfig{
+		StoreMode:         parsedConfig.FileStore.Mode,
+		DiskStoreConfig:   sourceserver.DiskStoreConfig(parsedConfig.FileStore.DiskConfig),
+		DefaultQuota:      sourceserver.Quota(parsedConfig.DefaultQuota),
+		DefaultMaxUpload:  parsedConfig.Upload.MaxUploadSize(),
+		AgentOfflineAfter: parsedConfig.AgentOfflineAfter,
+	}
+}
+
+func adminSrvManager(db *gorm.DB) sourceserver.SrvManager {
+	return sourceserver.NewSrvManager(adminSrvConfig(), sourceserver.NewSrvRepository(db))
+}
+
+// adminKeySrvManager is adminSrvManager along with server signing key, which
+// commands that create api keys need to wrap request signing key of agent
+func adminKeySrvManager(db *gorm.DB) sourceserver.SrvManager {
+	config := adminSrvConfig()
+	signingKey, err := loadSigningKey(&parsedConfig)
+	if err != nil {
+		log.Fatalln("error in loading signing key.", err.Error())
+	}
+	config.SigningKey = signingKey
+	return sourceserver.NewSrvManager(config, sourceserver.NewSrvRepository(db))
+}
+
+func commandOutput(cmd *cobra.Command) string {
+	output, err := cmd.Flags().GetString("output")
+	if err != nil {
+		log.Fatalln(err.Error())
+	}
+	if output != outputTable && output != outputJSON {
+		log.Fatalf("output should be one of '%s' or '%s'\n", outputTable, outputJSON)
+	}
+	return output
+}
+
+// printOutput prints value as json or rows as a table, by output of command
+func printOutput(cmd *cobra.Command, value interface{}, header []string, rows [][]string) {
+	if commandOutput(cmd) == outputJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(value); err != nil {
+			log.Fatalln(err.Error())
+		}
+		return
+	}
+
+	writer := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(writer, strings.Join(header, "\t"))
+	for _, row := range rows {
+		fmt.Fprintln(writer, strings.Join(row, "\t"))
+	}
+	writer.Flush()
+}
+
+func formatTime(t *time.Time) string {
+	if t == nil || t.IsZero() {
+		return "-"
+	}
+	return t.Format(time.RFC3339)
+}
+
+// generatePassword generates an initial password, which user should change
+// on first login
+func generatePassword() string {
+	const (
+		passwordLength = 16
+		letters        = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+	)
+	password := make([]byte, passwordLength)
+	for i := range password {
+		n, err := rand.Int(rand.Reader, big.NewInt(int64(len(letters))))
+		if err != nil {
+			log.Fatalln("error in generating password.", err.Error())
+		}
+		password[i] = letters[n.Int64()]
+	}
+	return string(password)
+}
+
+// initialPasswordDto validates initial passwords as registerUserDto does
+type initialPasswordDto struct {
+	Password string `validate:"required,alphanum,min=8"`
+}
+
+type adminUser struct {
+	*auth.User
+	// set only when password is generated
+	InitialPassword string `json:"initial_password,omitempty"`
+}
+
+func userRows(users ...auth.User) [][]string {
+	rows := [][]string{}
+	for _, u := range users {
+		rows = append(rows, []string{
+			fmt.Sprint(u.ID),
+			u.Username,
+			u.Email,
+			fmt.Sprint(u.IsAdmin),
+			fmt.Sprint(u.ChangeInitialPassword),
+			formatTime(&u.LastLoginAt),
+			formatTime(&u.CreatedAt),
+		})
+	}
+	return rows
+}
+
+var userHeader = []string{"ID", "USERNAME", "EMAIL", "ADMIN", "CHANGE PASSWORD", "LAST LOGIN", "CREATED"}
+
+func printAdminUser(cmd *cobra.Command, user adminUser) {
+	printOutput(cmd, user, userHeader, userRows(*user.User))
+	if user.InitialPassword != "" && commandOutput(cmd) == outputTable {
+		fmt.Printf("\ninitial password: %s\n", user.InitialPassword)
+	}
+}
+
+var userCmd = &cobra.Command{
+	Use:   "user",
+	Short: "Manage dashboard users",
+}
+
+var userCreateCmd = &cobra.Command{
+	Use:   "create",
+	Short: "Create a dashboard user. password of non admin users is generated when it is not set",
+	Run: func(cmd *cobra.Command, _ []string) {
+		email, err := cmd.Flags().GetString("email")
+		if err != nil {
+			log.Fatalln(err.Error())
+		}
+		username, err := cmd.Flags().GetString("username")
+		if err != nil {
+			log.Fatalln(err.Error())
+		}
+		password, err := cmd.Flags().GetString("password")
+		if err != nil {
+			log.Fatalln(err.Error())
+		}
+		isAdmin, err := cmd.Flags().GetBool("admin")
+		if err != nil {
+			log.Fatalln(err.Error())
+		}
+
+		generated := ""
+		if password == "" && !isAdmin {
+			generated = generatePassword()
+			password = generated
+		}
+		if isAdmin {
+			data := registerAdminDto{Email: email, Username: username, Password: password}
+			if errs, ok := validate.ValidateStruct[registerAdminDto](&data); !ok {
+				log.Fatalln(errs[0].Message)
+			}
+		} else {
+			data := registerUserDto{Email: email, Username: username, Password: password}
+			if errs, ok := validate.ValidateStruct[registerUserDto](&data); !ok {
+				log.Fatalln(errs[0].Message)
+			}
+		}
+
+		userManager := auth.NewUserManager(auth.UserConfig{}, auth.NewUserRepository(adminCommandDB(cmd)))
+		var user *auth.User
+		if isAdmin {
+			user, err = userManager.RegisterAdmin(email, username, password)
+		} else {
+			user, err = userManager.RegisterUser(email, username, password)
+		}
+		if err != nil {
+			log.Fatalln("error in creating user.", err.Error())
+		}
+
+		printAdminUser(cmd, adminUser{User: user, InitialPassword: generated})
+	},
+}
+
+var userListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List dashboard users",
+	Run: func(cmd *cobra.Command, _ []string) {
+		userManager := auth.NewUserManager(auth.UserConfig{}, auth.NewUserRepository(adminCommandDB(cmd)))
+		users, _, err := userManager.GetAllUsers(auth.FindAllOption{
+			SortBy:    "id",
+			SortOrder: "ASC",
+			Start:     0,
+			End:       -1,
+		})
+		if err != nil {
+			log.Fatalln("error in finding users.", err.Error())
+		}
+
+		printOutput(cmd, users, userHeader, userRows(*users...))
+	},
+}
+
+var userResetPasswordCmd = &cobra.Command{
+	Use:   "reset-password <email-or-username>",
+	Short: "Set a new initial password for user, which user should change on next login",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		password, err := cmd.Flags().GetString("password")
+		if err != nil {
+			log.Fatalln(err.Error())
+		}
+
+		generated := ""
+		if password == "" {
+			generated = generatePassword()
+			password = generated
+		}
+		data := initialPasswordDto{Password: password}
+		if errs, ok := validate.ValidateStruct[initialPasswordDto](&data); !ok {
+			log.Fatalln(errs[0].Message)
+		}
+
+		userManager := auth.NewUserManager(auth.UserConfig{}, auth.NewUserRepository(adminCommandDB(cmd)))
+		user, err := userManager.ResetPassword(args[0], password)
+		if err != nil {
+			if errors.Is(err, xerrors.ErrRecordNotFound) {
+				log.Fatalf("no user with email or username '%s' found\n", args[0])
+			}
+			log.Fatalln("error in resetting password.", err.Error())
+		}
+
+		printAdminUser(cmd, adminUser{User: user, InitialPassword: generated})
+	},
+}
+
+var userDisableCmd = &cobra.Command{
+	Use:   "disable <email-or-username>",
+	Short: "Disable a non admin user, so user can not login anymore",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		userManager := auth.NewUserManager(auth.UserConfig{}, auth.NewUserRepository(adminCommandDB(cmd)))
+		user, err := userManager.DisableUser(args[0])
+		if err != nil {
+			if errors.Is(err, xerrors.ErrRecordNotFound) {
+				log.Fatalf("no user with email or username '%s' found\n", args[0])
+			}
+			log.Fatalln("error in disabling user.", err.Error())
+		}
+
+		printAdminUser(cmd, adminUser{User: user})
+	},
+}
+
+var userTokenCmd = &cobra.Command{
+	Use:   "token <email-or-username>",
+	Short: "Issue a personal access token of user for clients like archivo-cli",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		ttl, err := cmd.Flags().GetDuration("ttl")
+		if err != nil {
+			log.Fatalln(err.Error())
+		}
+		if ttl <= 0 {
+			log.Fatalln("ttl should be a positive duration")
+		}
+
+		db := adminCommandDB(cmd)
+		userManager := auth.NewUserManager(
+			auth.UserConfig{
+				JWTSecret:     parsedConfig.Auth.JWTSecret,
+				JWTExpireTime: parsedConfig.Auth.JWTExpireTime,
+			},
+			auth.NewUserRepository(db),
+		)
+		user, err := userManager.FindUser(args[0])
+		if err != nil {
+			if errors.Is(err, xerrors.ErrRecordNotFound) {
+				log.Fatalf("no user with email or username '%s' found\n", args[0])
+			}
+			log.Fatalln("error in finding user.", err.Error())
+		}
+		if user.ChangeInitialPassword {
+			log.Fatalf("user '%s' should change initial password first\n", user.Username)
+		}
+		token, err := userManager.IssueAccessToken(user, ttl)
+		if err != nil {
+			log.Fatalln("error in issuing token.", err.Error())
+		}
+
+		expiresAt := time.Now().Add(ttl)
+		printOutput(
+			cmd,
+			map[string]interface{}{
+				"username":   user.Username,
+				"token":      token,
+				"expires_at": expiresAt,
+			},
+			[]string{"USERNAME", "EXPIRES", "TOKEN"},
+			[][]string{{user.Username, formatTime(&expiresAt), token}},
+		)
+	},
+}
+
+type adminSourceServer struct {
+	*sourceserver.SourceServer
+	APIKey string `json:"api_key"`
+}
+
+var serverCmd = &cobra.Command{
+	Use:   "server",
+	Short: "Manage source servers",
+}
+
+var serverCreateCmd = &cobra.Command{
+	Use:   "create <name>",
+	Short: "Register a source server and print api key of its agent",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		data := registerNewSourceServer{Name: args[0]}
+		if errs, ok := validate.ValidateStruct[registerNewSourceServer](&data); !ok {
+			log.Fatalln(errs[0].Message)
+		}
+
+		srcsrvManager := adminKeySrvManager(adminCommandDB(cmd))
+		result, err := srcsrvManager.RegisterNewSourceServer(data.Name)
+		if err != nil {
+			log.Fatalln("error in creating source server.", err.Error())
+		}
+
+		printOutput(
+			cmd,
+			adminSourceServer{SourceServer: result.NewServer, APIKey: result.APIKey},
+			[]string{"ID", "NAME", "API KEY"},
+			[][]string{{fmt.Sprint(result.NewServer.ID), result.NewServer.Name, result.APIKey}},
+		)
+	},
+}
+
+var serverListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List source servers with their usage and agent status",
+	Run: func(cmd *cobra.Command, _ []string) {
+		srcsrvManager := adminSrvManager(adminCommandDB(cmd))
+		servers, _, err := srcsrvManager.GetListOfAllSourceServers(sourceserver.FindAllOption{
+			SortBy:    "id",
+			SortOrder: "ASC",
+			Start:     0,
+			End:       -1,
+		})
+		if err != nil {
+			log.Fatalln("error in finding source servers.", err.Error())
+		}
+
+		rows := [][]string{}
+		for _, srv := range *servers {
+			agent := "offline"
+			if srv.Agent.Online {
+				agent = "online"
+			}
+			if srv.Agent.Flagged {
+				agent += " (flagged)"
+			}
+			rows = append(rows, []string{
+				fmt.Sprint(srv.ID),
+				srv.Name,
+				srv.Group,
+				agent,
+				sourceserver.ByteCountDecimal(srv.UsedBytes),
+				fmt.Sprint(srv.UsedSnapshots),
+				fmt.Sprint(srv.LegalHold),
+				formatTime(&srv.CreatedAt),
+			})
+		}
+		printOutput(cmd, servers, []string{"ID", "NAME", "GROUP", "AGENT", "USED", "SNAPSHOTS", "LEGAL HOLD", "CREATED"}, rows)
+	},
+}
+
+var serverRotateKeyCmd = &cobra.Command{
+	Use:   "rotate-key <name>",
+	Short: "Replace api key of source server. its agent should be configured by the new key",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		srcsrvManager := adminKeySrvManager(adminCommandDB(cmd))
+		result, err := srcsrvManager.RotateSourceServerAPIKey(args[0])
+		if err != nil {
+			if errors.Is(err, xerrors.ErrRecordNotFound) {
+				log.Fatalf("no source server with name '%s' found\n", args[0])
+			}
+			log.Fatalln("error in rotating api key.", err.Error())
+		}
+
+		printOutput(
+			cmd,
+			adminSourceServer{SourceServer: result.NewServer, APIKey: result.APIKey},
+			[]string{"ID", "NAME", "API KEY"},
+			[][]string{{fmt.Sprint(result.NewServer.ID), result.NewServer.Name, result.APIKey}},
+		)
+	},
+}
+
+var serverDeleteCmd = &cobra.Command{
+	Use:   "delete <name>",
+	Short: "Delete source server and its records. stored snapshots are kept unless --purge is set",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		purge, err := cmd.Flags().GetBool("purge")
+		if err != nil {
+			log.Fatalln(err.Error())
+		}
+		yes, err := cmd.Flags().GetBool("yes")
+		if err != nil {
+			log.Fatalln(err.Error())
+		}
+		if !yes {
+			log.Fatalf("deleting source server '%s' can not be undone, pass --yes to confirm\n", args[0])
+		}
+
+		srcsrvManager := adminSrvManager(adminCommandDB(cmd))
+		srv, err := srcsrvManager.DeleteSourceServer(args[0], purge)
+		if err != nil {
+			if errors.Is(err, xerrors.ErrRecordNotFound) {
+				log.Fatalf("no source server with name '%s' found\n", args[0])
+			}
+			if errors.Is(err, xerrors.ErrSourceServerUnderLegalHold) {
+				log.Fatalf("source server '%s' is under legal hold, release it first\n", args[0])
+			}
+			log.Fatalln("error in deleting source server.", err.Error())
+		}
+
+		printOutput(
+			cmd,
+			srv,
+			[]string{"ID", "NAME", "PURGED"},
+			[][]string{{fmt.Sprint(srv.ID), srv.Name, fmt.Sprint(purge)}},
+		)
+	},
+}
+
+var statsCmd = &cobra.Command{
+	Use:   "stats",
+	Short: "Show counts of users, source servers, files and size of stored snapshots",
+	Run: func(cmd *cobra.Command, _ []string) {
+		db := adminCommandDB(cmd)
+		userManager := auth.NewUserManager(auth.UserConfig{}, auth.NewUserRepository(db))
+		srcsrvManager := adminSrvManager(db)
+
+		_, usersCount, err := userManager.GetAllUsers(auth.FindAllOption{SortBy: "id", SortOrder: "ASC", Start: 0, End: 1})
+		if err != nil {
+			log.Fatalln("error in counting users.", err.Error())
+		}
+		sourceServersCount, err := srcsrvManager.SourceServersCount()
+		if err != nil {
+			log.Fatalln("error in counting source servers.", err.Error())
+		}
+		filesCount, err := srcsrvManager.SourceServerFilesCount()
+		if err != nil {
+			log.Fatalln("error in counting files.", err.Error())
+		}
+		snapshotsSize, err := srcsrvManager.TotalSnapshotsSize()
+		if err != nil {
+			log.Fatalln("error in calculating size of snapshots.", err.Error())
+		}
+
+		printOutput(
+			cmd,
+			map[string]interface{}{
+				"users_count":            usersCount,
+				"source_servers_count":   sourceServersCount,
+				"backup_files_count":     filesCount,
+				"snapshot_occupied_size": snapshotsSize,
+			},
+			[]string{"STAT", "VALUE"},
+			[][]string{
+				{"users", fmt.Sprint(usersCount)},
+				{"source servers", fmt.Sprint(sourceServersCount)},
+				{"backup files", fmt.Sprint(filesCount)},
+				{"snapshots size", snapshotsSize},
+			},
+		)
+	},
+}
+
+func init() {
+	for _, cmd := range []*cobra.Command{
+		userCreateCmd, userListCmd, userResetPasswordCmd, userDisableCmd, userTokenCmd,
+		serverCreateCmd, serverListCmd, serverRotateKeyCmd, serverDeleteCmd,
+		statsCmd,
+	} {
+		cmd.Flags().StringP(
+			"config",
+			"c",
+			"",
+			"archivo server configuration (default is $HOME/.archivo.yaml)",
+		)
+		cmd.Flags().StringP("output", "o", outputTable, "output format, table or json")
+	}
+	userCreateCmd.Flags().String("email", "", "email of user")
+	userCreateCmd.Flags().String("username", "", "username of user")
+	userCreateCmd.Flags().String("password", "", "password of user (default is a generated initial password)")
+	userCreateCmd.Flags().Bool("admin", false, "create the admin user, when no admin exists")
+	userCreateCmd.MarkFlagRequired("email")
+	userCreateCmd.MarkFlagRequired("username")
+	userResetPasswordCmd.Flags().String("password", "", "new initial password of user (default is a generated one)")
+	userTokenCmd.Flags().Duration("ttl", 30*24*time.Hour, "duration which token is valid for")
+	serverDeleteCmd.Flags().Bool("purge", false, "remove stored snapshots of source server too")
+	serverDeleteCmd.Flags().Bool("yes", false, "confirm deleting source server")
+}
@@ -0,0 +1,202 @@
+package archive
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ARTM2000/archivo/internal/archive/sourceserver"
+	"github.com/ARTM2000/archivo/internal/archive/xerrors"
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	agentTimestampHeader     = "X-Archivo-Timestamp"
+	agentNonceHeader         = "X-Archivo-Nonce"
+	agentContentSHA256Header = "X-Archivo-Content-SHA256"
+	agentSignatureHeader     = "X-Archivo-Signature"
+
+	maxNonceLength = 64
+)
+
+var errRequestBodyTooLarge = errors.New("request body is too large")
+
+// nonceCache keeps nonces of signed agent requests until their timestamp is
+// too old to be accepted, so a request can not be replayed in between
+type nonceCache struct {
+	mu        sync.Mutex
+	nonces    map[string]time.Time
+	lastPrune time.Time
+}
+
+func newNonceCache() *nonceCache {
+	return &nonceCache{
+		nonces:    map[string]time.Time{},
+		lastPrune: time.Now(),
+	}
+}
+
+// use remembers nonce until expiry. it reports false if nonce is already used
+func (nc *nonceCache) use(nonce string, expiry time.Time) bool {
+	nc.mu.Lock()
+	defer nc.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(nc.lastPrune) > time.Minute {
+		for n, e := range nc.nonces {
+			if now.After(e) {
+				delete(nc.nonces, n)
+			}
+		}
+		nc.lastPrune = now
+	}
+
+	if e, exists := nc.nonces[nonce]; exists && !now.After(e) {
+		return false
+	}
+	nc.nonces[nonce] = expiry
+	return true
+}
+
+// authorizeSignedRequest authorizes agent by signature of its request, which
+// covers method, uri, timestamp, nonce and sha256 of body
+func (api *API) authorizeSignedRequest(c *fiber.Ctx, sourceServerName string) error {
+	timestampHeader := c.Get(agentTimestampHeader)
+	nonce := c.Get(agentNonceHeader)
+	bodyHash := strings.ToLower(c.Get(agentContentSHA256Header))
+	signature := c.Get(agentSignatureHeader)
+
+	timestamp, err := strconv.ParseInt(timestampHeader, 10, 64)
+	if err != nil {
+		log.Default().Printf("unauthorized agent1 request. invalid timestamp '%s' received", timestampHeader)
+		return fiber.NewError(fiber.StatusUnauthorized, "unauthorized request")
+	}
+	signedAt := time.Unix(timestamp, 0)
+	skew := api.Config.AgentAuth.ClockSkew()
+	if time.Since(signedAt) > skew || time.Until(signedAt) > skew {
+		log.Default().Printf("unauthorized agent1 request. timestamp '%s' is out of accepted clock skew", signedAt.Format(time.RFC3339))
+		return fiber.NewError(fiber.StatusUnauthorized, "request timestamp is stale, check clock of agent")
+	}
+	if nonce == "" || len(nonce) > maxNonceLength {
+		log.Default().Println("unauthorized agent1 request. no valid nonce received")
+		return fiber.NewError(fiber.StatusUnauthorized, "unauthorized request")
+	}
+	if _, err := hex.DecodeString(bodyHash); err != nil || len(bodyHash) != sha256.Size*2 {
+		log.Default().Println("unauthorized agent1 request. no valid content hash received")
+		return fiber.NewError(fiber.StatusUnauthorized, "unauthorized request")
+	}
+
+	message := strings.Join([]string{
+		c.Method(),
+		string(c.Request().Header.RequestURI()),
+		timestampHeader,
+		nonce,
+		bodyHash,
+	}, "\n")
+
+	srcsrvManager := sourceserver.NewSrvManager(
+		sourceserver.SrvConfig{
+			SigningKey:       api.SigningKey,
+			DefaultMaxUpload: api.Config.Upload.MaxUploadSize(),
+		},
+		sourceserver.NewSrvRepository(api.DB),
+	)
+	srcSrv, err := srcsrvManager.AuthorizeSignedRequest(sourceServerName, []byte(message), signature)
+	if err != nil {
+		log.Default().Printf("error in authorizing signed agent request, %s", err.Error())
+		return fiber.NewError(fiber.StatusUnauthorized, "unauthorized request")
+	}
+
+	// nonce is remembered as long as its timestamp is accepted
+	if !api.Nonces.use(fmt.Sprintf("%d:%s", srcSrv.ID, nonce), signedAt.Add(skew)) {
+		log.Default().Printf("unauthorized agent1 request. nonce '%s' of source server '%s' is already used", nonce, srcSrv.Name)
+		return fiber.NewError(fiber.StatusUnauthorized, "unauthorized request")
+	}
+
+	// body is read before uploadLimitMiddleware, so it is bounded here too
+	maxBody := srcsrvManager.MaxUploadSize(srcSrv)
+	if maxBody > 0 {
+		maxBody += multipartOverhead
+		if int64(c.Request().Header.ContentLength()) > maxBody {
+			log.Default().Printf("request of source server '%s' rejected. content length: %d, max body: %d", srcSrv.Name, c.Request().Header.ContentLength(), maxBody)
+			return fiber.NewError(fiber.StatusRequestEntityTooLarge, xerrors.ErrUploadTooLarge.Error())
+		}
+	}
+
+	cleanup, err := api.verifyRequestBody(c, bodyHash, maxBody)
+	defer cleanup()
+	if errors.Is(err, errRequestBodyTooLarge) {
+		log.Default().Printf("request of source server '%s' rejected. body is bigger than %d bytes", srcSrv.Name, maxBody)
+		return fiber.NewError(fiber.StatusRequestEntityTooLarge, xerrors.ErrUploadTooLarge.Error())
+	}
+	if err != nil {
+		log.Default().Printf("unauthorized agent1 request of source server '%s'. %s", srcSrv.Name, err.Error())
+		return fiber.NewError(fiber.StatusUnauthorized, "unauthorized request")
+	}
+
+	c.Locals(SrcSrvLocalName, srcSrv)
+	return c.Next()
+}
+
+// verifyRequestBody checks sha256 of request body. as request bodies are
+// streamed, body is read before handler; small ones into memory and big ones
+// into a temporary file which is removed by returned function. body bigger
+// than maxBody is not read further, zero maxBody means no limit
+func (api *API) verifyRequestBody(c *fiber.Ctx, bodyHash string, maxBody int64) (func(), error) {
+	cleanup := func() {}
+	hash := sha256.New()
+
+	stream := c.Context().RequestBodyStream()
+	if stream != nil && maxBody > 0 {
+		stream = io.LimitReader(stream, maxBody+1)
+	}
+	contentLength := c.Request().Header.ContentLength()
+	switch {
+	case stream == nil:
+		hash.Write(c.Request().Body())
+	case contentLength >= 0 && contentLength <= api.Config.Upload.MemoryBodyLimit():
+		body, err := io.ReadAll(stream)
+		if err != nil {
+			return cleanup, fmt.Errorf("unable to read request body, error: %s", err.Error())
+		}
+		if maxBody > 0 && int64(len(body)) > maxBody {
+			return cleanup, errRequestBodyTooLarge
+		}
+		c.Request().SetBody(body)
+		hash.Write(body)
+	default:
+		spool, err := os.CreateTemp("", "archivo-body-*")
+		if err != nil {
+			return cleanup, fmt.Errorf("unable to create temporary file for request body, error: %s", err.Error())
+		}
+		// fasthttp does not close body streams other than its own
+		cleanup = func() {
+			spool.Close()
+			os.Remove(spool.Name())
+		}
+		size, err := io.Copy(io.MultiWriter(spool, hash), stream)
+		if err != nil {
+			return cleanup, fmt.Errorf("unable to read request body, error: %s", err.Error())
+		}
+		if maxBody > 0 && size > maxBody {
+			return cleanup, errRequestBodyTooLarge
+		}
+		if _, err := spool.Seek(0, io.SeekStart); err != nil {
+			return cleanup, fmt.Errorf("unable to read request body, error: %s", err.Error())
+		}
+		c.Request().SetBodyStream(spool, int(size))
+	}
+
+	if hex.EncodeToString(hash.Sum(nil)) != bodyHash {
+		return cleanup, fmt.Errorf("content hash does not match request body")
+	}
+	return cleanup, nil
+}
@@ -0,0 +1,129 @@
+package archive
+
+import (
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ARTM2000/archivo/internal/archive/migration"
+	"github.com/ARTM2000/archivo/internal/archive/sourceserver"
+	"github.com/gofiber/fiber/v2"
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func newTestSignedAPI(t *testing.T) (*fiber.App, string) {
+	t.Helper()
+	dsn := "file:" + filepath.Join(t.TempDir(), "archivo.db") + "?_pragma=foreign_keys(1)&_txlock=immediate"
+	db, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{Logger: logger.Discard, TranslateError: true})
+	if err != nil {
+		t.Fatalf("open database: %s", err)
+	}
+	migrator, err := migration.NewMigrator(db, nil)
+	if err != nil {
+		t.Fatalf("new migrator: %s", err)
+	}
+	if _, err := migrator.Up(); err != nil {
+		t.Fatalf("migrate database: %s", err)
+	}
+	_, signingKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("generate signing key: %s", err)
+	}
+
+	srvManager := sourceserver.NewSrvManager(
+		sourceserver.SrvConfig{SigningKey: signingKey},
+		sourceserver.NewSrvRepository(db),
+	)
+	result, err := srvManager.RegisterNewSourceServer("web1")
+	if err != nil {
+		t.Fatalf("register source server: %s", err)
+	}
+
+	api := API{DB: db, Config: &Config{}, SigningKey: signingKey, Nonces: newNonceCache()}
+	app := fiber.New()
+	app.Get("/config", func(c *fiber.Ctx) error {
+		return api.authorizeSignedRequest(c, "web1")
+	}, func(c *fiber.Ctx) error {
+		return c.SendStatus(fiber.StatusOK)
+	})
+	return app, result.APIKey
+}
+
+func sendSignedRequest(t *testing.T, app *fiber.App, apiKey string, signedAt time.Time, nonce string) int {
+	t.Helper()
+	keyMac := hmac.New(sha256.New, []byte(apiKey))
+	keyMac.Write([]byte("archivo-request-signing"))
+
+	timestamp := strconv.FormatInt(signedAt.Unix(), 10)
+	bodyHash := sha256.Sum256(nil)
+	mac := hmac.New(sha256.New, keyMac.Sum(nil))
+	mac.Write([]byte(strings.Join([]string{fiber.MethodGet, "/config", timestamp, nonce, hex.EncodeToString(bodyHash[:])}, "\n")))
+
+	req := httptest.NewRequest(fiber.MethodGet, "/config", nil)
+	req.Header.Set(agentTimestampHeader, timestamp)
+	req.Header.Set(agentNonceHeader, nonce)
+	req.Header.Set(agentContentSHA256Header, hex.EncodeToString(bodyHash[:]))
+	req.Header.Set(agentSignatureHeader, hex.EncodeToString(mac.Sum(nil)))
+	resp, err := app.Test(req)
+	if err != nil {
+		t.Fatalf("send request: %s", err)
+	}
+	return resp.StatusCode
+}
+
+func TestSignedRequestReplayIsRejected(t *testing.T) {
+	app, apiKey := newTestSignedAPI(t)
+
+	if status := sendSignedRequest(t, app, apiKey, time.Now(), "nonce1"); status != fiber.StatusOK {
+		t.Fatalf("signed request returned %d, want %d", status, fiber.StatusOK)
+	}
+	if status := sendSignedRequest(t, app, apiKey, time.Now(), "nonce1"); status != fiber.StatusUnauthorized {
+		t.Fatalf("replayed request returned %d, want %d", status, fiber.StatusUnauthorized)
+	}
+	if status := sendSignedRequest(t, app, apiKey, time.Now(), "nonce2"); status != fiber.StatusOK {
+		t.Fatalf("request with new nonce returned %d, want %d", status, fiber.StatusOK)
+	}
+}
+
+func TestSignedRequestOutOfClockSkewIsRejected(t *testing.T) {
+	app, apiKey := newTestSignedAPI(t)
+	skew := (&AgentAuth{}).ClockSkew()
+
+	if status := sendSignedRequest(t, app, apiKey, time.Now().Add(-skew-time.Minute), "old"); status != fiber.StatusUnauthorized {
+		t.Fatalf("stale request returned %d, want %d", status, fiber.StatusUnauthorized)
+	}
+	if status := sendSignedRequest(t, app, apiKey, time.Now().Add(skew+time.Minute), "future"); status != fiber.StatusUnauthorized {
+		t.Fatalf("request from future returned %d, want %d", status, fiber.StatusUnauthorized)
+	}
+	if status := sendSignedRequest(t, app, apiKey, time.Now().Add(-skew+time.Minute), "skewed"); status != fiber.StatusOK {
+		t.Fatalf("request within clock skew returned %d, want %d", status, fiber.StatusOK)
+	}
+}
+
+func TestNonceCacheExpiry(t *testing.T) {
+	nc := newNonceCache()
+	if !nc.use("1:a", time.Now().Add(time.Minute)) {
+		t.Fatal("new nonce is rejected")
+	}
+	if nc.use("1:a", time.Now().Add(time.Minute)) {
+		t.Fatal("used nonce is accepted")
+	}
+	if !nc.use("2:a", time.Now().Add(time.Minute)) {
+		t.Fatal("nonce of another source server is rejected")
+	}
+
+	// expired nonce is older than accepted timestamps, so it is not kept
+	if !nc.use("1:b", time.Now().Add(-time.Second)) || !nc.use("1:b", time.Now().Add(time.Minute)) {
+		t.Fatal("expired nonce is still remembered")
+	}
+}
@@ -1,11 +1,19 @@
 package archive
 
 import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
+	"github.com/ARTM2000/archivo/internal/archive/migration"
+	"github.com/ARTM2000/archivo/internal/archive/sourceserver"
 	"github.com/ARTM2000/archivo/internal/config"
 	"github.com/mitchellh/go-homedir"
 	"github.com/spf13/cobra"
@@ -33,6 +41,18 @@ func archiveConfigPreProcess(configPath string) {
 	if err := config.Parse[Config](finalConfigFile, &parsedConfig); err != nil {
 		log.Fatalf("error on reading configuration: %s", err.Error())
 	}
+	// signing key and ca are kept beside config file by default, out of file
+	// store
+	configDir, err := filepath.Abs(filepath.Dir(finalConfigFile))
+	if err != nil {
+		log.Fatalln(err)
+	}
+	if parsedConfig.Integrity.SigningKeyPath == "" {
+		parsedConfig.Integrity.SigningKeyPath = filepath.Join(configDir, defaultSigningKeyFilename)
+	}
+	if parsedConfig.TLS.CADir == "" {
+		parsedConfig.TLS.CADir = filepath.Join(configDir, defaultCADirname)
+	}
 
 	log.Default().Println("archivo configuration:", parsedConfig.String())
 	// validate received config
@@ -55,6 +75,354 @@ var validateCmd = &cobra.Command{
 	},
 }
 
+var verifyCmd = &cobra.Command{
+	Use:   "verify",
+	Short: "Verify stored snapshots against their recorded checksums",
+	Run: func(cmd *cobra.Command, _ []string) {
+		configPath, err := cmd.Flags().GetString("config")
+		if err != nil {
+			log.Fatalln(err.Error())
+		}
+		archiveConfigPreProcess(configPath)
+
+		signingKey, err := loadSigningKey(&parsedConfig)
+		if err != nil {
+			log.Fatalln("error in loading signing key.", err.Error())
+		}
+
+		report, err := verifyStoreIntegrity(&parsedConfig, NewDBConnection(NewDBConfig(&parsedConfig)), signingKey)
+		if err != nil {
+			log.Fatalln("error in verifying snapshots.", err.Error())
+		}
+
+		for _, m := range report.Mismatches {
+			fmt.Printf(
+				"MISMATCH server=%s file=%s snapshot=%s expected=%s actual=%s reason=%q\n",
+				m.SourceServer, m.Filename, m.Snapshot, m.Expected, m.Actual, m.Reason,
+			)
+		}
+		fmt.Printf("checked: %d, adopted: %d, failed: %d, mismatches: %d\n", report.Checked, report.Adopted, report.Failed, len(report.Mismatches))
+
+		if len(report.Mismatches) > 0 || report.Failed > 0 {
+			os.Exit(1)
+		}
+	},
+}
+
+var reindexCmd = &cobra.Command{
+	Use:   "reindex",
+	Short: "Rebuild full text search index of all stored snapshots",
+	Run: func(cmd *cobra.Command, _ []string) {
+		configPath, err := cmd.Flags().GetString("config")
+		if err != nil {
+			log.Fatalln(err.Error())
+		}
+		archiveConfigPreProcess(configPath)
+
+		srcsrvManager := sourceserver.NewSrvManager(
+			sourceserver.SrvConfig{
+				StoreMode:       parsedConfig.FileStore.Mode,
+				DiskStoreConfig: sourceserver.DiskStoreConfig(parsedConfig.FileStore.DiskConfig),
+			},
+			sourceserver.NewSrvRepository(NewDBConnection(NewDBConfig(&parsedConfig))),
+		)
+		indexed, err := srcsrvManager.RebuildSearchIndex()
+		if err != nil {
+			log.Fatalln("error in rebuilding search index.", err.Error())
+		}
+		fmt.Printf("indexed: %d\n", indexed)
+	},
+}
+
+var verifyManifestCmd = &cobra.Command{
+	Use:   "verify-manifest <manifest.json>",
+	Short: "Verify an exported snapshot history manifest offline",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		publicKeyB64, err := cmd.Flags().GetString("public-key")
+		if err != nil {
+			log.Fatalln(err.Error())
+		}
+
+		manifestBytes, err := os.ReadFile(args[0])
+		if err != nil {
+			log.Fatalln("error in reading manifest.", err.Error())
+		}
+
+		var manifest sourceserver.Manifest
+		if err := json.Unmarshal(manifestBytes, &manifest); err != nil {
+			log.Fatalln("error in parsing manifest.", err.Error())
+		}
+
+		var trustedKey ed25519.PublicKey
+		if publicKeyB64 != "" {
+			pk, err := base64.StdEncoding.DecodeString(publicKeyB64)
+			if err != nil || len(pk) != ed25519.PublicKeySize {
+				log.Fatalln("public key is not a valid base64 encoded ed25519 key")
+			}
+			trustedKey = pk
+		} else {
+			log.Default().Println("no trusted public key received. using the one embedded in manifest")
+		}
+
+		if err := sourceserver.VerifyManifest(&manifest, trustedKey); err != nil {
+			fmt.Printf("manifest is NOT valid: %s\n", err.Error())
+			os.Exit(1)
+		}
+
+		fmt.Printf(
+			"manifest is valid. source server: '%s', filename: '%s', entries: %d, head: %s\n",
+			manifest.SourceServer,
+			manifest.Filename,
+			len(manifest.Entries),
+			manifest.Head,
+		)
+	},
+}
+
+var caCmd = &cobra.Command{
+	Use:   "ca",
+	Short: "Manage built-in certificate authority which issues agent client certificates",
+}
+
+var caInitCmd = &cobra.Command{
+	Use:   "init",
+	Short: "Create certificate and key of built-in ca",
+	Run: func(cmd *cobra.Command, _ []string) {
+		configPath, err := cmd.Flags().GetString("config")
+		if err != nil {
+			log.Fatalln(err.Error())
+		}
+		archiveConfigPreProcess(configPath)
+
+		caDir, err := caDirectory(&parsedConfig)
+		if err != nil {
+			log.Fatalln(err.Error())
+		}
+		caCert, err := initCA(caDir)
+		if err != nil {
+			log.Fatalln("error in creating ca.", err.Error())
+		}
+		fmt.Printf(
+			"ca created at '%s', valid until %s\n",
+			caDir,
+			caCert.NotAfter.Format(time.RFC3339),
+		)
+	},
+}
+
+var caIssueCmd = &cobra.Command{
+	Use:   "issue",
+	Short: "Issue a client certificate for agent of a source server",
+	Run: func(cmd *cobra.Command, _ []string) {
+		configPath, err := cmd.Flags().GetString("config")
+		if err != nil {
+			log.Fatalln(err.Error())
+		}
+		name, err := cmd.Flags().GetString("name")
+		if err != nil {
+			log.Fatalln(err.Error())
+		}
+		days, err := cmd.Flags().GetInt("days")
+		if err != nil {
+			log.Fatalln(err.Error())
+		}
+		outDir, err := cmd.Flags().GetString("out-dir")
+		if err != nil {
+			log.Fatalln(err.Error())
+		}
+		if days <= 0 {
+			log.Fatalln("days should be a positive number")
+		}
+		archiveConfigPreProcess(configPath)
+
+		srcsrvManager := sourceserver.NewSrvManager(
+			sourceserver.SrvConfig{},
+			sourceserver.NewSrvRepository(NewDBConnection(NewDBConfig(&parsedConfig))),
+		)
+
+		caDir, err := caDirectory(&parsedConfig)
+		if err != nil {
+			log.Fatalln(err.Error())
+		}
+		cert, certPEM, keyPEM, err := issueAgentCertificate(caDir, name, time.Duration(days)*24*time.Hour)
+		if err != nil {
+			log.Fatalln("error in issuing certificate.", err.Error())
+		}
+		if _, err := srcsrvManager.RecordAgentCertificate(name, certificateSerial(cert), cert.NotAfter); err != nil {
+			log.Fatalf("error in recording certificate of source server '%s'. %s", name, err.Error())
+		}
+
+		certPath := filepath.Join(outDir, name+".crt")
+		keyPath := filepath.Join(outDir, name+".key")
+		if err := os.WriteFile(certPath, certPEM, 0644); err != nil {
+			log.Fatalln("error in writing certificate.", err.Error())
+		}
+		if err := os.WriteFile(keyPath, keyPEM, 0600); err != nil {
+			log.Fatalln("error in writing certificate key.", err.Error())
+		}
+		fmt.Printf(
+			"certificate '%s' issued for source server '%s', valid until %s. use '%s' as tls_cert and '%s' as tls_key of agent\n",
+			certificateSerial(cert),
+			name,
+			cert.NotAfter.Format(time.RFC3339),
+			certPath,
+			keyPath,
+		)
+	},
+}
+
+var caRevokeCmd = &cobra.Command{
+	Use:   "revoke",
+	Short: "Revoke a client certificate by its serial, or all certificates of a source server",
+	Run: func(cmd *cobra.Command, _ []string) {
+		configPath, err := cmd.Flags().GetString("config")
+		if err != nil {
+			log.Fatalln(err.Error())
+		}
+		serial, err := cmd.Flags().GetString("serial")
+		if err != nil {
+			log.Fatalln(err.Error())
+		}
+		name, err := cmd.Flags().GetString("name")
+		if err != nil {
+			log.Fatalln(err.Error())
+		}
+		if serial == "" && name == "" {
+			log.Fatalln("one of serial or name is required")
+		}
+		archiveConfigPreProcess(configPath)
+
+		srcsrvManager := sourceserver.NewSrvManager(
+			sourceserver.SrvConfig{},
+			sourceserver.NewSrvRepository(NewDBConnection(NewDBConfig(&parsedConfig))),
+		)
+		revoked, err := srcsrvManager.RevokeAgentCertificates(strings.ToLower(serial), name)
+		if err != nil {
+			log.Fatalln("error in revoking certificates.", err.Error())
+		}
+		fmt.Printf("%d certificates revoked\n", revoked)
+	},
+}
+
+var caListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List issued client certificates",
+	Run: func(cmd *cobra.Command, _ []string) {
+		configPath, err := cmd.Flags().GetString("config")
+		if err != nil {
+			log.Fatalln(err.Error())
+		}
+		archiveConfigPreProcess(configPath)
+
+		srcsrvManager := sourceserver.NewSrvManager(
+			sourceserver.SrvConfig{},
+			sourceserver.NewSrvRepository(NewDBConnection(NewDBConfig(&parsedConfig))),
+		)
+		certs, err := srcsrvManager.GetAgentCertificates()
+		if err != nil {
+			log.Fatalln("error in finding certificates.", err.Error())
+		}
+		for _, cert := range *certs {
+			revoked := "-"
+			if cert.RevokedAt != nil {
+				revoked = cert.RevokedAt.Format(time.RFC3339)
+			}
+			fmt.Printf(
+				"serial=%s source_server_id=%d not_after=%s revoked_at=%s\n",
+				cert.Serial, cert.SourceServerID, cert.NotAfter.Format(time.RFC3339), revoked,
+			)
+		}
+	},
+}
+
+var migrateCmd = &cobra.Command{
+	Use:   "migrate",
+	Short: "Manage versioned migrations of database schema",
+}
+
+// migrateCommandMigrator connects to database of config without applying
+// any migration
+func migrateCommandMigrator(cmd *cobra.Command) *migration.Migrator {
+	configPath, err := cmd.Flags().GetString("config")
+	if err != nil {
+		log.Fatalln(err.Error())
+	}
+	archiveConfigPreProcess(configPath)
+
+	migrator, err := newMigrator(OpenDB(NewDBConfig(&parsedConfig)))
+	if err != nil {
+		log.Fatalln("fail to load database migrations.", err.Error())
+	}
+	return migrator
+}
+
+var migrateUpCmd = &cobra.Command{
+	Use:   "up",
+	Short: "Apply all pending migrations",
+	Run: func(cmd *cobra.Command, _ []string) {
+		migrator := migrateCommandMigrator(cmd)
+		applied, err := migrator.Up()
+		for _, m := range applied {
+			fmt.Printf("applied %d %s\n", m.Version, m.Name)
+		}
+		if err != nil {
+			log.Fatalln(err.Error())
+		}
+		if len(applied) == 0 {
+			fmt.Println("database is up to date")
+		}
+	},
+}
+
+var migrateDownCmd = &cobra.Command{
+	Use:   "down",
+	Short: "Roll back the last applied migrations",
+	Run: func(cmd *cobra.Command, _ []string) {
+		steps, err := cmd.Flags().GetInt("steps")
+		if err != nil {
+			log.Fatalln(err.Error())
+		}
+		if steps <= 0 {
+			log.Fatalln("steps should be a positive number")
+		}
+		migrator := migrateCommandMigrator(cmd)
+		for i := 0; i < steps; i++ {
+			m, err := migrator.Down()
+			if err != nil {
+				if errors.Is(err, migration.ErrNoMigration) {
+					fmt.Println("no migration is applied")
+					return
+				}
+				log.Fatalln(err.Error())
+			}
+			fmt.Printf("rolled back %d %s\n", m.Version, m.Name)
+		}
+	},
+}
+
+var migrateStatusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "List migrations and whether they are applied",
+	Run: func(cmd *cobra.Command, _ []string) {
+		migrator := migrateCommandMigrator(cmd)
+		statuses, err := migrator.Status()
+		if err != nil {
+			log.Fatalln(err.Error())
+		}
+		for _, s := range statuses {
+			state := "pending"
+			if s.AppliedAt != nil {
+				state = "applied at " + s.AppliedAt.Format(time.RFC3339)
+			}
+			if s.Unknown {
+				state += " (unknown to this version of archivo)"
+			}
+			fmt.Printf("%04d %s: %s\n", s.Version, s.Name, state)
+		}
+	},
+}
+
 var archiveCmd = &cobra.Command{
 	Use:   "archivo",
 	Short: "Archivo server to store all agents files",
@@ -84,10 +452,65 @@ func init() {
 		"",
 		"archivo server configuration (default is $HOME/.archivo.yaml)",
 	)
+
+	verifyCmd.Flags().StringP(
+		"config",
+		"c",
+		"",
+		"archivo server configuration (default is $HOME/.archivo.yaml)",
+	)
+
+	for _, cmd := range []*cobra.Command{reindexCmd, caInitCmd, caIssueCmd, caRevokeCmd, caListCmd, migrateUpCmd, migrateDownCmd, migrateStatusCmd} {
+		cmd.Flags().StringP(
+			"config",
+			"c",
+			"",
+			"archivo server configuration (default is $HOME/.archivo.yaml)",
+		)
+	}
+	caIssueCmd.Flags().String("name", "", "name of source server which certificate is issued for")
+	caIssueCmd.Flags().Int("days", 365, "days which certificate is valid for")
+	caIssueCmd.Flags().String("out-dir", ".", "directory which certificate and its key are written to")
+	caIssueCmd.MarkFlagRequired("name")
+	caRevokeCmd.Flags().String("serial", "", "serial of certificate which should be revoked")
+	caRevokeCmd.Flags().String("name", "", "name of source server which all of its certificates should be revoked")
+	caRevokeCmd.MarkFlagsMutuallyExclusive("serial", "name")
+	migrateDownCmd.Flags().Int("steps", 1, "number of migrations which are rolled back")
+
+	verifyManifestCmd.Flags().StringP(
+		"public-key",
+		"k",
+		"",
+		"trusted base64 encoded ed25519 public key of archivo server (default is the key embedded in manifest)",
+	)
 }
 
 func CmdExecute() {
 	archiveCmd.AddCommand(validateCmd)
+	archiveCmd.AddCommand(verifyCmd)
+	archiveCmd.AddCommand(verifyManifestCmd)
+	archiveCmd.AddCommand(reindexCmd)
+	caCmd.AddCommand(caInitCmd)
+	caCmd.AddCommand(caIssueCmd)
+	caCmd.AddCommand(caRevokeCmd)
+	caCmd.AddCommand(caListCmd)
+	archiveCmd.AddCommand(caCmd)
+	migrateCmd.AddCommand(migrateUpCmd)
+	migrateCmd.AddCommand(migrateDownCmd)
+	migrateCmd.AddCommand(migrateStatusCmd)
+	archiveCmd.AddCommand(migrateCmd)
+	userCmd.AddCommand(userCreateCmd)
+	userCmd.AddCommand(userListCmd)
+	userCmd.AddCommand(userResetPasswordCmd)
+	userCmd.AddCommand(userDisableCmd)
+	userCmd.AddCommand(userTokenCmd)
+	archiveCmd.AddCommand(userCmd)
+	serverCmd.AddCommand(serverCreateCmd)
+	serverCmd.AddCommand(serverListCmd)
+	serverCmd.AddCommand(serverRotateKeyCmd)
+	serverCmd.AddCommand(serverDeleteCmd)
+	archiveCmd.AddCommand(serverCmd)
+	archiveCmd.AddCommand(statsCmd)
 	if err := archiveCmd.Execute(); err != nil {
 		log.Fatalln(err.Error())
 	}
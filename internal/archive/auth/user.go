@@ -125,9 +125,25 @@ func (um *userManger) LoginUser(email string, password string) (string, error) {
 		return "", xerrors.ErrEmailOrPasswordIsIncorrect
 	}
 
+	tokenString, err := um.IssueAccessToken(user, um.config.JWTExpireTime)
+	if err != nil {
+		return "", err
+	}
+
+	um.userRepository.UpdateLastLoginTime(user.ID)
+	if err != nil {
+		fmt.Println("error in updating last login time", err.Error())
+		return "", xerrors.ErrUnhandled
+	}
+
+	return tokenString, nil
+}
+
+// IssueAccessToken issues an access token of user which expires after ttl
+func (um *userManger) IssueAccessToken(user *User, ttl time.Duration) (string, error) {
 	now := time.Now().UTC()
 	claims := &jwt.MapClaims{
-		"exp": now.Add(um.config.JWTExpireTime).Unix(),
+		"exp": now.Add(ttl).Unix(),
 		"iat": now.Unix(),
 		"nbf": now.Unix(),
 		"ext": map[string]string{
@@ -143,12 +159,6 @@ func (um *userManger) LoginUser(email string, password string) (string, error) {
 		return "", xerrors.ErrUnhandled
 	}
 
-	um.userRepository.UpdateLastLoginTime(user.ID)
-	if err != nil {
-		fmt.Println("error in updating last login time", err.Error())
-		return "", xerrors.ErrUnhandled
-	}
-
 	return tokenString, nil
 }
 
@@ -234,3 +244,51 @@ func (um *userManger) GetAllUsers(option FindAllOption) (*[]User, int64, error)
 
 	return usersList, totalUsers, nil
 }
+
+// FindUser finds user by email or username
+func (um *userManger) FindUser(emailOrUsername string) (*User, error) {
+	user, err := um.userRepository.FindUserWithEmailOrUsername(emailOrUsername, emailOrUsername)
+	if err != nil {
+		if errors.Is(err, xerrors.ErrRecordNotFound) {
+			return nil, err
+		}
+		return nil, xerrors.ErrUnhandled
+	}
+
+	return user, nil
+}
+
+// ResetPassword sets password as initial password of user, which user should
+// change on next login
+func (um *userManger) ResetPassword(emailOrUsername string, password string) (*User, error) {
+	user, err := um.FindUser(emailOrUsername)
+	if err != nil {
+		return nil, err
+	}
+
+	passwordHash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		log.Default().Println("password hashing problem.", err.Error())
+		return nil, xerrors.ErrUnhandled
+	}
+
+	return um.userRepository.ResetUserPassword(user.ID, string(passwordHash))
+}
+
+// DisableUser disables a non admin user. admin can not be disabled, as the
+// dashboard would offer registering a new admin to anyone
+func (um *userManger) DisableUser(emailOrUsername string) (*User, error) {
+	user, err := um.FindUser(emailOrUsername)
+	if err != nil {
+		return nil, err
+	}
+	if user.IsAdmin {
+		return nil, xerrors.ErrAdminCanNotBeDisabled
+	}
+
+	if err := um.userRepository.DisableUser(user.ID); err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
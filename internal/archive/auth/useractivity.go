@@ -17,14 +17,15 @@ type userActivityManager struct {
 	userActivityRepo UserActivityRepository
 }
 
-func (uam *userActivityManager) SaveNewActivity(userId uint, method, route string) error {
+func (uam *userActivityManager) SaveNewActivity(userId uint, method, route, ip string) error {
 	log.Default().Printf(
-		"check user activity log for '%s:%s' for user %d",
+		"check user activity log for '%s:%s' for user %d from '%s'",
 		method,
 		route,
 		userId,
+		ip,
 	)
-	err := uam.userActivityRepo.SubmitNew(userId, fmt.Sprintf("%s:%s", method, route))
+	err := uam.userActivityRepo.SubmitNew(userId, fmt.Sprintf("%s:%s", method, route), ip)
 	return err
 }
 
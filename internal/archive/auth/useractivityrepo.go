@@ -13,6 +13,7 @@ type UserActivity struct {
 	ID        uint      `gorm:"primaryKey;unique" json:"id"`
 	UserID    uint      `json:"user_id"`
 	Act       string    `gorm:"type:string;not null" json:"act"`
+	IP        string    `gorm:"type:string" json:"ip"`
 	CreatedAt time.Time `gorm:"autoUpdateTime:milli" json:"created_at"`
 }
 
@@ -26,10 +27,11 @@ type UserActivityRepository struct {
 	db *gorm.DB
 }
 
-func (uar *UserActivityRepository) SubmitNew(userId uint, act string) error {
+func (uar *UserActivityRepository) SubmitNew(userId uint, act, ip string) error {
 	var newActivity = UserActivity{
 		UserID: userId,
 		Act:    act,
+		IP:     ip,
 	}
 
 	dbResult := uar.db.Model(&UserActivity{}).Create(&newActivity)
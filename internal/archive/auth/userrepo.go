@@ -206,3 +206,34 @@ func (repo *UserRepository) UpdateLastLoginTime(id uint) error {
 
 	return nil
 }
+
+// ResetUserPassword sets a new initial password, which user should change on
+// next login
+func (repo *UserRepository) ResetUserPassword(id uint, newHashedPassword string) (*User, error) {
+	user, err := repo.FindUserWithId(id)
+	if err != nil {
+		return nil, err
+	}
+
+	user.HashedPassword = newHashedPassword
+	user.ChangeInitialPassword = true
+	dbResult := repo.db.Save(user)
+	if dbResult.Error != nil {
+		log.Default().Printf("[Unhandled] error in resetting user password, error: %+v", dbResult.Error)
+		return nil, xerrors.ErrUnhandled
+	}
+
+	return user, nil
+}
+
+// DisableUser soft deletes user, so user can not login and access tokens of
+// user are not accepted anymore
+func (repo *UserRepository) DisableUser(id uint) error {
+	dbResult := repo.db.Delete(&User{}, id)
+	if dbResult.Error != nil {
+		log.Default().Printf("[Unhandled] error in disabling user, error: %+v", dbResult.Error)
+		return xerrors.ErrUnhandled
+	}
+
+	return nil
+}
@@ -0,0 +1,120 @@
+package auth
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/ARTM2000/archivo/internal/archive/migration"
+	"github.com/ARTM2000/archivo/internal/archive/xerrors"
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func newTestRepository(t *testing.T) UserRepository {
+	t.Helper()
+	dsn := "file:" + filepath.Join(t.TempDir(), "archivo.db") + "?_pragma=foreign_keys(1)&_txlock=immediate"
+	db, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{Logger: logger.Discard, TranslateError: true})
+	if err != nil {
+		t.Fatalf("open database: %s", err)
+	}
+	migrator, err := migration.NewMigrator(db, nil)
+	if err != nil {
+		t.Fatalf("new migrator: %s", err)
+	}
+	if _, err := migrator.Up(); err != nil {
+		t.Fatalf("migrate database: %s", err)
+	}
+	return NewUserRepository(db)
+}
+
+func TestCreateAndFindUsers(t *testing.T) {
+	repo := newTestRepository(t)
+
+	if _, err := repo.FindAdminUser(); !errors.Is(err, xerrors.ErrRecordNotFound) {
+		t.Fatalf("finding admin of empty database returned %v, want %v", err, xerrors.ErrRecordNotFound)
+	}
+
+	admin, err := repo.CreateNewAdminUser("admin", "admin@example.com", "hash")
+	if err != nil {
+		t.Fatalf("create admin user: %s", err)
+	}
+	if !admin.IsAdmin || admin.ChangeInitialPassword {
+		t.Fatalf("admin user is created as %+v", admin)
+	}
+	user, err := repo.CreateNewNonAdminUser("user", "user@example.com", "hash")
+	if err != nil {
+		t.Fatalf("create user: %s", err)
+	}
+	if user.IsAdmin || !user.ChangeInitialPassword {
+		t.Fatalf("user is created as %+v", user)
+	}
+
+	found, err := repo.FindAdminUser()
+	if err != nil || found.ID != admin.ID {
+		t.Fatalf("found admin %+v, error: %v", found, err)
+	}
+	found, err = repo.FindUserWithEmail("user@example.com")
+	if err != nil || found.ID != user.ID {
+		t.Fatalf("found user by email %+v, error: %v", found, err)
+	}
+	found, err = repo.FindUserWithEmailOrUsername("other@example.com", "user")
+	if err != nil || found.ID != user.ID {
+		t.Fatalf("found user by username %+v, error: %v", found, err)
+	}
+
+	if _, err := repo.CreateNewNonAdminUser("user", "another@example.com", "hash"); !errors.Is(err, xerrors.ErrDuplicateViolation) {
+		t.Fatalf("creating user with duplicate username returned %v, want %v", err, xerrors.ErrDuplicateViolation)
+	}
+	if _, err := repo.CreateNewNonAdminUser("another", "user@example.com", "hash"); !errors.Is(err, xerrors.ErrDuplicateViolation) {
+		t.Fatalf("creating user with duplicate email returned %v, want %v", err, xerrors.ErrDuplicateViolation)
+	}
+}
+
+func TestResetAndChangePassword(t *testing.T) {
+	repo := newTestRepository(t)
+	user, err := repo.CreateNewNonAdminUser("user", "user@example.com", "hash")
+	if err != nil {
+		t.Fatalf("create user: %s", err)
+	}
+
+	changed, err := repo.ChangeUserPassword(user.ID, "new-hash")
+	if err != nil {
+		t.Fatalf("change password: %s", err)
+	}
+	if changed.HashedPassword != "new-hash" || changed.ChangeInitialPassword {
+		t.Fatalf("user after password change is %+v", changed)
+	}
+
+	reset, err := repo.ResetUserPassword(user.ID, "reset-hash")
+	if err != nil {
+		t.Fatalf("reset password: %s", err)
+	}
+	found, err := repo.FindUserWithId(reset.ID)
+	if err != nil {
+		t.Fatalf("find user: %s", err)
+	}
+	if found.HashedPassword != "reset-hash" || !found.ChangeInitialPassword {
+		t.Fatalf("user after password reset is %+v", found)
+	}
+}
+
+func TestDisableUser(t *testing.T) {
+	repo := newTestRepository(t)
+	user, err := repo.CreateNewNonAdminUser("user", "user@example.com", "hash")
+	if err != nil {
+		t.Fatalf("create user: %s", err)
+	}
+
+	if err := repo.DisableUser(user.ID); err != nil {
+		t.Fatalf("disable user: %s", err)
+	}
+	if _, err := repo.FindUserWithId(user.ID); !errors.Is(err, xerrors.ErrRecordNotFound) {
+		t.Fatalf("finding disabled user returned %v, want %v", err, xerrors.ErrRecordNotFound)
+	}
+	// disabled user still holds its username and email
+	if _, err := repo.CreateNewNonAdminUser("user", "user@example.com", "hash"); !errors.Is(err, xerrors.ErrDuplicateViolation) {
+		t.Fatalf("creating user with username of disabled user returned %v, want %v", err, xerrors.ErrDuplicateViolation)
+	}
+}
@@ -230,11 +230,17 @@ func (api *API) _commonAuthorization(c *fiber.Ctx) (*auth.User, error) {
 		log.Default().Printf("error in getting session from store, error: %+v \n", err.Error())
 		return nil, fiber.NewError(fiber.StatusInternalServerError, "internal server error")
 	}
-	tknData := session.Get(SessionCredentialKey)
-	if tknData == nil {
+	var authHeader string
+	if tknData := session.Get(SessionCredentialKey); tknData != nil {
+		authHeader = tknData.(string)
+	} else {
+		// clients other than dashboard, e.g. archivo-cli, send personal
+		// access token instead of session
+		authHeader = c.Get(fiber.HeaderAuthorization)
+	}
+	if authHeader == "" {
 		return nil, fiber.NewError(fiber.StatusUnauthorized, "unauthorized request")
 	}
-	authHeader := tknData.(string)
 
 	if !strings.HasPrefix(authHeader, "Bearer ") {
 		return nil, fiber.NewError(fiber.StatusUnauthorized, "unauthorized request")
@@ -294,7 +300,7 @@ func (api *API) authorizationMiddleware(c *fiber.Ctx) error {
 			api.DB,
 		),
 	)
-	err = userActivityManager.SaveNewActivity(user.ID, string(c.Request().Header.Method()), string(c.Request().RequestURI()))
+	err = userActivityManager.SaveNewActivity(user.ID, string(c.Request().Header.Method()), string(c.Request().RequestURI()), c.IP())
 	if err != nil {
 		log.Default().Println("got error in user activity log >", err)
 		return fiber.NewError(fiber.StatusInternalServerError, err.Error())
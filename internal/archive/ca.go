@@ -0,0 +1,204 @@
+package archive
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	defaultCADirname = ".archivo-ca"
+	caCertFilename   = "ca.crt"
+	caKeyFilename    = "ca.key"
+	caValidity       = 10 * 365 * 24 * time.Hour
+)
+
+// caDirectory is where certificate and key of built-in ca are kept. older
+// versions kept them in file store by default. a new ca would invalidate all
+// issued certificates, so a ca which is left there should be moved instead
+func caDirectory(c *Config) (string, error) {
+	if c.TLS.CADir == "" {
+		return "", fmt.Errorf("tls ca_dir is not set")
+	}
+	if _, err := os.Stat(filepath.Join(c.TLS.CADir, caCertFilename)); err == nil {
+		return c.TLS.CADir, nil
+	}
+	legacyCADir := filepath.Join(c.FileStore.DiskConfig.Path, defaultCADirname)
+	if _, err := os.Stat(filepath.Join(legacyCADir, caCertFilename)); err == nil {
+		return "", fmt.Errorf("ca is found in file store at '%s'. move it to '%s' or set tls ca_dir out of file store", legacyCADir, c.TLS.CADir)
+	}
+	return c.TLS.CADir, nil
+}
+
+func randomSerial() (*big.Int, error) {
+	return rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+}
+
+func encodePrivateKey(key crypto.Signer) ([]byte, error) {
+	keyBytes, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyBytes}), nil
+}
+
+// initCA creates certificate and key of built-in ca which agent certificates
+// are issued by. existing ca is never replaced, as all issued certificates
+// would be invalid
+func initCA(dir string) (*x509.Certificate, error) {
+	certPath := filepath.Join(dir, caCertFilename)
+	if _, err := os.Stat(certPath); err == nil {
+		return nil, fmt.Errorf("ca already exists at '%s'", certPath)
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	serial, err := randomSerial()
+	if err != nil {
+		return nil, err
+	}
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: "Archivo Agent CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(caValidity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	certDER, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		return nil, err
+	}
+
+	keyPEM, err := encodePrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(filepath.Join(dir, caKeyFilename), keyPEM, 0600); err != nil {
+		return nil, err
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDER})
+	if err := os.WriteFile(certPath, certPEM, 0644); err != nil {
+		return nil, err
+	}
+
+	return x509.ParseCertificate(certDER)
+}
+
+func loadCA(dir string) (*x509.Certificate, crypto.Signer, error) {
+	tlsCert, err := tls.LoadX509KeyPair(filepath.Join(dir, caCertFilename), filepath.Join(dir, caKeyFilename))
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to load ca from '%s', run 'archivo ca init' first. %s", dir, err.Error())
+	}
+	cert, err := x509.ParseCertificate(tlsCert.Certificate[0])
+	if err != nil {
+		return nil, nil, err
+	}
+	signer, ok := tlsCert.PrivateKey.(crypto.Signer)
+	if !ok {
+		return nil, nil, fmt.Errorf("ca key of '%s' can not sign certificates", dir)
+	}
+	return cert, signer, nil
+}
+
+// issueAgentCertificate issues a client certificate for agent of source
+// server. name of source server is set as common name and dns name of it
+func issueAgentCertificate(dir, srcSrvName string, validity time.Duration) (*x509.Certificate, []byte, []byte, error) {
+	caCert, caKey, err := loadCA(dir)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	serial, err := randomSerial()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: srcSrvName},
+		DNSNames:     []string{srcSrvName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(validity),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	certDER, err := x509.CreateCertificate(rand.Reader, template, caCert, key.Public(), caKey)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	cert, err := x509.ParseCertificate(certDER)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	keyPEM, err := encodePrivateKey(key)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDER})
+	return cert, certPEM, keyPEM, nil
+}
+
+// certificateSerial is serial number of certificate as it is recorded
+func certificateSerial(cert *x509.Certificate) string {
+	return cert.SerialNumber.Text(16)
+}
+
+// certificateNames are the names which certificate is mapped to a source
+// server by
+func certificateNames(cert *x509.Certificate) []string {
+	names := []string{}
+	if cert.Subject.CommonName != "" {
+		names = append(names, cert.Subject.CommonName)
+	}
+	return append(names, cert.DNSNames...)
+}
+
+// serverTLSConfig is tls config of archivo server. client certificates are
+// asked but not required by tls, as dashboard users have none. store routes
+// check them when agent certificates are required
+func serverTLSConfig(c *Config, certs *certificateReloader) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: certs.getCertificate,
+	}
+	if !c.TLS.RequireAgentCert {
+		return tlsConfig, nil
+	}
+
+	caDir, err := caDirectory(c)
+	if err != nil {
+		return nil, err
+	}
+	caPEM, err := os.ReadFile(filepath.Join(caDir, caCertFilename))
+	if err != nil {
+		return nil, fmt.Errorf("unable to read ca certificate, run 'archivo ca init' first. %s", err.Error())
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(caPEM) {
+		return nil, fmt.Errorf("ca certificate of '%s' is not valid", caDir)
+	}
+	tlsConfig.ClientCAs = pool
+	tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+	return tlsConfig, nil
+}
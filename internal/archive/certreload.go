@@ -0,0 +1,68 @@
+package archive
+
+import (
+	"crypto/tls"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+)
+
+// certificateReloader keeps tls certificate of archivo server, so a renewed
+// certificate can be loaded without restart
+type certificateReloader struct {
+	certFile string
+	keyFile  string
+	mu       sync.RWMutex
+	cert     *tls.Certificate
+}
+
+func newCertificateReloader(certFile, keyFile string) (*certificateReloader, error) {
+	cr := &certificateReloader{certFile: certFile, keyFile: keyFile}
+	if err := cr.reload(); err != nil {
+		return nil, err
+	}
+	return cr, nil
+}
+
+// reload reads certificate and key files again. current certificate is kept
+// if they can not be loaded
+func (cr *certificateReloader) reload() error {
+	cert, err := tls.LoadX509KeyPair(cr.certFile, cr.keyFile)
+	if err != nil {
+		return err
+	}
+	cr.mu.Lock()
+	cr.cert = &cert
+	cr.mu.Unlock()
+	return nil
+}
+
+func (cr *certificateReloader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	cr.mu.RLock()
+	defer cr.mu.RUnlock()
+	return cr.cert, nil
+}
+
+// redirectToHTTPS redirects plain http requests of addr to https port of
+// archivo server
+func redirectToHTTPS(addr string, httpsPort int) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, _, err := net.SplitHostPort(r.Host)
+		if err != nil {
+			// host header has no port
+			host = r.Host
+		}
+		if httpsPort != 443 {
+			host = net.JoinHostPort(host, strconv.Itoa(httpsPort))
+		}
+		http.Redirect(w, r, fmt.Sprintf("https://%s%s", host, r.URL.RequestURI()), http.StatusMovedPermanently)
+	})
+
+	log.Default().Printf("redirecting http requests of '%s' to https\n", addr)
+	if err := http.ListenAndServe(addr, handler); err != nil {
+		log.Default().Printf("http redirect server stopped, error: %s", err.Error())
+	}
+}
@@ -4,21 +4,58 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
+	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/ARTM2000/archivo/internal/validate"
+	"github.com/gofiber/fiber/v2"
+	"github.com/robfig/cron/v3"
+)
+
+const (
+	DatabasePostgres = "postgres"
+	DatabaseSQLite   = "sqlite"
 )
 
 type Database struct {
-	Host     string `mapstructure:"host" json:"host" validate:"required,hostname|ip"`
-	Port     int    `mapstructure:"port" json:"port" validate:"required,number"`
-	Username string `mapstructure:"username" json:"username" validate:"required"`
-	Password string `mapstructure:"password" json:"password" validate:"required"`
-	Name     string `mapstructure:"dbname" json:"dbname" validate:"required"`
-	Zone     string `mapstructure:"timezone" json:"timezone" validate:"required"`
+	// postgres (default) or sqlite. sqlite keeps whole database in file of path
+	Driver   string `mapstructure:"driver" json:"driver" validate:"omitempty,oneof=postgres sqlite"`
+	Path     string `mapstructure:"path" json:"path" validate:"required_if=Driver sqlite,omitempty,filepath"`
+	Host     string `mapstructure:"host" json:"host" validate:"required_unless=Driver sqlite,omitempty,hostname|ip"`
+	Port     int    `mapstructure:"port" json:"port" validate:"required_unless=Driver sqlite,omitempty,number"`
+	Username string `mapstructure:"username" json:"username" validate:"required_unless=Driver sqlite"`
+	Password string `mapstructure:"password" json:"password" validate:"required_unless=Driver sqlite"`
+	Name     string `mapstructure:"dbname" json:"dbname" validate:"required_unless=Driver sqlite"`
+	Zone     string `mapstructure:"timezone" json:"timezone" validate:"required_unless=Driver sqlite"`
 	SSLMode  bool   `mapstructure:"ssl_mode" json:"ssl_mode" validate:"omitempty,boolean"`
 }
 
+func (d *Database) DriverName() string {
+	if d.Driver == "" {
+		return DatabasePostgres
+	}
+	return d.Driver
+}
+
+func (d *Database) Validate() error {
+	if d.DriverName() != DatabaseSQLite {
+		return nil
+	}
+	dir := filepath.Dir(d.Path)
+	dirD, err := os.Stat(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return fmt.Errorf("directory '%s' of path not exists", dir)
+		}
+		return fmt.Errorf("access denied to access directory '%s' of path", dir)
+	}
+	if !dirD.IsDir() {
+		return fmt.Errorf("'%s' of path is not valid directory", dir)
+	}
+	return nil
+}
+
 type Auth struct {
 	JWTSecret     string        `mapstructure:"jwt_secret" json:"jwt_secret" validate:"required,min=10"`
 	JWTExpireTime time.Duration `mapstructure:"jwt_expire_time" json:"jwt_expire_time" validate:"required"`
@@ -60,12 +97,200 @@ type DiskFileStore struct {
 	Path string `mapstructure:"path" json:"path" validate:"required,dir"`
 }
 
+type Quota struct {
+	MaxBytes     int64 `mapstructure:"max_bytes" json:"max_bytes" validate:"omitempty,gte=0"`
+	MaxSnapshots int   `mapstructure:"max_snapshots" json:"max_snapshots" validate:"omitempty,gte=0"`
+}
+
+const (
+	defaultMaxUploadSize   = 32 * 1024 * 1024
+	defaultMemoryBodyLimit = 4 * 1024 * 1024
+)
+
+type Upload struct {
+	MaxSize     int64 `mapstructure:"max_size" json:"max_size" validate:"omitempty,gte=0"`
+	MemoryLimit int   `mapstructure:"memory_limit" json:"memory_limit" validate:"omitempty,gte=0"`
+}
+
+// MaxUploadSize is the default limit of uploaded file size of every source server
+func (u *Upload) MaxUploadSize() int64 {
+	if u.MaxSize == 0 {
+		return defaultMaxUploadSize
+	}
+	return u.MaxSize
+}
+
+// MemoryBodyLimit is the max size of request bodies that are kept in memory.
+// uploads bigger than that are streamed to temporary files and any other
+// request bigger than that is rejected
+func (u *Upload) MemoryBodyLimit() int {
+	if u.MemoryLimit == 0 {
+		return defaultMemoryBodyLimit
+	}
+	return u.MemoryLimit
+}
+
+type Integrity struct {
+	ScrubInterval  string `mapstructure:"scrub_interval" json:"scrub_interval" validate:"omitempty"`
+	SigningKeyPath string `mapstructure:"signing_key_path" json:"signing_key_path" validate:"omitempty,filepath"`
+}
+
+// Validate checks integrity config. signing key should be kept out of file
+// store, as anyone who can write to store could otherwise re-sign a tampered
+// history along with it
+func (i *Integrity) Validate(storePath string) error {
+	if i.SigningKeyPath != "" && storePath != "" && isPathInside(i.SigningKeyPath, storePath) {
+		return fmt.Errorf("signing_key_path '%s' should not be inside of file store path '%s'", i.SigningKeyPath, storePath)
+	}
+	if i.ScrubInterval == "" {
+		return nil
+	}
+	if _, err := cron.ParseStandard(i.ScrubInterval); err != nil {
+		return fmt.Errorf("scrub_interval is invalid format: %s", err.Error())
+	}
+	return nil
+}
+
+// isPathInside reports whether path is dir or is inside of it
+func isPathInside(path, dir string) bool {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return false
+	}
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return false
+	}
+	rel, err := filepath.Rel(absDir, absPath)
+	if err != nil {
+		return false
+	}
+	return rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+type TLS struct {
+	CertFile string `mapstructure:"cert_file" json:"cert_file" validate:"omitempty,filepath"`
+	KeyFile  string `mapstructure:"key_file" json:"key_file" validate:"required_with=CertFile,omitempty,filepath"`
+	// agents are authorized by client certificates which are issued by the
+	// built-in ca of ca_dir on store routes. ca_dir is not validated by
+	// filepath, which does not accept an existing directory
+	RequireAgentCert bool   `mapstructure:"require_agent_cert" json:"require_agent_cert"`
+	CADir            string `mapstructure:"ca_dir" json:"ca_dir"`
+	// plain http requests on this address are redirected to https, e.g. ":80"
+	RedirectHTTPAddr string `mapstructure:"redirect_http_addr" json:"redirect_http_addr" validate:"omitempty,hostname_port"`
+}
+
+// Validate checks tls config. key of built-in ca should be kept out of file
+// store, as anyone with access to store could take its key and issue agent
+// certificates for any source server
+func (t *TLS) Validate(storePath string) error {
+	if t.CADir != "" && storePath != "" && isPathInside(t.CADir, storePath) {
+		return fmt.Errorf("ca_dir '%s' should not be inside of file store path '%s'", t.CADir, storePath)
+	}
+	if t.RequireAgentCert && t.CertFile == "" {
+		return fmt.Errorf("require_agent_cert needs archivo to serve tls, cert_file and key_file are required")
+	}
+	if t.RedirectHTTPAddr != "" && t.CertFile == "" {
+		return fmt.Errorf("redirect_http_addr needs archivo to serve tls, cert_file and key_file are required")
+	}
+	return nil
+}
+
+var defaultCORSOrigins = []string{"http://localhost:5173", "http://127.0.0.1:5173"}
+
+type HTTP struct {
+	// origins which dashboard api can be called from by browsers (default is
+	// the vite dev server)
+	CORSOrigins []string `mapstructure:"cors_origins" json:"cors_origins" validate:"omitempty,dive,url"`
+	// ip or cidr of reverse proxies which client ip is taken from ProxyHeader
+	// of their requests. header of any other client is ignored
+	TrustedProxies []string `mapstructure:"trusted_proxies" json:"trusted_proxies" validate:"omitempty,dive,ip|cidr"`
+	ProxyHeader    string   `mapstructure:"proxy_header" json:"proxy_header"`
+	// session cookie is sent only over https. it is always set when archivo
+	// serves tls itself
+	CookieSecure   bool   `mapstructure:"cookie_secure" json:"cookie_secure"`
+	CookieSameSite string `mapstructure:"cookie_same_site" json:"cookie_same_site" validate:"omitempty,oneof=Lax Strict None"`
+}
+
+func (h *HTTP) AllowedOrigins() string {
+	if len(h.CORSOrigins) == 0 {
+		return strings.Join(defaultCORSOrigins, ",")
+	}
+	return strings.Join(h.CORSOrigins, ",")
+}
+
+// ClientIPHeader is the header which real client ip is sent by trusted
+// proxies in
+func (h *HTTP) ClientIPHeader() string {
+	if len(h.TrustedProxies) == 0 {
+		return ""
+	}
+	if h.ProxyHeader == "" {
+		return fiber.HeaderXForwardedFor
+	}
+	return h.ProxyHeader
+}
+
+func (h *HTTP) SameSite() string {
+	if h.CookieSameSite == "" {
+		return "Lax"
+	}
+	return h.CookieSameSite
+}
+
+const defaultAgentMaxClockSkew = 5 * time.Minute
+
+type AgentAuth struct {
+	// agents can be authorized by api key in Authorization header, instead of
+	// signed requests. such requests can be replayed, so it should only be
+	// enabled while agents are upgraded
+	AllowAPIKey bool `mapstructure:"allow_api_key" json:"allow_api_key"`
+	// signed requests with timestamp farther than this from server time are
+	// rejected
+	MaxClockSkew time.Duration `mapstructure:"max_clock_skew" json:"max_clock_skew" validate:"omitempty,gte=0"`
+}
+
+// ClockSkew is how old or new timestamp of a signed request can be
+func (a *AgentAuth) ClockSkew() time.Duration {
+	if a.MaxClockSkew == 0 {
+		return defaultAgentMaxClockSkew
+	}
+	return a.MaxClockSkew
+}
+
+const defaultShutdownTimeout = 30 * time.Second
+
+type Shutdown struct {
+	// how long in-flight requests, e.g. uploads, are waited for on shutdown
+	Timeout time.Duration `mapstructure:"timeout" json:"timeout" validate:"omitempty,gte=0"`
+	// how long server reports not ready and keeps serving before it stops
+	// accepting requests, so load balancers can take it out first
+	DrainDelay time.Duration `mapstructure:"drain_delay" json:"drain_delay" validate:"omitempty,gte=0"`
+}
+
+func (s *Shutdown) ShutdownTimeout() time.Duration {
+	if s.Timeout == 0 {
+		return defaultShutdownTimeout
+	}
+	return s.Timeout
+}
+
 type Config struct {
-	ServerPort *int      `mapstructure:"server_port" json:"server_port" validate:"omitempty,number"`
-	ServerHost *string   `mapstructure:"server_host" json:"server_host" validate:"omitempty,hostname|ip"`
-	Database   Database  `mapstructure:"database" json:"database" validate:"required,dive"`
-	Auth       Auth      `mapstructure:"auth" json:"auth" validate:"required,dive"`
-	FileStore  FileStore `mapstructure:"file_store" json:"file_store" validate:"required"`
+	ServerPort   *int      `mapstructure:"server_port" json:"server_port" validate:"omitempty,number"`
+	ServerHost   *string   `mapstructure:"server_host" json:"server_host" validate:"omitempty,hostname|ip"`
+	Database     Database  `mapstructure:"database" json:"database" validate:"required,dive"`
+	Auth         Auth      `mapstructure:"auth" json:"auth" validate:"required,dive"`
+	FileStore    FileStore `mapstructure:"file_store" json:"file_store" validate:"required"`
+	Integrity    Integrity `mapstructure:"integrity" json:"integrity"`
+	DefaultQuota Quota     `mapstructure:"default_quota" json:"default_quota"`
+	Upload       Upload    `mapstructure:"upload" json:"upload"`
+	TLS          TLS       `mapstructure:"tls" json:"tls"`
+	AgentAuth    AgentAuth `mapstructure:"agent_auth" json:"agent_auth"`
+	HTTP         HTTP      `mapstructure:"http" json:"http"`
+	Shutdown     Shutdown  `mapstructure:"shutdown" json:"shutdown"`
+	// source servers which agent has not sent heartbeat for this duration
+	// are shown offline
+	AgentOfflineAfter time.Duration `mapstructure:"agent_offline_after" json:"agent_offline_after"`
 }
 
 func (c *Config) String() string {
@@ -79,10 +304,32 @@ func (c *Config) Validate() error {
 		return fmt.Errorf("configuration validation error: %s", errors[0].Message)
 	}
 
+	if err := c.Database.Validate(); err != nil {
+		return fmt.Errorf("database config got error. %s", err.Error())
+	}
+
 	fileStoreErr := c.FileStore.Validate()
 	if fileStoreErr != nil {
 		return fmt.Errorf("file store config got error. %s", fileStoreErr.Error())
 	}
 
+	if err := c.Integrity.Validate(c.FileStore.DiskConfig.Path); err != nil {
+		return fmt.Errorf("integrity config got error. %s", err.Error())
+	}
+
+	if err := c.TLS.Validate(c.FileStore.DiskConfig.Path); err != nil {
+		return fmt.Errorf("tls config got error. %s", err.Error())
+	}
+
+	// browsers drop cookies with SameSite=None which are not secure
+	if c.HTTP.SameSite() == "None" && !c.SecureCookie() {
+		return fmt.Errorf("http config got error. cookie_same_site 'None' needs cookie_secure")
+	}
+
 	return nil
 }
+
+// SecureCookie reports whether session cookie should only be sent over https
+func (c *Config) SecureCookie() bool {
+	return c.HTTP.CookieSecure || c.TLS.CertFile != ""
+}
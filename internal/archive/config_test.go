@@ -0,0 +1,45 @@
+package archive
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestIntegrityRejectsSigningKeyInsideStore(t *testing.T) {
+	storePath := filepath.Join(t.TempDir(), "store")
+	cases := []struct {
+		keyPath string
+		valid   bool
+	}{
+		{keyPath: "", valid: true},
+		{keyPath: filepath.Join(filepath.Dir(storePath), ".archivo-signing.key"), valid: true},
+		{keyPath: storePath + "-keys/.archivo-signing.key", valid: true},
+		{keyPath: filepath.Join(storePath, ".archivo-signing.key"), valid: false},
+		{keyPath: filepath.Join(storePath, "web1", "..", ".archivo-signing.key"), valid: false},
+		{keyPath: storePath, valid: false},
+	}
+
+	for _, tc := range cases {
+		integrity := Integrity{SigningKeyPath: tc.keyPath}
+		err := integrity.Validate(storePath)
+		if tc.valid && err != nil {
+			t.Errorf("signing key path '%s' is rejected: %s", tc.keyPath, err)
+		}
+		if !tc.valid && err == nil {
+			t.Errorf("signing key path '%s' inside of store is accepted", tc.keyPath)
+		}
+	}
+}
+
+func TestTLSRejectsCADirInsideStore(t *testing.T) {
+	storePath := filepath.Join(t.TempDir(), "store")
+
+	outside := TLS{CADir: filepath.Join(filepath.Dir(storePath), ".archivo-ca")}
+	if err := outside.Validate(storePath); err != nil {
+		t.Errorf("ca dir beside store is rejected: %s", err)
+	}
+	inside := TLS{CADir: filepath.Join(storePath, ".archivo-ca")}
+	if err := inside.Validate(storePath); err == nil {
+		t.Error("ca dir inside of store is accepted")
+	}
+}
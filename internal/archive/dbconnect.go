@@ -1,17 +1,24 @@
 package archive
 
 import (
+	"errors"
 	"fmt"
 	"log"
+	"os"
+	"time"
 
 	"github.com/ARTM2000/archivo/internal/archive/auth"
+	"github.com/ARTM2000/archivo/internal/archive/migration"
 	"github.com/ARTM2000/archivo/internal/archive/sourceserver"
+	"github.com/glebarez/sqlite"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
 	"gorm.io/gorm/logger"
 )
 
 type DBConfig struct {
+	DBDriver  string
+	DBPath    string
 	DBHost    string
 	DBPort    int
 	DBUser    string
@@ -19,15 +26,84 @@ type DBConfig struct {
 	DBName    string
 	DBZone    string
 	DBSSLMode bool
+	// only warnings and errors are logged, to stderr, so stdout is left
+	// for output of commands
+	DBQuiet bool
 }
 
+func NewDBConfig(c *Config) DBConfig {
+	return DBConfig{
+		DBDriver:  c.Database.DriverName(),
+		DBPath:    c.Database.Path,
+		DBHost:    c.Database.Host,
+		DBPort:    c.Database.Port,
+		DBUser:    c.Database.Username,
+		DBPass:    c.Database.Password,
+		DBName:    c.Database.Name,
+		DBZone:    c.Database.Zone,
+		DBSSLMode: c.Database.SSLMode,
+	}
+}
+
+// NewDBConnection connects to database and applies pending migrations. it
+// refuses to continue with a database which is migrated by a newer archivo
 func NewDBConnection(dbc DBConfig) *gorm.DB {
+	db := OpenDB(dbc)
+
+	migrator, err := newMigrator(db)
+	if err != nil {
+		log.Fatalln("fail to load database migrations.", err.Error())
+	}
+	applied, err := migrator.Up()
+	if err != nil {
+		if errors.Is(err, migration.ErrUnknownSchema) {
+			log.Fatalln("refusing to run against database.", err.Error(), "upgrade archivo or roll back database with the newer version of archivo")
+		}
+		log.Fatalln("fail to migrate database.", err.Error())
+	}
+	if len(applied) > 0 {
+		log.Default().Printf("%d database migrations applied, database version: %d\n", len(applied), migrator.LatestVersion())
+	}
+
+	return db
+}
+
+// OpenDB connects to database without any migration
+func OpenDB(dbc DBConfig) *gorm.DB {
+	var dialector gorm.Dialector
+	switch dbc.DBDriver {
+	case DatabaseSQLite:
+		dialector = sqlite.Open(sqliteDSN(dbc.DBPath))
+	default:
+		dialector = postgres.Open(postgresDSN(dbc))
+	}
+
+	dbLogger := logger.Default.LogMode(logger.Info)
+	if dbc.DBQuiet {
+		dbLogger = logger.New(log.New(os.Stderr, "\r\n", log.LstdFlags), logger.Config{
+			SlowThreshold: 200 * time.Millisecond,
+			LogLevel:      logger.Warn,
+		})
+	}
+
+	db, err := gorm.Open(dialector, &gorm.Config{
+		Logger:         dbLogger,
+		TranslateError: true,
+	})
+	if err != nil {
+		log.Fatalln("fail to connect database.", err.Error())
+	}
+
+	return db
+}
+
+func postgresDSN(dbc DBConfig) string {
 	sslMode := "disable"
 	if dbc.DBSSLMode {
 		sslMode = "enable"
 	}
 
-	postgresDSN := fmt.Sprintf("host=%s user=%s password=%s dbname=%s port=%d sslmode=%s TimeZone=%s",
+	return fmt.Sprintf("host=%s user=%s password=%s dbname=%s port=%d sslmode=%s TimeZone=%s",
 		dbc.DBHost,
 		dbc.DBUser,
 		dbc.DBPass,
@@ -36,22 +112,33 @@ func NewDBConnection(dbc DBConfig) *gorm.DB {
 		sslMode,
 		dbc.DBZone,
 	)
+}
 
-	db, err := gorm.Open(postgres.Open(postgresDSN), &gorm.Config{
-		Logger:         logger.Default.LogMode(logger.Info),
-		TranslateError: true,
-	})
-	if err != nil {
-		log.Fatalln("fail to connect database.", err.Error())
-	}
+// sqliteDSN enables foreign keys, which sqlite does not enforce by default.
+// transactions take write lock on begin and connections wait for it, as
+// requests write concurrently and sqlite has a single writer
+func sqliteDSN(path string) string {
+	return fmt.Sprintf("file:%s?_pragma=foreign_keys(1)&_pragma=journal_mode(WAL)&_pragma=busy_timeout(5000)&_txlock=immediate", path)
+}
 
-	// auto migration.
-	// todo: make its safety more
-	db.AutoMigrate(
+func newMigrator(db *gorm.DB) (*migration.Migrator, error) {
+	return migration.NewMigrator(db, legacyAutoMigrate)
+}
+
+// legacyAutoMigrate brings databases which are created by auto migration of
+// archivo before versioned migrations to schema of the first migration. as
+// it uses current models, migrations after the first one should tolerate
+// their changes being already made, e.g. by ADD COLUMN IF NOT EXISTS
+func legacyAutoMigrate(db *gorm.DB) error {
+	return db.AutoMigrate(
 		auth.User{},
 		auth.UserActivity{},
 		sourceserver.SourceServer{},
+		sourceserver.Snapshot{},
+		sourceserver.RestoreJob{},
+		sourceserver.ManagedConfig{},
+		sourceserver.Heartbeat{},
+		sourceserver.EnrollmentToken{},
+		sourceserver.AgentCertificate{},
 	)
-
-	return db
 }
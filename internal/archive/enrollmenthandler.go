@@ -0,0 +1,190 @@
+package archive
+
+import (
+	"errors"
+	"log"
+	"time"
+
+	"github.com/ARTM2000/archivo/internal/archive/auth"
+	"github.com/ARTM2000/archivo/internal/archive/sourceserver"
+	"github.com/ARTM2000/archivo/internal/archive/xerrors"
+	"github.com/ARTM2000/archivo/internal/validate"
+	"github.com/gofiber/fiber/v2"
+)
+
+type enrollmentTokenDto struct {
+	Note    string `json:"note" validate:"omitempty,max=255"`
+	Group   string `json:"group" validate:"omitempty,max=64,printascii"`
+	MaxUses int    `json:"max_uses" validate:"required,gte=1"`
+	// how long token is valid, e.g. 24h
+	TTL string `json:"ttl" validate:"required"`
+}
+
+type enrollmentTokenParams struct {
+	TokenId uint `params:"tokenId" validate:"required,number"`
+}
+
+type enrollSourceServerDto struct {
+	Token string `json:"token" validate:"required,max=128"`
+	Name  string `json:"name" validate:"required,alphanum"`
+}
+
+func (api *API) createEnrollmentToken(c *fiber.Ctx) error {
+	var tokenData enrollmentTokenDto
+	if err := c.BodyParser(&tokenData); err != nil {
+		log.Default().Println(err.Error())
+		return fiber.NewError(fiber.StatusBadRequest, err.Error())
+	}
+	if errs, ok := validate.ValidateStruct[enrollmentTokenDto](&tokenData); !ok {
+		log.Default().Println(errs[0].Message)
+		return fiber.NewError(fiber.StatusUnprocessableEntity, errs[0].Message)
+	}
+
+	ttl, err := time.ParseDuration(tokenData.TTL)
+	if err != nil || ttl <= 0 {
+		return fiber.NewError(fiber.StatusUnprocessableEntity, "ttl should be a positive duration like 24h")
+	}
+
+	srcsrvManager := sourceserver.NewSrvManager(
+		sourceserver.SrvConfig{
+			CorrelationId: c.GetRespHeader(fiber.HeaderXRequestID),
+		},
+		sourceserver.NewSrvRepository(api.DB),
+	)
+
+	user := c.Locals(UserLocalName).(*auth.User)
+
+	result, err := srcsrvManager.CreateEnrollmentToken(tokenData.Note, tokenData.Group, tokenData.MaxUses, ttl, user.ID)
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "internal server error")
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(FormatResponse(c, Data{
+		Message: "enrollment token created",
+		Data: map[string]interface{}{
+			"enrollment_token": result.EnrollmentToken,
+			"token":            result.Token,
+		},
+	}))
+}
+
+func (api *API) getListOfEnrollmentTokens(c *fiber.Ctx) error {
+	var lData listData
+	if err := c.QueryParser(&lData); err != nil {
+		log.Default().Println(err.Error())
+		return fiber.NewError(fiber.StatusBadRequest, err.Error())
+	}
+	if errs, ok := validate.ValidateStruct[listData](&lData); !ok {
+		log.Default().Println(errs[0].Message)
+		return fiber.NewError(fiber.StatusUnprocessableEntity, errs[0].Message)
+	}
+
+	if lData.Start == nil {
+		var initialStart = 0
+		lData.Start = &initialStart
+	}
+	if lData.End == nil {
+		var initialEnd = 10
+		lData.End = &initialEnd
+	}
+
+	srcsrvManager := sourceserver.NewSrvManager(
+		sourceserver.SrvConfig{
+			CorrelationId: c.GetRespHeader(fiber.HeaderXRequestID),
+		},
+		sourceserver.NewSrvRepository(api.DB),
+	)
+
+	tokens, total, err := srcsrvManager.GetListOfEnrollmentTokens(sourceserver.FindAllOption{
+		SortBy:    lData.SortBy,
+		SortOrder: lData.SortOrder,
+		Start:     *lData.Start,
+		End:       *lData.End,
+	})
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "internal server error")
+	}
+
+	return c.Status(fiber.StatusOK).JSON(FormatResponse(c, Data{
+		Data: map[string]interface{}{
+			"list":  tokens,
+			"total": total,
+		},
+	}))
+}
+
+func (api *API) revokeEnrollmentToken(c *fiber.Ctx) error {
+	params := enrollmentTokenParams{}
+	if err := c.ParamsParser(&params); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, err.Error())
+	}
+	if errs, ok := validate.ValidateStruct[enrollmentTokenParams](&params); !ok {
+		log.Default().Println(errs[0].Message)
+		return fiber.NewError(fiber.StatusUnprocessableEntity, errs[0].Message)
+	}
+
+	srcsrvManager := sourceserver.NewSrvManager(
+		sourceserver.SrvConfig{
+			CorrelationId: c.GetRespHeader(fiber.HeaderXRequestID),
+		},
+		sourceserver.NewSrvRepository(api.DB),
+	)
+
+	token, err := srcsrvManager.RevokeEnrollmentToken(params.TokenId)
+	if err != nil {
+		if errors.Is(err, xerrors.ErrRecordNotFound) {
+			return fiber.NewError(fiber.StatusNotFound, err.Error())
+		}
+		return fiber.NewError(fiber.StatusInternalServerError, "internal server error")
+	}
+
+	return c.Status(fiber.StatusOK).JSON(FormatResponse(c, Data{
+		Message: "enrollment token revoked",
+		Data: map[string]interface{}{
+			"enrollment_token": token,
+		},
+	}))
+}
+
+// enrollSourceServer is called by agents which are not registered yet, so
+// enrollment token is the only authorization of it
+func (api *API) enrollSourceServer(c *fiber.Ctx) error {
+	var enrollData enrollSourceServerDto
+	if err := c.BodyParser(&enrollData); err != nil {
+		log.Default().Println(err.Error())
+		return fiber.NewError(fiber.StatusBadRequest, err.Error())
+	}
+	if errs, ok := validate.ValidateStruct[enrollSourceServerDto](&enrollData); !ok {
+		log.Default().Println(errs[0].Message)
+		return fiber.NewError(fiber.StatusUnprocessableEntity, errs[0].Message)
+	}
+
+	srcsrvManager := sourceserver.NewSrvManager(
+		sourceserver.SrvConfig{
+			CorrelationId: c.GetRespHeader(fiber.HeaderXRequestID),
+			SigningKey:    api.SigningKey,
+		},
+		sourceserver.NewSrvRepository(api.DB),
+	)
+
+	newSourceServerD, err := srcsrvManager.EnrollSourceServer(enrollData.Token, enrollData.Name)
+	if err != nil {
+		if errors.Is(err, xerrors.ErrInvalidEnrollmentToken) {
+			return fiber.NewError(fiber.StatusUnauthorized, err.Error())
+		}
+		if errors.Is(err, xerrors.ErrSourceServerWithThisNameExists) {
+			return fiber.NewError(fiber.StatusConflict, "source server with this name exists")
+		}
+		return fiber.NewError(fiber.StatusInternalServerError, "internal server error")
+	}
+
+	return c.Status(fiber.StatusOK).JSON(FormatResponse(c, Data{
+		Message: "source server enrolled",
+		Data: map[string]interface{}{
+			"id":      newSourceServerD.NewServer.ID,
+			"name":    newSourceServerD.NewServer.Name,
+			"group":   newSourceServerD.NewServer.Group,
+			"api_key": newSourceServerD.APIKey,
+		},
+	}))
+}
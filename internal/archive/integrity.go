@@ -0,0 +1,138 @@
+package archive
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/ARTM2000/archivo/internal/archive/sourceserver"
+	"github.com/robfig/cron/v3"
+	"gorm.io/gorm"
+)
+
+const defaultScrubInterval = "@daily"
+
+var lastReport *sourceserver.IntegrityReport
+var lastReportMu sync.RWMutex
+
+func lastIntegrityReport() *sourceserver.IntegrityReport {
+	lastReportMu.RLock()
+	defer lastReportMu.RUnlock()
+	return lastReport
+}
+
+func verifyStoreIntegrity(c *Config, db *gorm.DB, signingKey ed25519.PrivateKey) (*sourceserver.IntegrityReport, error) {
+	srcsrvManager := sourceserver.NewSrvManager(
+		sourceserver.SrvConfig{
+			StoreMode:       c.FileStore.Mode,
+			DiskStoreConfig: sourceserver.DiskStoreConfig(c.FileStore.DiskConfig),
+			SigningKey:      signingKey,
+		},
+		sourceserver.NewSrvRepository(db),
+	)
+
+	report, err := srcsrvManager.VerifySnapshots()
+	if err != nil {
+		return nil, err
+	}
+
+	lastReportMu.Lock()
+	lastReport = report
+	lastReportMu.Unlock()
+
+	return report, nil
+}
+
+// startIntegrityScrubber schedules a background job which verifies stored
+// snapshots against their recorded checksums
+func startIntegrityScrubber(c *Config, db *gorm.DB, signingKey ed25519.PrivateKey) (*cron.Cron, error) {
+	interval := c.Integrity.ScrubInterval
+	if interval == "" {
+		interval = defaultScrubInterval
+	}
+
+	scrubCron := cron.New(cron.WithLogger(cron.DefaultLogger), cron.WithChain(cron.SkipIfStillRunning(cron.DefaultLogger)))
+	log.Default().Printf("register integrity scrubber with interval '%s'\n", interval)
+	_, err := scrubCron.AddFunc(interval, func() {
+		log.Default().Println("running integrity scrubber")
+		report, err := verifyStoreIntegrity(c, db, signingKey)
+		if err != nil {
+			log.Default().Printf("integrity scrubber fails. error: [%s]", err.Error())
+			return
+		}
+		log.Default().Printf(
+			"integrity scrubber finished. checked: %d, adopted: %d, failed: %d, mismatches: %d",
+			report.Checked,
+			report.Adopted,
+			report.Failed,
+			len(report.Mismatches),
+		)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	scrubCron.Start()
+	return scrubCron, nil
+}
+
+const defaultSigningKeyFilename = ".archivo-signing.key"
+
+// loadSigningKey reads the server Ed25519 key which snapshot records are
+// signed with. if the key file does not exist, a new key is generated and
+// written to it
+func loadSigningKey(c *Config) (ed25519.PrivateKey, error) {
+	keyPath := c.Integrity.SigningKeyPath
+	if keyPath == "" {
+		return nil, fmt.Errorf("integrity signing_key_path is not set")
+	}
+
+	keyPEM, err := os.ReadFile(keyPath)
+	if err == nil {
+		block, _ := pem.Decode(keyPEM)
+		if block == nil {
+			return nil, fmt.Errorf("signing key '%s' is not a valid pem file", keyPath)
+		}
+		key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("signing key '%s' is not valid. %s", keyPath, err.Error())
+		}
+		edKey, ok := key.(ed25519.PrivateKey)
+		if !ok {
+			return nil, fmt.Errorf("signing key '%s' is not an ed25519 key", keyPath)
+		}
+		return edKey, nil
+	}
+	if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	// older versions kept the key in file store by default. a new key would
+	// invalidate signatures of existing records, so it should be moved instead
+	legacyKeyPath := filepath.Join(c.FileStore.DiskConfig.Path, defaultSigningKeyFilename)
+	if _, err := os.Stat(legacyKeyPath); err == nil {
+		return nil, fmt.Errorf("signing key is found in file store at '%s'. move it to '%s' or set integrity signing_key_path out of file store", legacyKeyPath, keyPath)
+	}
+
+	log.Default().Printf("no signing key found at '%s'. generating new one", keyPath)
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	keyBytes, err := x509.MarshalPKCS8PrivateKey(edKey)
+	if err != nil {
+		return nil, err
+	}
+	keyPEM = pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyBytes})
+	if err := os.WriteFile(keyPath, keyPEM, 0600); err != nil {
+		return nil, err
+	}
+
+	return edKey, nil
+}
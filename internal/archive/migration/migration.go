@@ -0,0 +1,279 @@
+package migration
+
+import (
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+//go:embed postgres/*.sql sqlite/*.sql
+var scripts embed.FS
+
+var (
+	ErrUnknownSchema = errors.New("database schema is newer than this version of archivo")
+	ErrNoMigration   = errors.New("no migration to roll back")
+)
+
+// Migration is a versioned change of database schema. scripts of each
+// dialect are embedded as <version>_<name>.up.sql and <version>_<name>.down.sql
+type Migration struct {
+	Version uint
+	Name    string
+	Up      string
+	Down    string
+}
+
+// SchemaMigration is a migration which is applied on database
+type SchemaMigration struct {
+	Version   uint      `gorm:"primaryKey;autoIncrement:false" json:"version"`
+	Name      string    `gorm:"not null" json:"name"`
+	AppliedAt time.Time `gorm:"not null" json:"applied_at"`
+}
+
+// Status of a known or applied migration
+type Status struct {
+	Version   uint       `json:"version"`
+	Name      string     `json:"name"`
+	AppliedAt *time.Time `json:"applied_at"`
+	// migration is applied on database but is not known by this archivo
+	Unknown bool `json:"unknown"`
+}
+
+// Migrator applies migrations of dialect of db. baseline brings a database
+// which is created before versioned migrations to schema of first migration
+type Migrator struct {
+	db         *gorm.DB
+	migrations []Migration
+	baseline   func(db *gorm.DB) error
+}
+
+func NewMigrator(db *gorm.DB, baseline func(db *gorm.DB) error) (*Migrator, error) {
+	migrations, err := loadMigrations(db.Dialector.Name())
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{
+		db:         db,
+		migrations: migrations,
+		baseline:   baseline,
+	}, nil
+}
+
+func loadMigrations(dialect string) ([]Migration, error) {
+	entries, err := fs.ReadDir(scripts, dialect)
+	if err != nil {
+		return nil, fmt.Errorf("no migrations for database dialect '%s'", dialect)
+	}
+
+	byVersion := map[uint]*Migration{}
+	for _, entry := range entries {
+		filename := entry.Name()
+		var direction string
+		switch {
+		case strings.HasSuffix(filename, ".up.sql"):
+			direction = "up"
+		case strings.HasSuffix(filename, ".down.sql"):
+			direction = "down"
+		default:
+			continue
+		}
+
+		versionPart, name, found := strings.Cut(strings.TrimSuffix(filename, "."+direction+".sql"), "_")
+		version, err := strconv.ParseUint(versionPart, 10, 32)
+		if !found || err != nil {
+			return nil, fmt.Errorf("migration '%s' should be named as <version>_<name>.%s.sql", filename, direction)
+		}
+		script, err := fs.ReadFile(scripts, path.Join(dialect, filename))
+		if err != nil {
+			return nil, err
+		}
+
+		migration, exists := byVersion[uint(version)]
+		if !exists {
+			migration = &Migration{Version: uint(version), Name: name}
+			byVersion[uint(version)] = migration
+		}
+		if direction == "up" {
+			migration.Up = string(script)
+		} else {
+			migration.Down = string(script)
+		}
+	}
+
+	migrations := []Migration{}
+	for _, migration := range byVersion {
+		if migration.Up == "" || migration.Down == "" {
+			return nil, fmt.Errorf("migration %d of '%s' should have both up and down scripts", migration.Version, dialect)
+		}
+		migrations = append(migrations, *migration)
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+	return migrations, nil
+}
+
+// LatestVersion is version of the last migration which is known
+func (m *Migrator) LatestVersion() uint {
+	if len(m.migrations) == 0 {
+		return 0
+	}
+	return m.migrations[len(m.migrations)-1].Version
+}
+
+// prepare creates schema_migrations table. a database which has tables of
+// archivo but no schema_migrations is created by auto migration of older
+// versions, so it is brought to first migration by baseline and recorded
+func (m *Migrator) prepare() error {
+	if m.db.Migrator().HasTable(&SchemaMigration{}) {
+		return nil
+	}
+
+	legacy := m.db.Migrator().HasTable("users")
+	if err := m.db.Migrator().CreateTable(&SchemaMigration{}); err != nil {
+		return err
+	}
+	if !legacy || len(m.migrations) == 0 {
+		return nil
+	}
+
+	first := m.migrations[0]
+	log.Default().Printf("database is created before versioned migrations, recording it as migration %d '%s'\n", first.Version, first.Name)
+	if m.baseline != nil {
+		if err := m.baseline(m.db); err != nil {
+			return fmt.Errorf("unable to bring database to migration %d, error: %s", first.Version, err.Error())
+		}
+	}
+	return m.db.Create(&SchemaMigration{Version: first.Version, Name: first.Name, AppliedAt: time.Now()}).Error
+}
+
+func (m *Migrator) applied() ([]SchemaMigration, error) {
+	if err := m.prepare(); err != nil {
+		return nil, err
+	}
+	var applied []SchemaMigration
+	if err := m.db.Model(&SchemaMigration{}).Order("version").Find(&applied).Error; err != nil {
+		return nil, err
+	}
+	return applied, nil
+}
+
+// CurrentVersion is version of the last migration which is applied on database
+func (m *Migrator) CurrentVersion() (uint, error) {
+	applied, err := m.applied()
+	if err != nil {
+		return 0, err
+	}
+	if len(applied) == 0 {
+		return 0, nil
+	}
+	return applied[len(applied)-1].Version, nil
+}
+
+// Up applies all pending migrations in order. each migration is applied in
+// a transaction along with its record. database with a migration which is
+// not known is not touched
+func (m *Migrator) Up() ([]Migration, error) {
+	current, err := m.CurrentVersion()
+	if err != nil {
+		return nil, err
+	}
+	if current > m.LatestVersion() {
+		return nil, fmt.Errorf("%w. database version: %d, latest known version: %d", ErrUnknownSchema, current, m.LatestVersion())
+	}
+
+	done := []Migration{}
+	for _, migration := range m.migrations {
+		if migration.Version <= current {
+			continue
+		}
+		log.Default().Printf("applying migration %d '%s'\n", migration.Version, migration.Name)
+		err := m.db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Exec(migration.Up).Error; err != nil {
+				return err
+			}
+			return tx.Create(&SchemaMigration{Version: migration.Version, Name: migration.Name, AppliedAt: time.Now()}).Error
+		})
+		if err != nil {
+			return done, fmt.Errorf("migration %d '%s' fails, error: %s", migration.Version, migration.Name, err.Error())
+		}
+		done = append(done, migration)
+	}
+	return done, nil
+}
+
+// Down rolls back the last applied migration
+func (m *Migrator) Down() (*Migration, error) {
+	current, err := m.CurrentVersion()
+	if err != nil {
+		return nil, err
+	}
+	if current == 0 {
+		return nil, ErrNoMigration
+	}
+
+	var migration *Migration
+	for i := range m.migrations {
+		if m.migrations[i].Version == current {
+			migration = &m.migrations[i]
+		}
+	}
+	if migration == nil {
+		return nil, fmt.Errorf("%w. migration %d can not be rolled back", ErrUnknownSchema, current)
+	}
+
+	log.Default().Printf("rolling back migration %d '%s'\n", migration.Version, migration.Name)
+	err = m.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec(migration.Down).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&SchemaMigration{}, migration.Version).Error
+	})
+	if err != nil {
+		return nil, fmt.Errorf("rolling back migration %d '%s' fails, error: %s", migration.Version, migration.Name, err.Error())
+	}
+	return migration, nil
+}
+
+// Status lists known migrations along with applied ones which are not known
+func (m *Migrator) Status() ([]Status, error) {
+	applied, err := m.applied()
+	if err != nil {
+		return nil, err
+	}
+	appliedAt := map[uint]time.Time{}
+	for _, a := range applied {
+		appliedAt[a.Version] = a.AppliedAt
+	}
+
+	statuses := []Status{}
+	known := map[uint]bool{}
+	for _, migration := range m.migrations {
+		known[migration.Version] = true
+		status := Status{Version: migration.Version, Name: migration.Name}
+		if at, exists := appliedAt[migration.Version]; exists {
+			status.AppliedAt = &at
+		}
+		statuses = append(statuses, status)
+	}
+	for _, a := range applied {
+		if known[a.Version] {
+			continue
+		}
+		at := a.AppliedAt
+		statuses = append(statuses, Status{Version: a.Version, Name: a.Name, AppliedAt: &at, Unknown: true})
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].Version < statuses[j].Version
+	})
+	return statuses, nil
+}
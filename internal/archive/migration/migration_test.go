@@ -0,0 +1,174 @@
+package migration
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func openTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	dsn := "file:" + filepath.Join(t.TempDir(), "archivo.db") + "?_pragma=foreign_keys(1)&_txlock=immediate"
+	db, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{Logger: logger.Discard, TranslateError: true})
+	if err != nil {
+		t.Fatalf("open database: %s", err)
+	}
+	return db
+}
+
+func TestDialectsHaveSameMigrations(t *testing.T) {
+	postgres, err := loadMigrations("postgres")
+	if err != nil {
+		t.Fatalf("load postgres migrations: %s", err)
+	}
+	sqlite, err := loadMigrations("sqlite")
+	if err != nil {
+		t.Fatalf("load sqlite migrations: %s", err)
+	}
+	if len(postgres) != len(sqlite) {
+		t.Fatalf("postgres has %d migrations, sqlite has %d", len(postgres), len(sqlite))
+	}
+	for i := range postgres {
+		if postgres[i].Version != sqlite[i].Version || postgres[i].Name != sqlite[i].Name {
+			t.Errorf("migration %d differs: postgres %d '%s', sqlite %d '%s'", i, postgres[i].Version, postgres[i].Name, sqlite[i].Version, sqlite[i].Name)
+		}
+	}
+}
+
+func TestUpAndDown(t *testing.T) {
+	db := openTestDB(t)
+	migrator, err := NewMigrator(db, nil)
+	if err != nil {
+		t.Fatalf("new migrator: %s", err)
+	}
+
+	applied, err := migrator.Up()
+	if err != nil {
+		t.Fatalf("up: %s", err)
+	}
+	if len(applied) != len(migrator.migrations) {
+		t.Fatalf("applied %d migrations, want %d", len(applied), len(migrator.migrations))
+	}
+	current, err := migrator.CurrentVersion()
+	if err != nil {
+		t.Fatalf("current version: %s", err)
+	}
+	if current != migrator.LatestVersion() {
+		t.Fatalf("current version is %d, want %d", current, migrator.LatestVersion())
+	}
+	for _, table := range []string{"users", "source_servers", "snapshots", "snapshot_terms"} {
+		if !db.Migrator().HasTable(table) {
+			t.Errorf("table '%s' is not created", table)
+		}
+	}
+
+	// applying again is a no-op
+	applied, err = migrator.Up()
+	if err != nil || len(applied) != 0 {
+		t.Fatalf("second up applied %d migrations, error: %v", len(applied), err)
+	}
+
+	for i := len(migrator.migrations) - 1; i >= 0; i-- {
+		rolledBack, err := migrator.Down()
+		if err != nil {
+			t.Fatalf("down: %s", err)
+		}
+		if rolledBack.Version != migrator.migrations[i].Version {
+			t.Fatalf("rolled back migration %d, want %d", rolledBack.Version, migrator.migrations[i].Version)
+		}
+	}
+	if db.Migrator().HasTable("users") {
+		t.Error("table 'users' is not dropped")
+	}
+	if _, err := migrator.Down(); !errors.Is(err, ErrNoMigration) {
+		t.Fatalf("down without migrations returned %v, want %v", err, ErrNoMigration)
+	}
+
+	if _, err := migrator.Up(); err != nil {
+		t.Fatalf("up after down: %s", err)
+	}
+}
+
+func TestStatus(t *testing.T) {
+	db := openTestDB(t)
+	migrator, err := NewMigrator(db, nil)
+	if err != nil {
+		t.Fatalf("new migrator: %s", err)
+	}
+
+	statuses, err := migrator.Status()
+	if err != nil {
+		t.Fatalf("status: %s", err)
+	}
+	for _, status := range statuses {
+		if status.AppliedAt != nil {
+			t.Errorf("migration %d is applied before up", status.Version)
+		}
+	}
+
+	if _, err := migrator.Up(); err != nil {
+		t.Fatalf("up: %s", err)
+	}
+	statuses, err = migrator.Status()
+	if err != nil {
+		t.Fatalf("status: %s", err)
+	}
+	for _, status := range statuses {
+		if status.AppliedAt == nil || status.Unknown {
+			t.Errorf("migration %d is not reported as applied", status.Version)
+		}
+	}
+}
+
+func TestUpRefusesUnknownSchema(t *testing.T) {
+	db := openTestDB(t)
+	migrator, err := NewMigrator(db, nil)
+	if err != nil {
+		t.Fatalf("new migrator: %s", err)
+	}
+	if _, err := migrator.Up(); err != nil {
+		t.Fatalf("up: %s", err)
+	}
+
+	newer := SchemaMigration{Version: migrator.LatestVersion() + 100, Name: "from_future", AppliedAt: time.Now()}
+	if err := db.Create(&newer).Error; err != nil {
+		t.Fatalf("record newer migration: %s", err)
+	}
+	if _, err := migrator.Up(); !errors.Is(err, ErrUnknownSchema) {
+		t.Fatalf("up returned %v, want %v", err, ErrUnknownSchema)
+	}
+	if _, err := migrator.Down(); !errors.Is(err, ErrUnknownSchema) {
+		t.Fatalf("down returned %v, want %v", err, ErrUnknownSchema)
+	}
+}
+
+func TestBaselineOfLegacyDatabase(t *testing.T) {
+	db := openTestDB(t)
+	if err := db.Exec(`CREATE TABLE "users" ("id" integer PRIMARY KEY)`).Error; err != nil {
+		t.Fatalf("create legacy table: %s", err)
+	}
+
+	baselined := false
+	migrator, err := NewMigrator(db, func(db *gorm.DB) error {
+		baselined = true
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("new migrator: %s", err)
+	}
+	current, err := migrator.CurrentVersion()
+	if err != nil {
+		t.Fatalf("current version: %s", err)
+	}
+	if !baselined {
+		t.Fatal("baseline is not run for legacy database")
+	}
+	if current != migrator.migrations[0].Version {
+		t.Fatalf("legacy database is recorded as version %d, want %d", current, migrator.migrations[0].Version)
+	}
+}
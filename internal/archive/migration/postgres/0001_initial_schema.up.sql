@@ -0,0 +1,151 @@
+-- baseline schema as of the first versioned migration. databases created by
+-- auto migration of older versions are brought up to it by legacyAutoMigrate
+
+CREATE TABLE "users" (
+    "id" bigserial UNIQUE,
+    "username" text NOT NULL UNIQUE,
+    "email" text NOT NULL UNIQUE,
+    "hashed_password" text NOT NULL,
+    "is_admin" boolean NOT NULL DEFAULT false,
+    "change_initial_password" boolean NOT NULL,
+    "last_login_at" timestamptz,
+    "created_at" timestamptz,
+    "updated_at" timestamptz,
+    "deleted_at" timestamptz,
+    PRIMARY KEY ("id")
+);
+
+CREATE TABLE "user_activities" (
+    "id" bigserial UNIQUE,
+    "user_id" bigint,
+    "act" text NOT NULL,
+    "ip" text,
+    "created_at" timestamptz,
+    PRIMARY KEY ("id"),
+    CONSTRAINT "fk_users_activities" FOREIGN KEY ("user_id") REFERENCES "users"("id")
+);
+
+CREATE TABLE "source_servers" (
+    "id" bigserial NOT NULL,
+    "name" text NOT NULL UNIQUE,
+    "hashed_api_key" text NOT NULL,
+    "legal_hold" boolean NOT NULL DEFAULT false,
+    "legal_hold_note" text NOT NULL DEFAULT '',
+    "legal_hold_at" timestamptz,
+    "quota_bytes" bigint,
+    "quota_snapshots" bigint,
+    "max_upload_bytes" bigint,
+    "group" text NOT NULL DEFAULT '',
+    "config_revision" text NOT NULL DEFAULT '',
+    "config_seen_at" timestamptz,
+    "created_at" timestamptz,
+    PRIMARY KEY ("id")
+);
+
+CREATE INDEX "idx_source_servers_group" ON "source_servers" ("group");
+
+CREATE TABLE "snapshots" (
+    "id" bigserial NOT NULL,
+    "source_server_id" bigint NOT NULL,
+    "filename" text NOT NULL,
+    "name" text NOT NULL,
+    "checksum" text NOT NULL,
+    "byte_size" bigint NOT NULL,
+    "corrupted" boolean NOT NULL DEFAULT false,
+    "verified_at" timestamptz,
+    "prev_hash" text NOT NULL DEFAULT '',
+    "record_hash" text NOT NULL DEFAULT '',
+    "signature" text NOT NULL DEFAULT '',
+    "pinned" boolean NOT NULL DEFAULT false,
+    "pin_note" text NOT NULL DEFAULT '',
+    "pinned_by" bigint,
+    "pinned_at" timestamptz,
+    "source_path" text NOT NULL DEFAULT '',
+    "mode" bigint,
+    "uid" bigint,
+    "gid" bigint,
+    "owner_name" text NOT NULL DEFAULT '',
+    "group_name" text NOT NULL DEFAULT '',
+    "mod_time" timestamptz,
+    "host" text NOT NULL DEFAULT '',
+    "created_at" timestamptz,
+    "deleted_at" timestamptz,
+    PRIMARY KEY ("id")
+);
+
+CREATE UNIQUE INDEX "idx_snapshot_file_name" ON "snapshots" ("source_server_id","filename","name");
+
+CREATE TABLE "restore_jobs" (
+    "id" bigserial NOT NULL,
+    "source_server_id" bigint NOT NULL,
+    "filename" text NOT NULL,
+    "snapshot" text NOT NULL,
+    "status" text NOT NULL,
+    "message" text NOT NULL DEFAULT '',
+    "requested_by" bigint NOT NULL,
+    "created_at" timestamptz,
+    "started_at" timestamptz,
+    "finished_at" timestamptz,
+    PRIMARY KEY ("id")
+);
+
+CREATE INDEX "idx_restore_jobs_source_server_id" ON "restore_jobs" ("source_server_id");
+
+CREATE INDEX "idx_restore_jobs_status" ON "restore_jobs" ("status");
+
+CREATE TABLE "managed_configs" (
+    "id" bigserial NOT NULL,
+    "scope" text NOT NULL,
+    "scope_key" text NOT NULL,
+    "files" text NOT NULL,
+    "updated_by" bigint NOT NULL,
+    "updated_at" timestamptz,
+    PRIMARY KEY ("id")
+);
+
+CREATE UNIQUE INDEX "idx_managed_config_scope" ON "managed_configs" ("scope","scope_key");
+
+CREATE TABLE "heartbeats" (
+    "id" bigserial NOT NULL,
+    "source_server_id" bigint NOT NULL,
+    "version" text NOT NULL DEFAULT '',
+    "hostname" text NOT NULL DEFAULT '',
+    "os" text NOT NULL DEFAULT '',
+    "arch" text NOT NULL DEFAULT '',
+    "config_revision" text NOT NULL DEFAULT '',
+    "pending_uploads" bigint NOT NULL DEFAULT 0,
+    "running_uploads" bigint NOT NULL DEFAULT 0,
+    "failing_files" bigint NOT NULL DEFAULT 0,
+    "unreachable_files" bigint NOT NULL DEFAULT 0,
+    "files" text NOT NULL,
+    "received_at" timestamptz NOT NULL,
+    PRIMARY KEY ("id")
+);
+
+CREATE UNIQUE INDEX "idx_heartbeats_source_server_id" ON "heartbeats" ("source_server_id");
+
+CREATE TABLE "enrollment_tokens" (
+    "id" bigserial NOT NULL,
+    "note" text NOT NULL DEFAULT '',
+    "hashed_token" text NOT NULL UNIQUE,
+    "group" text NOT NULL DEFAULT '',
+    "max_uses" bigint NOT NULL,
+    "uses" bigint NOT NULL DEFAULT 0,
+    "expires_at" timestamptz NOT NULL,
+    "revoked_at" timestamptz,
+    "created_by" bigint NOT NULL,
+    "created_at" timestamptz,
+    PRIMARY KEY ("id")
+);
+
+CREATE TABLE "agent_certificates" (
+    "id" bigserial NOT NULL,
+    "serial" text NOT NULL UNIQUE,
+    "source_server_id" bigint NOT NULL,
+    "not_after" timestamptz NOT NULL,
+    "revoked_at" timestamptz,
+    "created_at" timestamptz,
+    PRIMARY KEY ("id")
+);
+
+CREATE INDEX "idx_agent_certificates_source_server_id" ON "agent_certificates" ("source_server_id");
@@ -0,0 +1,9 @@
+-- inverted index of text snapshots for full text search
+
+CREATE TABLE IF NOT EXISTS "snapshot_terms" (
+    "term" text NOT NULL,
+    "snapshot_id" bigint NOT NULL,
+    PRIMARY KEY ("term","snapshot_id")
+);
+
+CREATE INDEX IF NOT EXISTS "idx_snapshot_terms_snapshot_id" ON "snapshot_terms" ("snapshot_id");
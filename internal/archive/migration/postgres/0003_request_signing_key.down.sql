@@ -0,0 +1 @@
+ALTER TABLE "source_servers" DROP COLUMN IF EXISTS "request_signing_key";
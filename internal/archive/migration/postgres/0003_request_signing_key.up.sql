@@ -0,0 +1,4 @@
+-- wrapped key which agents sign requests by, apart from hashed api key.
+-- databases brought by legacy auto migration may already have it
+
+ALTER TABLE "source_servers" ADD COLUMN IF NOT EXISTS "request_signing_key" text NOT NULL DEFAULT '';
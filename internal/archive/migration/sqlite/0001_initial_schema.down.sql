@@ -0,0 +1,9 @@
+DROP TABLE IF EXISTS "agent_certificates";
+DROP TABLE IF EXISTS "enrollment_tokens";
+DROP TABLE IF EXISTS "heartbeats";
+DROP TABLE IF EXISTS "managed_configs";
+DROP TABLE IF EXISTS "restore_jobs";
+DROP TABLE IF EXISTS "snapshots";
+DROP TABLE IF EXISTS "source_servers";
+DROP TABLE IF EXISTS "user_activities";
+DROP TABLE IF EXISTS "users";
@@ -0,0 +1,151 @@
+-- baseline schema as of the first versioned migration. databases created by
+-- auto migration of older versions are brought up to it by legacyAutoMigrate
+
+CREATE TABLE "users" (
+    "id" integer UNIQUE,
+    "username" text NOT NULL UNIQUE,
+    "email" text NOT NULL UNIQUE,
+    "hashed_password" text NOT NULL,
+    "is_admin" numeric NOT NULL DEFAULT false,
+    "change_initial_password" numeric NOT NULL,
+    "last_login_at" datetime,
+    "created_at" datetime,
+    "updated_at" datetime,
+    "deleted_at" datetime,
+    PRIMARY KEY ("id")
+);
+
+CREATE TABLE "user_activities" (
+    "id" integer UNIQUE,
+    "user_id" integer,
+    "act" text NOT NULL,
+    "ip" text,
+    "created_at" datetime,
+    PRIMARY KEY ("id"),
+    CONSTRAINT "fk_users_activities" FOREIGN KEY ("user_id") REFERENCES "users"("id")
+);
+
+CREATE TABLE "source_servers" (
+    "id" integer NOT NULL,
+    "name" text NOT NULL UNIQUE,
+    "hashed_api_key" text NOT NULL,
+    "legal_hold" numeric NOT NULL DEFAULT false,
+    "legal_hold_note" text NOT NULL DEFAULT '',
+    "legal_hold_at" datetime,
+    "quota_bytes" integer,
+    "quota_snapshots" integer,
+    "max_upload_bytes" integer,
+    "group" text NOT NULL DEFAULT '',
+    "config_revision" text NOT NULL DEFAULT '',
+    "config_seen_at" datetime,
+    "created_at" datetime,
+    PRIMARY KEY ("id")
+);
+
+CREATE INDEX "idx_source_servers_group" ON "source_servers" ("group");
+
+CREATE TABLE "snapshots" (
+    "id" integer NOT NULL,
+    "source_server_id" integer NOT NULL,
+    "filename" text NOT NULL,
+    "name" text NOT NULL,
+    "checksum" text NOT NULL,
+    "byte_size" integer NOT NULL,
+    "corrupted" numeric NOT NULL DEFAULT false,
+    "verified_at" datetime,
+    "prev_hash" text NOT NULL DEFAULT '',
+    "record_hash" text NOT NULL DEFAULT '',
+    "signature" text NOT NULL DEFAULT '',
+    "pinned" numeric NOT NULL DEFAULT false,
+    "pin_note" text NOT NULL DEFAULT '',
+    "pinned_by" integer,
+    "pinned_at" datetime,
+    "source_path" text NOT NULL DEFAULT '',
+    "mode" integer,
+    "uid" integer,
+    "gid" integer,
+    "owner_name" text NOT NULL DEFAULT '',
+    "group_name" text NOT NULL DEFAULT '',
+    "mod_time" datetime,
+    "host" text NOT NULL DEFAULT '',
+    "created_at" datetime,
+    "deleted_at" datetime,
+    PRIMARY KEY ("id")
+);
+
+CREATE UNIQUE INDEX "idx_snapshot_file_name" ON "snapshots" ("source_server_id","filename","name");
+
+CREATE TABLE "restore_jobs" (
+    "id" integer NOT NULL,
+    "source_server_id" integer NOT NULL,
+    "filename" text NOT NULL,
+    "snapshot" text NOT NULL,
+    "status" text NOT NULL,
+    "message" text NOT NULL DEFAULT '',
+    "requested_by" integer NOT NULL,
+    "created_at" datetime,
+    "started_at" datetime,
+    "finished_at" datetime,
+    PRIMARY KEY ("id")
+);
+
+CREATE INDEX "idx_restore_jobs_source_server_id" ON "restore_jobs" ("source_server_id");
+
+CREATE INDEX "idx_restore_jobs_status" ON "restore_jobs" ("status");
+
+CREATE TABLE "managed_configs" (
+    "id" integer NOT NULL,
+    "scope" text NOT NULL,
+    "scope_key" text NOT NULL,
+    "files" text NOT NULL,
+    "updated_by" integer NOT NULL,
+    "updated_at" datetime,
+    PRIMARY KEY ("id")
+);
+
+CREATE UNIQUE INDEX "idx_managed_config_scope" ON "managed_configs" ("scope","scope_key");
+
+CREATE TABLE "heartbeats" (
+    "id" integer NOT NULL,
+    "source_server_id" integer NOT NULL,
+    "version" text NOT NULL DEFAULT '',
+    "hostname" text NOT NULL DEFAULT '',
+    "os" text NOT NULL DEFAULT '',
+    "arch" text NOT NULL DEFAULT '',
+    "config_revision" text NOT NULL DEFAULT '',
+    "pending_uploads" integer NOT NULL DEFAULT 0,
+    "running_uploads" integer NOT NULL DEFAULT 0,
+    "failing_files" integer NOT NULL DEFAULT 0,
+    "unreachable_files" integer NOT NULL DEFAULT 0,
+    "files" text NOT NULL,
+    "received_at" datetime NOT NULL,
+    PRIMARY KEY ("id")
+);
+
+CREATE UNIQUE INDEX "idx_heartbeats_source_server_id" ON "heartbeats" ("source_server_id");
+
+CREATE TABLE "enrollment_tokens" (
+    "id" integer NOT NULL,
+    "note" text NOT NULL DEFAULT '',
+    "hashed_token" text NOT NULL UNIQUE,
+    "group" text NOT NULL DEFAULT '',
+    "max_uses" integer NOT NULL,
+    "uses" integer NOT NULL DEFAULT 0,
+    "expires_at" datetime NOT NULL,
+    "revoked_at" datetime,
+    "created_by" integer NOT NULL,
+    "created_at" datetime,
+    PRIMARY KEY ("id")
+);
+
+CREATE TABLE "agent_certificates" (
+    "id" integer NOT NULL,
+    "serial" text NOT NULL UNIQUE,
+    "source_server_id" integer NOT NULL,
+    "not_after" datetime NOT NULL,
+    "revoked_at" datetime,
+    "created_at" datetime,
+    PRIMARY KEY ("id")
+);
+
+CREATE INDEX "idx_agent_certificates_source_server_id" ON "agent_certificates" ("source_server_id");
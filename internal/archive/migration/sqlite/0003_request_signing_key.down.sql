@@ -0,0 +1 @@
+ALTER TABLE "source_servers" DROP COLUMN "request_signing_key";
@@ -0,0 +1,3 @@
+-- wrapped key which agents sign requests by, apart from hashed api key
+
+ALTER TABLE "source_servers" ADD COLUMN "request_signing_key" text NOT NULL DEFAULT '';
@@ -0,0 +1,80 @@
+package archive
+
+import (
+	"errors"
+	"log"
+
+	"github.com/ARTM2000/archivo/internal/archive/auth"
+	"github.com/ARTM2000/archivo/internal/archive/sourceserver"
+	"github.com/ARTM2000/archivo/internal/archive/xerrors"
+	"github.com/ARTM2000/archivo/internal/validate"
+	"github.com/gofiber/fiber/v2"
+)
+
+const defaultSearchLimit = 50
+
+type searchData struct {
+	// glob pattern of filename or source file, e.g. nginx*.conf
+	Pattern string `query:"pattern" validate:"omitempty,max=255"`
+	// text which snapshots should contain
+	Query string `query:"q" validate:"omitempty,max=255"`
+	Limit *int   `query:"limit" validate:"omitempty,min=1,max=500"`
+}
+
+func (api *API) search(c *fiber.Ctx) error {
+	var sData searchData
+	if err := c.QueryParser(&sData); err != nil {
+		log.Default().Println(err.Error())
+		return fiber.NewError(fiber.StatusBadRequest, err.Error())
+	}
+	if errs, ok := validate.ValidateStruct[searchData](&sData); !ok {
+		log.Default().Println(errs[0].Message)
+		return fiber.NewError(fiber.StatusUnprocessableEntity, errs[0].Message)
+	}
+
+	// every user can browse files and download a snapshot they have picked,
+	// like the dashboard allows. full text search instead sweeps content of
+	// all snapshots of every source server for a text (e.g. a password) and
+	// returns the matching lines in one request, so it is only permitted to
+	// admin. pattern search only lists file names, which are browsable too
+	user := c.Locals(UserLocalName).(*auth.User)
+	if sData.Query != "" && !user.IsAdmin {
+		log.Default().Printf("full text search by non admin user '%s' is rejected", user.Username)
+		return fiber.NewError(fiber.StatusForbidden, "full text search is only permitted to admin")
+	}
+
+	limit := defaultSearchLimit
+	if sData.Limit != nil {
+		limit = *sData.Limit
+	}
+
+	srcsrvManager := sourceserver.NewSrvManager(
+		sourceserver.SrvConfig{
+			CorrelationId:   c.GetRespHeader(fiber.HeaderXRequestID),
+			StoreMode:       api.Config.FileStore.Mode,
+			DiskStoreConfig: sourceserver.DiskStoreConfig(api.Config.FileStore.DiskConfig),
+		},
+		sourceserver.NewSrvRepository(api.DB),
+	)
+
+	result, err := srcsrvManager.Search(sourceserver.SearchOption{
+		Pattern: sData.Pattern,
+		Query:   sData.Query,
+		Limit:   limit,
+	})
+	if err != nil {
+		log.Default().Printf("error in searching by pattern '%s' and query '%s'. error: %s", sData.Pattern, sData.Query, err.Error())
+		if errors.Is(err, xerrors.ErrInvalidSearchPattern) || errors.Is(err, xerrors.ErrSearchQueryTooShort) {
+			return fiber.NewError(fiber.StatusUnprocessableEntity, err.Error())
+		}
+		return fiber.NewError(fiber.StatusInternalServerError, "internal server error")
+	}
+
+	return c.Status(fiber.StatusOK).JSON(FormatResponse(c, Data{
+		Data: map[string]interface{}{
+			"files":     result.Files,
+			"matches":   result.Matches,
+			"truncated": result.Truncated,
+		},
+	}))
+}
@@ -1,10 +1,15 @@
 package archive
 
 import (
+	"crypto/ed25519"
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"log"
+	"sync/atomic"
 
+	"github.com/ARTM2000/archivo/internal/archive/sourceserver"
+	"github.com/ARTM2000/archivo/internal/processmng"
 	"github.com/ARTM2000/archivo/web"
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/middleware/cors"
@@ -23,6 +28,16 @@ func runServer(c *Config) {
 		AppName:                      "Archivo",
 		DisablePreParseMultipartForm: true,
 		DisableStartupMessage:        true,
+		// bodies bigger than BodyLimit are streamed instead of being read into
+		// memory. uploaded files are written to temporary files while parsing
+		StreamRequestBody: true,
+		BodyLimit:         c.Upload.MemoryBodyLimit(),
+		// client ip is taken from proxy header only for requests of trusted
+		// proxies, and is used in logs and user activities
+		EnableTrustedProxyCheck: len(c.HTTP.TrustedProxies) > 0,
+		TrustedProxies:          c.HTTP.TrustedProxies,
+		ProxyHeader:             c.HTTP.ClientIPHeader(),
+		EnableIPValidation:      true,
 		ErrorHandler: func(c *fiber.Ctx, err error) error {
 			code := fiber.StatusInternalServerError
 
@@ -42,23 +57,42 @@ func runServer(c *Config) {
 		},
 	}
 	sessionStore := session.New(session.Config{
-		Expiration: c.Auth.JWTExpireTime,
+		Expiration:     c.Auth.JWTExpireTime,
+		CookieSecure:   c.SecureCookie(),
+		CookieHTTPOnly: true,
+		CookieSameSite: c.HTTP.SameSite(),
 	})
 
 	app := fiber.New(sConfig)
 
 	api := API{
-		DB: NewDBConnection(DBConfig{
-			DBHost:    c.Database.Host,
-			DBPort:    c.Database.Port,
-			DBUser:    c.Database.Username,
-			DBPass:    c.Database.Password,
-			DBName:    c.Database.Name,
-			DBZone:    c.Database.Zone,
-			DBSSLMode: c.Database.SSLMode,
-		}),
+		DB:           NewDBConnection(NewDBConfig(c)),
 		Config:       c,
 		SessionStore: sessionStore,
+		Nonces:       newNonceCache(),
+	}
+
+	signingKey, err := loadSigningKey(c)
+	if err != nil {
+		log.Fatalln("error in loading signing key.", err.Error())
+	}
+	api.SigningKey = signingKey
+
+	// clean up leftovers of interrupted uploads before accepting new ones
+	srcsrvManager := sourceserver.NewSrvManager(
+		sourceserver.SrvConfig{
+			StoreMode:       c.FileStore.Mode,
+			DiskStoreConfig: sourceserver.DiskStoreConfig(c.FileStore.DiskConfig),
+		},
+		sourceserver.NewSrvRepository(api.DB),
+	)
+	if err := srcsrvManager.RecoverStore(); err != nil {
+		log.Fatalln("error in recovering file store.", err.Error())
+	}
+
+	scrubber, err := startIntegrityScrubber(c, api.DB, api.SigningKey)
+	if err != nil {
+		log.Fatalln("error in starting integrity scrubber.", err.Error())
 	}
 
 	/**
@@ -82,7 +116,7 @@ func runServer(c *Config) {
 	}))
 	app.Use(helmet.New())
 	app.Use(cors.New(cors.Config{
-		AllowOrigins:     "http://localhost:5173,http://127.0.0.1:5173",
+		AllowOrigins:     c.HTTP.AllowedOrigins(),
 		AllowHeaders:     "Origin, Content-Type, Accept, Authorization, X-Request-ID",
 		ExposeHeaders:    "Content-Length,Content-Disposition,Content-Type",
 		AllowCredentials: true,
@@ -92,12 +126,14 @@ func runServer(c *Config) {
 		c.Accepts(fiber.MIMEApplicationJSON, fiber.MIMEMultipartForm)
 		return c.Next()
 	})
+	app.Use(api.requestBodyLimitMiddleware)
 
 	app.Get("/healthz", func(c *fiber.Ctx) error {
 		return c.Status(fiber.StatusOK).JSON(FormatResponse(c, Data{
 			Message: "everything is fine",
 		}))
 	})
+	app.Get("/readyz", api.readiness)
 
 	app.Route("/api/v1", func(router fiber.Router) {
 		router.Route("/pre-auth", func(rt fiber.Router) {
@@ -121,14 +157,43 @@ func runServer(c *Config) {
 		router.Route("/servers", func(rtr fiber.Router) {
 			rtr.Route("/store", func(rt fiber.Router) {
 				rt.Use(api.authorizeSourceServerMiddleware)
-				rt.Post("/file", api.rotateSrcSrvFile)
+				rt.Get("/limits", api.getSourceServerLimits)
+				rt.Get("/file", api.readSrcSrvSnapshot)
+				rt.Get("/restore-jobs/next", api.nextRestoreJob)
+				rt.Get("/config", api.getAgentManagedConfig)
+				rt.Post("/heartbeat", api.receiveHeartbeat)
+				rt.Post("/restore-jobs/:jobId/report", api.reportRestoreJob)
+				rt.Post("/file", api.uploadLimitMiddleware, api.rotateSrcSrvFile)
 			})
+			// agents which are not registered yet authorize by enrollment token
+			rtr.Post("/enroll", api.enrollSourceServer)
 			rtr.Use(api.authorizationMiddleware)
 			rtr.Get("/", api.getListOfSourceServers)
 			rtr.Post("/new", api.registerNewSourceServer)
+			rtr.Get("/integrity", api.getCorruptedSnapshots)
+			rtr.Get("/:srvId/export", api.exportSourceServer)
 			rtr.Get("/:srvId/files", api.getSourceServerFilesList)
 			rtr.Get("/:srvId/files/:filename", api.getListOfFileSnapshots)
+			rtr.Get("/:srvId/files/:filename/manifest", api.exportFileManifest)
 			rtr.Get("/:srvId/files/:filename/:snapshot/download", api.downloadSnapshot)
+			rtr.Get("/:srvId/restore-jobs", api.getListOfRestoreJobs)
+			rtr.Get("/:srvId/managed-config", api.getManagedConfig)
+			rtr.Get("/:srvId/heartbeat", api.getSourceServerHeartbeat)
+			// admin only
+			rtr.Post("/:srvId/files/:filename/:snapshot/pin", api.adminAuthorizationMiddleware, api.pinSnapshot)
+			rtr.Delete("/:srvId/files/:filename/:snapshot/pin", api.adminAuthorizationMiddleware, api.unpinSnapshot)
+			rtr.Post("/:srvId/files/:filename/:snapshot/restore", api.adminAuthorizationMiddleware, api.createRestoreJob)
+			rtr.Post("/:srvId/legal-hold", api.adminAuthorizationMiddleware, api.placeLegalHold)
+			rtr.Delete("/:srvId/legal-hold", api.adminAuthorizationMiddleware, api.releaseLegalHold)
+			rtr.Put("/:srvId/quota", api.adminAuthorizationMiddleware, api.setSourceServerQuota)
+			rtr.Put("/:srvId/max-upload", api.adminAuthorizationMiddleware, api.setSourceServerMaxUpload)
+			rtr.Put("/:srvId/managed-config", api.adminAuthorizationMiddleware, api.setManagedFiles)
+			rtr.Put("/:srvId/group", api.adminAuthorizationMiddleware, api.setSourceServerGroup)
+			rtr.Get("/groups/:group/managed-config", api.adminAuthorizationMiddleware, api.getGroupManagedFiles)
+			rtr.Put("/groups/:group/managed-config", api.adminAuthorizationMiddleware, api.setGroupManagedFiles)
+			rtr.Get("/enrollment-tokens", api.adminAuthorizationMiddleware, api.getListOfEnrollmentTokens)
+			rtr.Post("/enrollment-tokens", api.adminAuthorizationMiddleware, api.createEnrollmentToken)
+			rtr.Delete("/enrollment-tokens/:tokenId", api.adminAuthorizationMiddleware, api.revokeEnrollmentToken)
 		})
 
 		router.Route("/users", func(rtr fiber.Router) {
@@ -140,6 +205,11 @@ func runServer(c *Config) {
 			rtr.Post("/register", api.registerUser)
 		})
 
+		router.Route("/search", func(rtr fiber.Router) {
+			rtr.Use(api.authorizationMiddleware)
+			rtr.Get("/", api.search)
+		})
+
 		router.Route("/dashboard", func(rtr fiber.Router) {
 			rtr.Use(api.authorizationMiddleware)
 			rtr.Get("/metrics/common", api.storeCommonStatistics)
@@ -174,10 +244,50 @@ func runServer(c *Config) {
 		return nil
 	})
 
-	err := app.Listen(fmt.Sprintf("%s:%d", host, port))
+	drained := make(chan struct{})
+	go processmng.OnInterrupt(func() {
+		api.drain(app)
+		close(drained)
+	})
+
+	addr := fmt.Sprintf("%s:%d", host, port)
+	if c.TLS.CertFile == "" {
+		err = app.Listen(addr)
+	} else {
+		certs, certErr := newCertificateReloader(c.TLS.CertFile, c.TLS.KeyFile)
+		if certErr != nil {
+			log.Fatalln("error in loading tls certificate.", certErr.Error())
+		}
+		// renewed certificate is loaded on hangup signal
+		go processmng.OnHangup(func() {
+			if err := certs.reload(); err != nil {
+				log.Default().Printf("unable to reload tls certificate, current one is kept. error: %s", err.Error())
+				return
+			}
+			log.Default().Println("tls certificate reloaded")
+		})
+		tlsConfig, tlsErr := serverTLSConfig(c, certs)
+		if tlsErr != nil {
+			log.Fatalln("error in loading tls config.", tlsErr.Error())
+		}
+		if c.TLS.RedirectHTTPAddr != "" {
+			go redirectToHTTPS(c.TLS.RedirectHTTPAddr, port)
+		}
+		ln, lnErr := tls.Listen("tcp", addr, tlsConfig)
+		if lnErr != nil {
+			log.Fatalln(lnErr.Error())
+		}
+		err = app.Listener(ln)
+	}
 	if err != nil {
 		log.Fatalln(err.Error())
 	}
+
+	// listener returns as soon as shutdown starts, before in-flight requests
+	// are finished
+	<-drained
+	api.closeResources(scrubber)
+	log.Default().Println("server stopped")
 }
 
 // API handlers (controllers) register on this struct (class)
@@ -185,4 +295,9 @@ type API struct {
 	DB           *gorm.DB
 	Config       *Config
 	SessionStore *session.Store
+	SigningKey   ed25519.PrivateKey
+	// nonces of signed agent requests
+	Nonces *nonceCache
+	// server is shutting down and does not accept new requests
+	Draining atomic.Bool
 }
@@ -0,0 +1,69 @@
+package archive
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/ARTM2000/archivo/internal/archive/sourceserver"
+	"github.com/gofiber/fiber/v2"
+	"github.com/robfig/cron/v3"
+)
+
+// readiness reports whether server accepts new requests. it is not ready
+// while draining on shutdown, so load balancers stop sending requests to it
+func (api *API) readiness(c *fiber.Ctx) error {
+	if api.Draining.Load() {
+		return c.Status(fiber.StatusServiceUnavailable).JSON(FormatResponse(c, Data{
+			Message: "server is shutting down",
+			IsError: true,
+		}))
+	}
+	return c.Status(fiber.StatusOK).JSON(FormatResponse(c, Data{
+		Message: "server is ready",
+	}))
+}
+
+// drain marks server as not ready, stops accepting new requests and waits
+// for in-flight requests, e.g. uploads and rotations, until shutdown timeout
+func (api *API) drain(app *fiber.App) {
+	api.Draining.Store(true)
+	if delay := api.Config.Shutdown.DrainDelay; delay > 0 {
+		log.Default().Printf("server is not ready anymore, waiting %s before shutdown\n", delay)
+		time.Sleep(delay)
+	}
+
+	timeout := api.Config.Shutdown.ShutdownTimeout()
+	log.Default().Printf("shutting down, waiting up to %s for in-flight requests\n", timeout)
+	if err := app.ShutdownWithTimeout(timeout); err != nil {
+		log.Default().Printf("in-flight requests are not finished in shutdown timeout, error: %s", err.Error())
+		return
+	}
+	log.Default().Println("all in-flight requests are finished")
+}
+
+// closeResources stops background jobs and releases resources of server
+// after it is drained. a running integrity scrub is waited for until
+// shutdown timeout
+func (api *API) closeResources(scrubber *cron.Cron) {
+	ctx, cancel := context.WithTimeout(context.Background(), api.Config.Shutdown.ShutdownTimeout())
+	defer cancel()
+	select {
+	case <-scrubber.Stop().Done():
+	case <-ctx.Done():
+		log.Default().Println("integrity scrubber is not finished in shutdown timeout")
+	}
+
+	sourceserver.FlushSrcSrvMetrics()
+
+	sqlDB, err := api.DB.DB()
+	if err != nil {
+		log.Default().Printf("unable to get database pool, error: %s", err.Error())
+		return
+	}
+	if err := sqlDB.Close(); err != nil {
+		log.Default().Printf("unable to close database pool, error: %s", err.Error())
+		return
+	}
+	log.Default().Println("database pool closed")
+}
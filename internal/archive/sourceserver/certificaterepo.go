@@ -0,0 +1,72 @@
+package sourceserver
+
+import (
+	"errors"
+	"log"
+	"time"
+
+	"github.com/ARTM2000/archivo/internal/archive/xerrors"
+	"gorm.io/gorm"
+)
+
+// AgentCertificate is a client certificate which is issued for agent of a
+// source server by the built-in ca. Serial is serial number of certificate in
+// hex
+type AgentCertificate struct {
+	ID             uint       `gorm:"primaryKey;not null" json:"id"`
+	Serial         string     `gorm:"type:string;not null;unique" json:"serial"`
+	SourceServerID uint       `gorm:"not null;index" json:"source_server_id"`
+	NotAfter       time.Time  `gorm:"not null" json:"not_after"`
+	RevokedAt      *time.Time `json:"revoked_at"`
+	CreatedAt      time.Time  `gorm:"autoUpdateTime:milli" json:"created_at"`
+}
+
+func (sr *SrvRepository) CreateAgentCertificate(cert *AgentCertificate) error {
+	dbResult := sr.db.Model(&AgentCertificate{}).Create(cert)
+	if dbResult.Error != nil {
+		log.Default().Printf("[Unhandled] error in creating agent certificate '%s', error: %s\n", cert.Serial, dbResult.Error.Error())
+		return xerrors.ErrUnhandled
+	}
+
+	return nil
+}
+
+func (sr *SrvRepository) FindAgentCertificate(serial string) (*AgentCertificate, error) {
+	var cert AgentCertificate
+	dbResult := sr.db.Model(&AgentCertificate{}).Where(AgentCertificate{Serial: serial}).First(&cert)
+	if dbResult.Error != nil {
+		if errors.Is(dbResult.Error, gorm.ErrRecordNotFound) {
+			return nil, xerrors.ErrRecordNotFound
+		}
+		log.Default().Printf("[Unhandled] error in finding agent certificate '%s', error: %s\n", serial, dbResult.Error.Error())
+		return nil, xerrors.ErrUnhandled
+	}
+
+	return &cert, nil
+}
+
+func (sr *SrvRepository) FindAgentCertificates() (*[]AgentCertificate, error) {
+	var certs []AgentCertificate
+	dbResult := sr.db.Model(&AgentCertificate{}).Order("id").Find(&certs)
+	if dbResult.Error != nil {
+		log.Default().Printf("[Unhandled] error in finding agent certificates, error: %s\n", dbResult.Error.Error())
+		return nil, xerrors.ErrUnhandled
+	}
+
+	return &certs, nil
+}
+
+// RevokeAgentCertificates revokes certificates which match cert. returned
+// count is the number of certificates which are revoked now
+func (sr *SrvRepository) RevokeAgentCertificates(cert AgentCertificate) (int64, error) {
+	dbResult := sr.db.Model(&AgentCertificate{}).
+		Where(cert).
+		Where("revoked_at IS NULL").
+		Update("revoked_at", time.Now())
+	if dbResult.Error != nil {
+		log.Default().Printf("[Unhandled] error in revoking agent certificates %+v, error: %s\n", cert, dbResult.Error.Error())
+		return 0, xerrors.ErrUnhandled
+	}
+
+	return dbResult.RowsAffected, nil
+}
@@ -1,14 +1,19 @@
 package sourceserver
 
 import (
+	"bytes"
+	"crypto/rand"
 	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io"
+	"io/fs"
 	"log"
 	"mime/multipart"
 	"os"
 	"path"
+	"path/filepath"
 	"sort"
 	"strings"
 	"time"
@@ -36,7 +41,81 @@ type DiskStore struct {
 
 const metaFilename = ".archive1.meta"
 
-func (ds *DiskStore) FileStore(srcSrvName string, fileName string, file *multipart.FileHeader, correlationId string) error {
+// tempFilePrefix is the prefix of files which are still being written. they
+// are renamed to their final name after a successful write
+const tempFilePrefix = ".archive1.tmp-"
+
+func isSnapshotName(name string) bool {
+	return name != metaFilename && !strings.HasPrefix(name, tempFilePrefix)
+}
+
+type byteCounter struct {
+	n int64
+}
+
+func (bc *byteCounter) Write(p []byte) (int, error) {
+	bc.n += int64(len(p))
+	return len(p), nil
+}
+
+func newSnapshotName(now time.Time) (string, error) {
+	suffix := make([]byte, 4)
+	if _, err := rand.Read(suffix); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf(
+		"%s-%s",
+		strings.Replace(now.Format("20060102150405.000"), ".", "", 1),
+		hex.EncodeToString(suffix),
+	), nil
+}
+
+// writeFileAtomic writes content of r to a temporary file in dir, syncs it to
+// disk and then renames it to name. in case of any failure, the temporary file
+// is removed and the previous file by that name (if any) stays untouched.
+// beforeRename (if not nil) is called after the write and can abort it
+func writeFileAtomic(dir, name string, r io.Reader, beforeRename func() error) error {
+	tmpF, err := os.CreateTemp(dir, tempFilePrefix)
+	if err != nil {
+		return err
+	}
+	tmpPath := tmpF.Name()
+	defer os.Remove(tmpPath)
+
+	if _, err := io.Copy(tmpF, r); err != nil {
+		tmpF.Close()
+		return err
+	}
+	if err := tmpF.Sync(); err != nil {
+		tmpF.Close()
+		return err
+	}
+	if err := tmpF.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmpPath, 0644); err != nil {
+		return err
+	}
+	if beforeRename != nil {
+		if err := beforeRename(); err != nil {
+			return err
+		}
+	}
+
+	if err := os.Rename(tmpPath, path.Join(dir, name)); err != nil {
+		return err
+	}
+
+	// sync directory to persist rename
+	dirF, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer dirF.Close()
+	return dirF.Sync()
+}
+
+func (ds *DiskStore) FileStore(srcSrvName string, fileName string, file *multipart.FileHeader, checksum string, correlationId string) (*StoredSnapshot, error) {
 	// check if directory exist
 	storePath := path.Join(ds.Config.Path, srcSrvName, fileName)
 	if spData, err := os.Stat(storePath); err != nil {
@@ -49,7 +128,7 @@ func (ds *DiskStore) FileStore(srcSrvName string, fileName string, file *multipa
 					correlationId,
 				)
 				log.Default().Println(err.Error())
-				return xerrors.ErrUnableToCreateStoreDirectory
+				return nil, xerrors.ErrUnableToCreateStoreDirectory
 			}
 		} else if !spData.IsDir() {
 			log.Default().Printf(
@@ -58,28 +137,31 @@ func (ds *DiskStore) FileStore(srcSrvName string, fileName string, file *multipa
 				fileName,
 				correlationId,
 			)
-			return xerrors.ErrStorePathExistButNotADirectory
+			return nil, xerrors.ErrStorePathExistButNotADirectory
 		}
 	}
 
-	// create new file snapshot name
-	now := time.Now()
-	fileSnapshotName := strings.Replace(now.Format("20060102150405.000"), ".", "", 1)
-
-	// store file to desire path
-	snapshotPath := path.Join(storePath, fileSnapshotName)
-	f, err := os.OpenFile(snapshotPath, os.O_WRONLY|os.O_CREATE, 0666)
-	if err != nil {
-		log.Default().Printf(
-			"error in creating store path for source server '%s' filename '%s' correlationId '%s'. error: %s\n",
-			srcSrvName,
-			fileName,
-			correlationId,
-			err.Error(),
-		)
-		return err
+	// create new file snapshot name. it is prefixed by the creation time, so
+	// sorting snapshot names keeps them in order, and suffixed by a random id to
+	// prevent collision between uploads received in the same millisecond
+	var fileSnapshotName string
+	for {
+		name, err := newSnapshotName(time.Now())
+		if err != nil {
+			log.Default().Printf(
+				"error in generating snapshot name for source server '%s' filename '%s' correlationId '%s'. error: %s\n",
+				srcSrvName,
+				fileName,
+				correlationId,
+				err.Error(),
+			)
+			return nil, err
+		}
+		if _, err := os.Stat(path.Join(storePath, name)); os.IsNotExist(err) {
+			fileSnapshotName = name
+			break
+		}
 	}
-	defer f.Close()
 
 	oFile, err := file.Open()
 	if err != nil {
@@ -90,22 +172,37 @@ func (ds *DiskStore) FileStore(srcSrvName string, fileName string, file *multipa
 			correlationId,
 			err.Error(),
 		)
-		return err
+		return nil, err
 	}
-
-	_, err = io.Copy(f, oFile)
+	defer oFile.Close()
+
+	// store file to desire path. snapshot is written to a temporary file first
+	// and renamed after that, so a half-written snapshot never appears in store.
+	// checksum is calculated from written bytes and should match the received one
+	hash := sha256.New()
+	counter := &byteCounter{}
+	err = writeFileAtomic(storePath, fileSnapshotName, io.TeeReader(oFile, io.MultiWriter(hash, counter)), func() error {
+		if checksum != "" && !strings.EqualFold(checksum, hex.EncodeToString(hash.Sum(nil))) {
+			return xerrors.ErrChecksumMismatch
+		}
+		return nil
+	})
 	if err != nil {
 		log.Default().Printf(
-			"error in creating store path for source server '%s' filename '%s' correlationId '%s'. error: %s\n",
+			"error in storing snapshot for source server '%s' filename '%s' correlationId '%s'. error: %s\n",
 			srcSrvName,
 			fileName,
 			correlationId,
 			err.Error(),
 		)
-		return err
+		return nil, err
 	}
 
-	return nil
+	return &StoredSnapshot{
+		Name:     fileSnapshotName,
+		Checksum: hex.EncodeToString(hash.Sum(nil)),
+		ByteSize: counter.n,
+	}, nil
 }
 
 func (ds *DiskStore) FileStoreValidate(srcSrvName string, fileName string, rotate int) error {
@@ -145,7 +242,10 @@ func (ds *DiskStore) FileStoreValidate(srcSrvName string, fileName string, rotat
 	return nil
 }
 
-func (ds *DiskStore) FileRotate(srcSrvName string, fileName string, rotate int, correlationId string) error {
+// FileRotate deletes the oldest snapshots of file to keep only rotate count of
+// them. snapshots which protected reports true for are never deleted and are
+// not counted
+func (ds *DiskStore) FileRotate(srcSrvName string, fileName string, rotate int, protected func(snapshot string) bool, correlationId string) ([]string, error) {
 	// get files count from file store path
 
 	// the path should exists and then this method to be called.
@@ -155,31 +255,34 @@ func (ds *DiskStore) FileRotate(srcSrvName string, fileName string, rotate int,
 	ents, err := os.ReadDir(storePath)
 	if err != nil {
 		log.Default().Println("error in read directory of file store. error: ", err.Error())
-		return err
+		return nil, err
 	}
 
 	var fileSnapshotNames []string
 	for _, ent := range ents {
-		if ent.Name() != metaFilename {
-			fileSnapshotNames = append(fileSnapshotNames, ent.Name())
+		if !isSnapshotName(ent.Name()) {
+			continue
 		}
+		if protected != nil && protected(ent.Name()) {
+			log.Default().Printf(
+				"snapshot '%s' of filename '%s' for source server '%s' with correlationId '%s' is protected from rotation",
+				ent.Name(),
+				fileName,
+				srcSrvName,
+				correlationId,
+			)
+			continue
+		}
+		fileSnapshotNames = append(fileSnapshotNames, ent.Name())
 	}
 
 	// if rotate meta file not found, create it
-	metaFilePath := path.Join(storePath, metaFilename)
-	metaF, err := os.Create(metaFilePath)
-	if err != nil {
-		log.Default().Println("error in writing meta file. error: ", err.Error())
-		return err
-	}
-	defer metaF.Close()
-
 	mData := metaData{Rotate: rotate}
 	jsonMetaData, _ := json.Marshal(mData)
-	_, err = metaF.Write(jsonMetaData)
+	err = writeFileAtomic(storePath, metaFilename, bytes.NewReader(jsonMetaData), nil)
 	if err != nil {
 		log.Default().Println("error in write default meta data to file, error: ", err.Error())
-		return err
+		return nil, err
 	}
 
 	// sort files by date
@@ -200,7 +303,7 @@ func (ds *DiskStore) FileRotate(srcSrvName string, fileName string, rotate int,
 			srcSrvName,
 			correlationId,
 		)
-		return nil
+		return nil, nil
 	}
 	filesForDelete := fileSnapshotNames[:(len(fileSnapshotNames) - rotate)]
 	log.Default().Printf(
@@ -211,6 +314,7 @@ func (ds *DiskStore) FileRotate(srcSrvName string, fileName string, rotate int,
 		filesForDelete,
 	)
 
+	var deleted []string
 	for _, ffdName := range filesForDelete {
 		err := os.Remove(path.Join(storePath, ffdName))
 		if err != nil {
@@ -222,8 +326,10 @@ func (ds *DiskStore) FileRotate(srcSrvName string, fileName string, rotate int,
 				ffdName,
 				err.Error(),
 			)
-			return err
+			// already deleted snapshots are returned, so their records are removed
+			return deleted, err
 		}
+		deleted = append(deleted, ffdName)
 	}
 
 	log.Default().Printf(
@@ -234,7 +340,7 @@ func (ds *DiskStore) FileRotate(srcSrvName string, fileName string, rotate int,
 		rotate,
 	)
 
-	return nil
+	return deleted, nil
 }
 
 func (ds *DiskStore) FilesList(srcSrvName string) ([]FileList, error) {
@@ -253,7 +359,7 @@ func (ds *DiskStore) FilesList(srcSrvName string) ([]FileList, error) {
 
 	var filenamesList []string
 	for _, ent := range ents {
-		if ent.IsDir() {
+		if ent.IsDir() && !strings.HasPrefix(ent.Name(), tempFilePrefix) {
 			filenamesList = append(filenamesList, ent.Name())
 		}
 	}
@@ -266,8 +372,13 @@ func (ds *DiskStore) FilesList(srcSrvName string) ([]FileList, error) {
 		fInfo, _ := os.Stat(dirName)
 		snapshots, _ := os.ReadDir(dirName)
 
-		// (-1) is to exclude `.archive1.meta` metadata file
-		fileNameSnapshotCounts := len(snapshots) - 1
+		// exclude `.archive1.meta` metadata file and in progress temporary files
+		fileNameSnapshotCounts := 0
+		for _, snp := range snapshots {
+			if isSnapshotName(snp.Name()) {
+				fileNameSnapshotCounts++
+			}
+		}
 
 		filesList = append(filesList, FileList{
 			ID:        uint32(i + 1),
@@ -309,7 +420,7 @@ func (ds *DiskStore) SnapshotsList(srcSrvName, filename string) ([]SnapshotList,
 
 	var snapshotNameList []string
 	for _, ent := range ents {
-		if ent.Name() != metaFilename {
+		if isSnapshotName(ent.Name()) {
 			snapshotNameList = append(snapshotNameList, ent.Name())
 		}
 	}
@@ -322,13 +433,25 @@ func (ds *DiskStore) SnapshotsList(srcSrvName, filename string) ([]SnapshotList,
 			continue
 		}
 		snpPath := path.Join(filenameStorePath, snpName)
-		snpInfo, _ := os.Stat(snpPath)
+		snpInfo, err := os.Stat(snpPath)
+		if err != nil {
+			log.Default().Printf("error in reading snapshot '%s' info, error: %s", snpPath, err.Error())
+			return nil, xerrors.ErrUnhandled
+		}
 
 		// calculate file checksum
-		f, _ := os.Open(snpPath)
+		f, err := os.Open(snpPath)
+		if err != nil {
+			log.Default().Printf("error in opening snapshot '%s', error: %s", snpPath, err.Error())
+			return nil, xerrors.ErrUnhandled
+		}
 		hash := sha256.New()
-		io.Copy(hash, f)
+		_, err = io.Copy(hash, f)
 		f.Close()
+		if err != nil {
+			log.Default().Printf("error in reading snapshot '%s', error: %s", snpPath, err.Error())
+			return nil, xerrors.ErrUnhandled
+		}
 
 		snp := SnapshotList{
 			ID:        uint32(i + 1),
@@ -360,3 +483,70 @@ func (ds *DiskStore) ReadSnapshot(srcSrvName, filename, snapshot string) (*[]byt
 
 	return &f, nil
 }
+
+// Usage reports total size and count of all snapshots of source server
+func (ds *DiskStore) Usage(srcSrvName string) (int64, int, error) {
+	srcSrvStorePath := path.Join(ds.Config.Path, srcSrvName)
+	if _, err := os.Stat(srcSrvStorePath); os.IsNotExist(err) {
+		return 0, 0, nil
+	}
+
+	var totalSize int64
+	var totalCount int
+	err := filepath.WalkDir(srcSrvStorePath, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || !isSnapshotName(d.Name()) {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		totalSize += info.Size()
+		totalCount++
+		return nil
+	})
+	if err != nil {
+		log.Default().Printf("error in calculating usage of source server '%s', error: %s", srcSrvName, err.Error())
+		return 0, 0, xerrors.ErrUnhandled
+	}
+
+	return totalSize, totalCount, nil
+}
+
+// DeleteStore removes all files and snapshots of source server
+func (ds *DiskStore) DeleteStore(srcSrvName string) error {
+	srcSrvStorePath := path.Join(ds.Config.Path, srcSrvName)
+	if err := os.RemoveAll(srcSrvStorePath); err != nil {
+		log.Default().Printf("error in removing store of source server '%s', error: %s", srcSrvName, err.Error())
+		return xerrors.ErrUnhandled
+	}
+
+	return nil
+}
+
+func (ds *DiskStore) Recover() error {
+	if _, err := os.Stat(ds.Config.Path); os.IsNotExist(err) {
+		return nil
+	}
+
+	return filepath.WalkDir(ds.Config.Path, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || !strings.HasPrefix(d.Name(), tempFilePrefix) {
+			return nil
+		}
+
+		log.Default().Printf("removing leftover temporary file '%s'", p)
+		if err := os.Remove(p); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	})
+}
@@ -0,0 +1,87 @@
+package sourceserver
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+)
+
+func writeTestSnapshots(t *testing.T, dir string, names ...string) {
+	t.Helper()
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		t.Fatalf("create store directory: %s", err)
+	}
+	for _, name := range names {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(name), 0o644); err != nil {
+			t.Fatalf("write snapshot '%s': %s", name, err)
+		}
+	}
+}
+
+func remainingSnapshots(t *testing.T, dir string) []string {
+	t.Helper()
+	ents, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("read store directory: %s", err)
+	}
+	names := []string{}
+	for _, ent := range ents {
+		if isSnapshotName(ent.Name()) {
+			names = append(names, ent.Name())
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestFileRotateReturnsDeletedSnapshots(t *testing.T) {
+	store := NewDiskStore(DiskStoreConfig{Path: t.TempDir()})
+	dir := filepath.Join(store.Config.Path, "web1", "nginx")
+	writeTestSnapshots(t, dir, "1000", "2000", "3000", "4000")
+
+	deleted, err := store.FileRotate("web1", "nginx", 2, nil, "test")
+	if err != nil {
+		t.Fatalf("rotate file: %s", err)
+	}
+	if len(deleted) != 2 || deleted[0] != "1000" || deleted[1] != "2000" {
+		t.Fatalf("deleted snapshots are %v, want [1000 2000]", deleted)
+	}
+	if remaining := remainingSnapshots(t, dir); len(remaining) != 2 || remaining[0] != "3000" || remaining[1] != "4000" {
+		t.Fatalf("remaining snapshots are %v, want [3000 4000]", remaining)
+	}
+	if _, err := os.Stat(filepath.Join(dir, metaFilename)); err != nil {
+		t.Fatalf("meta file is not written: %s", err)
+	}
+}
+
+func TestFileRotateSkipsProtectedSnapshots(t *testing.T) {
+	store := NewDiskStore(DiskStoreConfig{Path: t.TempDir()})
+	dir := filepath.Join(store.Config.Path, "web1", "nginx")
+	writeTestSnapshots(t, dir, "1000", "2000", "3000", "4000")
+
+	deleted, err := store.FileRotate("web1", "nginx", 2, func(snapshot string) bool { return snapshot == "1000" }, "test")
+	if err != nil {
+		t.Fatalf("rotate file: %s", err)
+	}
+	if len(deleted) != 1 || deleted[0] != "2000" {
+		t.Fatalf("deleted snapshots are %v, want [2000]", deleted)
+	}
+	if remaining := remainingSnapshots(t, dir); len(remaining) != 3 {
+		t.Fatalf("remaining snapshots are %v, want [1000 3000 4000]", remaining)
+	}
+}
+
+func TestFileRotateUnderRotateCount(t *testing.T) {
+	store := NewDiskStore(DiskStoreConfig{Path: t.TempDir()})
+	dir := filepath.Join(store.Config.Path, "web1", "nginx")
+	writeTestSnapshots(t, dir, "1000")
+
+	deleted, err := store.FileRotate("web1", "nginx", 3, nil, "test")
+	if err != nil {
+		t.Fatalf("rotate file: %s", err)
+	}
+	if len(deleted) != 0 {
+		t.Fatalf("deleted snapshots are %v, want none", deleted)
+	}
+}
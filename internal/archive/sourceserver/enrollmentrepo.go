@@ -0,0 +1,144 @@
+package sourceserver
+
+import (
+	"errors"
+	"log"
+	"time"
+
+	"github.com/ARTM2000/archivo/internal/archive/xerrors"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// EnrollmentToken lets agents register themselves as new source servers
+// without an admin creating them one by one. source servers which are
+// enrolled by a token join its group
+type EnrollmentToken struct {
+	ID          uint       `gorm:"primaryKey;not null" json:"id"`
+	Note        string     `gorm:"type:string;not null;default:''" json:"note"`
+	HashedToken string     `gorm:"type:string;not null;unique" json:"-"`
+	Group       string     `gorm:"type:string;not null;default:''" json:"group"`
+	MaxUses     int        `gorm:"not null" json:"max_uses"`
+	Uses        int        `gorm:"not null;default:0" json:"uses"`
+	ExpiresAt   time.Time  `gorm:"not null" json:"expires_at"`
+	RevokedAt   *time.Time `json:"revoked_at"`
+	CreatedBy   uint       `gorm:"not null" json:"created_by"`
+	CreatedAt   time.Time  `gorm:"autoUpdateTime:milli" json:"created_at"`
+}
+
+// Usable reports whether token can still enroll a source server
+func (et *EnrollmentToken) Usable() bool {
+	return et.RevokedAt == nil && et.Uses < et.MaxUses && time.Now().Before(et.ExpiresAt)
+}
+
+func (sr *SrvRepository) CreateEnrollmentToken(token *EnrollmentToken) error {
+	dbResult := sr.db.Model(&EnrollmentToken{}).Create(token)
+	if dbResult.Error != nil {
+		log.Default().Printf("[Unhandled] error in creating enrollment token, error: %s\n", dbResult.Error.Error())
+		return xerrors.ErrUnhandled
+	}
+
+	return nil
+}
+
+func (sr *SrvRepository) FindEnrollmentTokens(option FindAllOption) (*[]EnrollmentToken, int64, error) {
+	var tokens []EnrollmentToken
+	var DESC bool
+	if option.SortOrder == "ASC" {
+		DESC = false
+	} else {
+		DESC = true
+	}
+	dbResult := sr.db.Model(&EnrollmentToken{}).Order(clause.OrderByColumn{Column: clause.Column{Name: option.SortBy}, Desc: DESC}).Offset(option.Start).Limit(option.End).Find(&tokens)
+	if dbResult.Error != nil {
+		log.Default().Printf("[Unhandled] error in finding enrollment tokens, error: %s\n", dbResult.Error.Error())
+		return nil, 0, xerrors.ErrUnhandled
+	}
+
+	var total int64
+	dbResult = sr.db.Model(&EnrollmentToken{}).Count(&total)
+	if dbResult.Error != nil {
+		log.Default().Printf("[Unhandled] error in counting enrollment tokens, error: %s\n", dbResult.Error.Error())
+		return nil, 0, xerrors.ErrUnhandled
+	}
+
+	return &tokens, total, nil
+}
+
+func (sr *SrvRepository) RevokeEnrollmentToken(id uint) (*EnrollmentToken, error) {
+	var token EnrollmentToken
+	dbResult := sr.db.Model(&EnrollmentToken{}).Where(EnrollmentToken{ID: id}).First(&token)
+	if dbResult.Error != nil {
+		if errors.Is(dbResult.Error, gorm.ErrRecordNotFound) {
+			return nil, xerrors.ErrRecordNotFound
+		}
+		log.Default().Printf("[Unhandled] error in finding enrollment token '%d', error: %s\n", id, dbResult.Error.Error())
+		return nil, xerrors.ErrUnhandled
+	}
+	if token.RevokedAt != nil {
+		return &token, nil
+	}
+
+	now := time.Now()
+	token.RevokedAt = &now
+	dbResult = sr.db.Model(&token).Select("revoked_at").Updates(&token)
+	if dbResult.Error != nil {
+		log.Default().Printf("[Unhandled] error in revoking enrollment token '%d', error: %s\n", id, dbResult.Error.Error())
+		return nil, xerrors.ErrUnhandled
+	}
+
+	return &token, nil
+}
+
+// EnrollSrv uses enrollment token once and creates a new source server in
+// its group. token is locked during enrollment, so it is never used more than
+// its max uses by concurrent agents
+func (sr *SrvRepository) EnrollSrv(hashedToken, name, hashedAPIKey, requestSigningKey string) (*SourceServer, error) {
+	var newSrv SourceServer
+	err := sr.db.Transaction(func(tx *gorm.DB) error {
+		var token EnrollmentToken
+		dbResult := tx.Model(&EnrollmentToken{}).
+			Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where(EnrollmentToken{HashedToken: hashedToken}).
+			First(&token)
+		if dbResult.Error != nil {
+			if errors.Is(dbResult.Error, gorm.ErrRecordNotFound) {
+				return xerrors.ErrInvalidEnrollmentToken
+			}
+			return dbResult.Error
+		}
+		if !token.Usable() {
+			return xerrors.ErrInvalidEnrollmentToken
+		}
+
+		var existing int64
+		dbResult = tx.Model(&SourceServer{}).Where(SourceServer{Name: name}).Count(&existing)
+		if dbResult.Error != nil {
+			return dbResult.Error
+		}
+		if existing > 0 {
+			return xerrors.ErrSourceServerWithThisNameExists
+		}
+
+		newSrv = SourceServer{
+			Name:              name,
+			HashedAPIKey:      hashedAPIKey,
+			RequestSigningKey: requestSigningKey,
+			Group:             token.Group,
+		}
+		if dbResult := tx.Model(&SourceServer{}).Create(&newSrv); dbResult.Error != nil {
+			return dbResult.Error
+		}
+
+		return tx.Model(&token).Update("uses", gorm.Expr("uses + 1")).Error
+	})
+	if err != nil {
+		if errors.Is(err, xerrors.ErrInvalidEnrollmentToken) || errors.Is(err, xerrors.ErrSourceServerWithThisNameExists) {
+			return nil, err
+		}
+		log.Default().Printf("[Unhandled] error in enrolling source server '%s', error: %s\n", name, err.Error())
+		return nil, xerrors.ErrUnhandled
+	}
+
+	return &newSrv, nil
+}
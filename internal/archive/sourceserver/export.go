@@ -0,0 +1,227 @@
+package sourceserver
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"path"
+	"time"
+
+	"github.com/ARTM2000/archivo/internal/archive/xerrors"
+)
+
+const (
+	exportManifestFilename = "manifest.json"
+	exportFilesDirname     = "files"
+	exportDefaultMode      = 0644
+)
+
+// ExportEntry is a file of source server along with the snapshot which it
+// had at export time
+type ExportEntry struct {
+	Filename  string       `json:"filename"`
+	Snapshot  string       `json:"snapshot"`
+	Checksum  string       `json:"checksum"`
+	ByteSize  int64        `json:"byte_size"`
+	Corrupted bool         `json:"corrupted"`
+	Metadata  FileMetadata `json:"metadata"`
+	CreatedAt time.Time    `json:"created_at"`
+	// path of snapshot in bundle
+	Path string `json:"path"`
+}
+
+// ExportSkippedFile is a file which source server had at export time, but
+// its snapshot of that time is not in the bundle
+type ExportSkippedFile struct {
+	Filename string `json:"filename"`
+	Snapshot string `json:"snapshot"`
+	Reason   string `json:"reason"`
+}
+
+// Export is manifest of a point in time bundle of source server. it is
+// written as the last entry of bundle, so files which can not be read while
+// bundle is written are reported as skipped
+type Export struct {
+	SourceServerID uint                `json:"source_server_id"`
+	SourceServer   string              `json:"source_server"`
+	At             time.Time           `json:"at"`
+	Files          []ExportEntry       `json:"files"`
+	Skipped        []ExportSkippedFile `json:"skipped"`
+	GeneratedAt    time.Time           `json:"generated_at"`
+}
+
+// Dirname is the directory which all entries of bundle are placed in
+func (e *Export) Dirname() string {
+	return e.SourceServer + "-" + e.At.UTC().Format("20060102T150405Z")
+}
+
+// PrepareExport selects, for every file of source server, the last snapshot
+// taken at or before received time. when that snapshot is rotated and an
+// older pinned one still exists, file is skipped, as the older one is not
+// what source server had at that time
+func (sm *SrvManager) PrepareExport(srcSrvId uint, at time.Time) (*SourceServer, *Export, error) {
+	srv, err := sm.srvRepository.FindSrvWithId(srcSrvId)
+	if err != nil {
+		if errors.Is(err, xerrors.ErrRecordNotFound) {
+			log.Default().Printf("source server with ID '%d' not exists\n", srcSrvId)
+			return nil, nil, xerrors.ErrRecordNotFound
+		}
+
+		log.Default().Printf("[Unhandled] finding source server with ID '%d' failed, error: %s", srcSrvId, err.Error())
+		return nil, nil, xerrors.ErrUnhandled
+	}
+
+	export := Export{
+		SourceServerID: srv.ID,
+		SourceServer:   srv.Name,
+		At:             at.UTC().Truncate(time.Millisecond),
+		Files:          []ExportEntry{},
+		Skipped:        []ExportSkippedFile{},
+	}
+
+	storeManager := sm.getStoreManager()
+	files, err := storeManager.FilesList(srv.Name)
+	if err != nil {
+		if errors.Is(err, xerrors.ErrNoStoreForSourceServer) {
+			return srv, &export, nil
+		}
+		log.Default().Printf("[Unhandled] error in finding files for source server by name '%s', error: %s", srv.Name, err)
+		return nil, nil, xerrors.ErrUnhandled
+	}
+
+	for _, file := range files {
+		history, err := sm.srvRepository.FindFileSnapshotsHistory(srv.ID, file.FileName)
+		if err != nil {
+			return nil, nil, err
+		}
+		var lastRecord *Snapshot
+		for i := range *history {
+			if !(*history)[i].CreatedAt.After(at) {
+				lastRecord = &(*history)[i]
+			}
+		}
+
+		selected, err := sm.FindRestoreSnapshot(srv, file.FileName, "", &at)
+		if err != nil && !errors.Is(err, xerrors.ErrSnapshotNotFound) {
+			return nil, nil, err
+		}
+
+		if lastRecord != nil && lastRecord.DeletedAt.Valid && (selected == nil || selected.Name != lastRecord.Name) {
+			export.Skipped = append(export.Skipped, ExportSkippedFile{
+				Filename: file.FileName,
+				Snapshot: lastRecord.Name,
+				Reason:   "snapshot is rotated",
+			})
+			continue
+		}
+		if selected == nil {
+			// file did not exist on source server at that time
+			continue
+		}
+
+		entry := ExportEntry{
+			Filename:  file.FileName,
+			Snapshot:  selected.Name,
+			Checksum:  selected.Checksum,
+			ByteSize:  selected.ByteSize,
+			Corrupted: selected.Corrupted,
+			CreatedAt: selected.CreatedAt,
+			Path:      path.Join(exportFilesDirname, file.FileName),
+		}
+		if selected.Metadata != nil {
+			entry.Metadata = *selected.Metadata
+		}
+		export.Files = append(export.Files, entry)
+	}
+
+	return srv, &export, nil
+}
+
+// WriteExport writes snapshots of export as a tar.gz bundle to w, followed by
+// its manifest. snapshots which can not be read anymore, e.g. rotated since
+// they are selected, are skipped
+func (sm *SrvManager) WriteExport(srv *SourceServer, export *Export, w io.Writer) error {
+	gzipWriter := gzip.NewWriter(w)
+	tarWriter := tar.NewWriter(gzipWriter)
+	storeManager := sm.getStoreManager()
+
+	files := []ExportEntry{}
+	for _, entry := range export.Files {
+		content, err := storeManager.ReadSnapshot(srv.Name, entry.Filename, entry.Snapshot)
+		if err != nil {
+			log.Default().Printf(
+				"error in reading snapshot '%s' of file '%s' of source server '%s' for export, error: %s",
+				entry.Snapshot, entry.Filename, srv.Name, err.Error(),
+			)
+			export.Skipped = append(export.Skipped, ExportSkippedFile{
+				Filename: entry.Filename,
+				Snapshot: entry.Snapshot,
+				Reason:   "snapshot can not be read",
+			})
+			continue
+		}
+		sum := sha256.Sum256(*content)
+		if hex.EncodeToString(sum[:]) != entry.Checksum {
+			entry.Corrupted = true
+		}
+
+		header := &tar.Header{
+			Name:     path.Join(export.Dirname(), entry.Path),
+			Mode:     exportDefaultMode,
+			Size:     int64(len(*content)),
+			ModTime:  entry.CreatedAt,
+			Uname:    entry.Metadata.OwnerName,
+			Gname:    entry.Metadata.GroupName,
+			Typeflag: tar.TypeReg,
+		}
+		if entry.Metadata.Mode != nil {
+			header.Mode = int64(*entry.Metadata.Mode & 07777)
+		}
+		if entry.Metadata.ModTime != nil {
+			header.ModTime = *entry.Metadata.ModTime
+		}
+		if entry.Metadata.Uid != nil {
+			header.Uid = *entry.Metadata.Uid
+		}
+		if entry.Metadata.Gid != nil {
+			header.Gid = *entry.Metadata.Gid
+		}
+		if err := tarWriter.WriteHeader(header); err != nil {
+			return err
+		}
+		if _, err := tarWriter.Write(*content); err != nil {
+			return err
+		}
+		files = append(files, entry)
+	}
+	export.Files = files
+	export.GeneratedAt = time.Now().UTC().Truncate(time.Millisecond)
+
+	manifest, err := json.MarshalIndent(export, "", "  ")
+	if err != nil {
+		return err
+	}
+	err = tarWriter.WriteHeader(&tar.Header{
+		Name:     path.Join(export.Dirname(), exportManifestFilename),
+		Mode:     exportDefaultMode,
+		Size:     int64(len(manifest)),
+		ModTime:  export.GeneratedAt,
+		Typeflag: tar.TypeReg,
+	})
+	if err != nil {
+		return err
+	}
+	if _, err := tarWriter.Write(manifest); err != nil {
+		return err
+	}
+
+	if err := tarWriter.Close(); err != nil {
+		return err
+	}
+	return gzipWriter.Close()
+}
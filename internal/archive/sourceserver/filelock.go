@@ -0,0 +1,43 @@
+package sourceserver
+
+import (
+	"path"
+	"sync"
+)
+
+type fileLock struct {
+	mu   sync.Mutex
+	refs int
+}
+
+var fileLocks = map[string]*fileLock{}
+var fileLocksMu sync.Mutex
+
+// lockFile acquires an exclusive lock on a source server filename, so store,
+// rotation and any other change on that file are done one at a time. returned
+// function releases the lock
+func lockFile(srcSrvName, fileName string) func() {
+	key := path.Join(srcSrvName, fileName)
+
+	fileLocksMu.Lock()
+	fl, exists := fileLocks[key]
+	if !exists {
+		fl = &fileLock{}
+		fileLocks[key] = fl
+	}
+	fl.refs++
+	fileLocksMu.Unlock()
+
+	fl.mu.Lock()
+
+	return func() {
+		fl.mu.Unlock()
+
+		fileLocksMu.Lock()
+		fl.refs--
+		if fl.refs == 0 {
+			delete(fileLocks, key)
+		}
+		fileLocksMu.Unlock()
+	}
+}
@@ -0,0 +1,142 @@
+package sourceserver
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// recordPayload is the canonical form of a snapshot record which its hash is
+// calculated from. any change in this format breaks verification of existing
+// chains
+func recordPayload(srvId uint, filename, name, checksum string, byteSize int64, createdAt time.Time, prevHash string) []byte {
+	return []byte(fmt.Sprintf(
+		"archivo-snapshot-v1\n%d\n%s\n%s\n%s\n%d\n%d\n%s",
+		srvId,
+		filename,
+		name,
+		checksum,
+		byteSize,
+		createdAt.UnixMilli(),
+		prevHash,
+	))
+}
+
+func recordHash(srvId uint, filename, name, checksum string, byteSize int64, createdAt time.Time, prevHash string) string {
+	sum := sha256.Sum256(recordPayload(srvId, filename, name, checksum, byteSize, createdAt, prevHash))
+	return hex.EncodeToString(sum[:])
+}
+
+func manifestPayload(m *Manifest) []byte {
+	return []byte(fmt.Sprintf(
+		"archivo-manifest-v1\n%d\n%s\n%d\n%s\n%d",
+		m.SourceServerID,
+		m.Filename,
+		len(m.Entries),
+		m.Head,
+		m.GeneratedAt.UnixMilli(),
+	))
+}
+
+// sealSnapshot links snapshot record to the previous record of the same file
+// and signs its hash. prev is nil for the first record of a file
+func sealSnapshot(snapshot *Snapshot, prev *Snapshot, key ed25519.PrivateKey) {
+	if snapshot.CreatedAt.IsZero() {
+		snapshot.CreatedAt = time.Now()
+	}
+	snapshot.CreatedAt = snapshot.CreatedAt.UTC().Truncate(time.Millisecond)
+
+	snapshot.PrevHash = ""
+	if prev != nil {
+		snapshot.PrevHash = prev.RecordHash
+	}
+
+	snapshot.RecordHash = recordHash(
+		snapshot.SourceServerID,
+		snapshot.Filename,
+		snapshot.Name,
+		snapshot.Checksum,
+		snapshot.ByteSize,
+		snapshot.CreatedAt,
+		snapshot.PrevHash,
+	)
+
+	snapshot.Signature = ""
+	if key != nil {
+		rh, _ := hex.DecodeString(snapshot.RecordHash)
+		snapshot.Signature = base64.StdEncoding.EncodeToString(ed25519.Sign(key, rh))
+	}
+}
+
+type ManifestEntry struct {
+	Name       string    `json:"name"`
+	Checksum   string    `json:"checksum"`
+	ByteSize   int64     `json:"byte_size"`
+	CreatedAt  time.Time `json:"created_at"`
+	Rotated    bool      `json:"rotated"`
+	PrevHash   string    `json:"prev_hash"`
+	RecordHash string    `json:"record_hash"`
+	Signature  string    `json:"signature"`
+}
+
+type Manifest struct {
+	SourceServerID uint            `json:"source_server_id"`
+	SourceServer   string          `json:"source_server"`
+	Filename       string          `json:"filename"`
+	PublicKey      string          `json:"public_key"`
+	Entries        []ManifestEntry `json:"entries"`
+	Head           string          `json:"head"`
+	GeneratedAt    time.Time       `json:"generated_at"`
+	Signature      string          `json:"signature"`
+}
+
+// VerifyManifest checks that every entry of manifest is linked to its previous
+// one, its hash matches its content and it is signed by the public key. if
+// trustedKey is nil, public key embedded in manifest is used
+func VerifyManifest(m *Manifest, trustedKey ed25519.PublicKey) error {
+	publicKey := trustedKey
+	if publicKey == nil {
+		pk, err := base64.StdEncoding.DecodeString(m.PublicKey)
+		if err != nil || len(pk) != ed25519.PublicKeySize {
+			return errors.New("manifest public key is not valid")
+		}
+		publicKey = pk
+	} else if m.PublicKey != base64.StdEncoding.EncodeToString(trustedKey) {
+		return errors.New("manifest is not signed by trusted public key")
+	}
+
+	prevHash := ""
+	for i, e := range m.Entries {
+		if e.PrevHash != prevHash {
+			return fmt.Errorf("entry %d (%s) is not linked to its previous entry", i, e.Name)
+		}
+
+		expected := recordHash(m.SourceServerID, m.Filename, e.Name, e.Checksum, e.ByteSize, e.CreatedAt, e.PrevHash)
+		if e.RecordHash != expected {
+			return fmt.Errorf("entry %d (%s) hash does not match its content", i, e.Name)
+		}
+
+		rh, _ := hex.DecodeString(e.RecordHash)
+		sig, err := base64.StdEncoding.DecodeString(e.Signature)
+		if err != nil || !ed25519.Verify(publicKey, rh, sig) {
+			return fmt.Errorf("entry %d (%s) signature is not valid", i, e.Name)
+		}
+
+		prevHash = e.RecordHash
+	}
+
+	if m.Head != prevHash {
+		return errors.New("manifest head does not match its last entry")
+	}
+
+	sig, err := base64.StdEncoding.DecodeString(m.Signature)
+	if err != nil || !ed25519.Verify(publicKey, manifestPayload(m), sig) {
+		return errors.New("manifest signature is not valid")
+	}
+
+	return nil
+}
@@ -0,0 +1,107 @@
+package sourceserver
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/hex"
+	"testing"
+)
+
+func newTestManifest(t *testing.T) (*Manifest, ed25519.PublicKey) {
+	t.Helper()
+	repo := newTestRepository(t)
+	srv := createTestSrv(t, repo, "web1")
+	signingKey := newTestSigningKey(t)
+	srvManager := NewSrvManager(SrvConfig{SigningKey: signingKey}, repo)
+
+	for _, name := range []string{"1000", "2000", "3000"} {
+		if _, err := srvManager.recordSnapshot(srv.ID, "nginx", &StoredSnapshot{Name: name, Checksum: "checksum-" + name, ByteSize: 10}, FileMetadata{}); err != nil {
+			t.Fatalf("record snapshot '%s': %s", name, err)
+		}
+	}
+
+	manifest, err := srvManager.ExportFileManifest(srv.ID, "nginx")
+	if err != nil {
+		t.Fatalf("export manifest: %s", err)
+	}
+	return manifest, signingKey.Public().(ed25519.PublicKey)
+}
+
+func TestSealSnapshotLinksChain(t *testing.T) {
+	key := newTestSigningKey(t)
+	first := Snapshot{SourceServerID: 1, Filename: "nginx", Name: "1000", Checksum: "a", ByteSize: 1}
+	sealSnapshot(&first, nil, key)
+	second := Snapshot{SourceServerID: 1, Filename: "nginx", Name: "2000", Checksum: "b", ByteSize: 1}
+	sealSnapshot(&second, &first, key)
+
+	if first.PrevHash != "" {
+		t.Fatalf("first record is linked to '%s'", first.PrevHash)
+	}
+	if second.PrevHash != first.RecordHash {
+		t.Fatalf("second record is linked to '%s', want '%s'", second.PrevHash, first.RecordHash)
+	}
+	if second.RecordHash != recordHash(1, "nginx", "2000", "b", 1, second.CreatedAt, first.RecordHash) {
+		t.Fatal("record hash does not cover record content")
+	}
+	rh, _ := hex.DecodeString(second.RecordHash)
+	sig, _ := base64.StdEncoding.DecodeString(second.Signature)
+	if !ed25519.Verify(key.Public().(ed25519.PublicKey), rh, sig) {
+		t.Fatal("record signature is not valid")
+	}
+}
+
+func TestVerifyManifest(t *testing.T) {
+	manifest, publicKey := newTestManifest(t)
+	if len(manifest.Entries) != 3 {
+		t.Fatalf("manifest has %d entries, want 3", len(manifest.Entries))
+	}
+	if err := VerifyManifest(manifest, publicKey); err != nil {
+		t.Fatalf("verify manifest: %s", err)
+	}
+	if err := VerifyManifest(manifest, nil); err != nil {
+		t.Fatalf("verify manifest by embedded key: %s", err)
+	}
+}
+
+func TestVerifyManifestDetectsTampering(t *testing.T) {
+	otherKey := newTestSigningKey(t)
+	cases := map[string]func(m *Manifest){
+		"changed checksum": func(m *Manifest) {
+			m.Entries[1].Checksum = "forged"
+		},
+		"changed record hash": func(m *Manifest) {
+			m.Entries[1].RecordHash = recordHash(m.SourceServerID, m.Filename, "forged", "forged", 1, m.Entries[1].CreatedAt, m.Entries[1].PrevHash)
+		},
+		"broken prev link": func(m *Manifest) {
+			m.Entries[2].PrevHash = m.Entries[0].RecordHash
+		},
+		"removed entry": func(m *Manifest) {
+			m.Entries = append(m.Entries[:1], m.Entries[2:]...)
+		},
+		"entry signed by other key": func(m *Manifest) {
+			rh, _ := hex.DecodeString(m.Entries[0].RecordHash)
+			m.Entries[0].Signature = base64.StdEncoding.EncodeToString(ed25519.Sign(otherKey, rh))
+		},
+		"changed head": func(m *Manifest) {
+			m.Head = m.Entries[1].RecordHash
+		},
+		"manifest signed by other key": func(m *Manifest) {
+			m.Signature = base64.StdEncoding.EncodeToString(ed25519.Sign(otherKey, manifestPayload(m)))
+		},
+	}
+
+	for name, tamper := range cases {
+		manifest, publicKey := newTestManifest(t)
+		tamper(manifest)
+		if err := VerifyManifest(manifest, publicKey); err == nil {
+			t.Errorf("manifest with %s is verified", name)
+		}
+	}
+
+	// a manifest re-signed by another key is rejected against the trusted key
+	manifest, publicKey := newTestManifest(t)
+	manifest.PublicKey = base64.StdEncoding.EncodeToString(otherKey.Public().(ed25519.PublicKey))
+	if err := VerifyManifest(manifest, publicKey); err == nil {
+		t.Error("manifest of untrusted key is verified")
+	}
+}
@@ -0,0 +1,106 @@
+package sourceserver
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/ARTM2000/archivo/internal/archive/xerrors"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// HeartbeatFile is the state of a file which agent backs up, as reported by
+// agent in its heartbeat
+type HeartbeatFile struct {
+	Filename    string     `json:"filename"`
+	Path        string     `json:"path"`
+	Command     bool       `json:"command"`
+	Trigger     string     `json:"trigger"`
+	Interval    string     `json:"interval"`
+	Rotate      int64      `json:"rotate"`
+	NextRun     *time.Time `json:"next_run"`
+	LastRun     *time.Time `json:"last_run"`
+	LastSuccess *time.Time `json:"last_success"`
+	LastError   string     `json:"last_error"`
+	Reachable   bool       `json:"reachable"`
+	Pending     bool       `json:"pending"`
+	Running     bool       `json:"running"`
+}
+
+// Heartbeat is the latest heartbeat which agent of source server sent
+type Heartbeat struct {
+	ID               uint      `gorm:"primaryKey;not null" json:"id"`
+	SourceServerID   uint      `gorm:"not null;uniqueIndex" json:"source_server_id"`
+	Version          string    `gorm:"type:string;not null;default:''" json:"version"`
+	Hostname         string    `gorm:"type:string;not null;default:''" json:"hostname"`
+	OS               string    `gorm:"type:string;not null;default:''" json:"os"`
+	Arch             string    `gorm:"type:string;not null;default:''" json:"arch"`
+	ConfigRevision   string    `gorm:"type:string;not null;default:''" json:"config_revision"`
+	PendingUploads   int       `gorm:"not null;default:0" json:"pending_uploads"`
+	RunningUploads   int       `gorm:"not null;default:0" json:"running_uploads"`
+	FailingFiles     int       `gorm:"not null;default:0" json:"failing_files"`
+	UnreachableFiles int       `gorm:"not null;default:0" json:"unreachable_files"`
+	Files            string    `gorm:"type:text;not null" json:"-"`
+	ReceivedAt       time.Time `gorm:"not null" json:"received_at"`
+}
+
+func (hb *Heartbeat) HeartbeatFiles() []HeartbeatFile {
+	files := []HeartbeatFile{}
+	if hb == nil || hb.Files == "" {
+		return files
+	}
+	if err := json.Unmarshal([]byte(hb.Files), &files); err != nil {
+		log.Default().Printf("[Unhandled] error in decoding files of heartbeat '%d', error: %s\n", hb.ID, err.Error())
+	}
+	return files
+}
+
+// SaveHeartbeat replaces the last heartbeat of source server
+func (sr *SrvRepository) SaveHeartbeat(heartbeat *Heartbeat) error {
+	dbResult := sr.db.Model(&Heartbeat{}).Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "source_server_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{
+			"version", "hostname", "os", "arch", "config_revision", "pending_uploads",
+			"running_uploads", "failing_files", "unreachable_files", "files", "received_at",
+		}),
+	}).Create(heartbeat)
+	if dbResult.Error != nil {
+		log.Default().Printf("[Unhandled] error in saving heartbeat of source server '%d', error: %s\n", heartbeat.SourceServerID, dbResult.Error.Error())
+		return xerrors.ErrUnhandled
+	}
+
+	return nil
+}
+
+func (sr *SrvRepository) FindHeartbeat(srvId uint) (*Heartbeat, error) {
+	var heartbeat Heartbeat
+	dbResult := sr.db.Model(&Heartbeat{}).Where(Heartbeat{SourceServerID: srvId}).First(&heartbeat)
+	if dbResult.Error != nil {
+		if errors.Is(dbResult.Error, gorm.ErrRecordNotFound) {
+			return nil, xerrors.ErrRecordNotFound
+		}
+		log.Default().Printf("[Unhandled] error in finding heartbeat of source server '%d', error: %s\n", srvId, dbResult.Error.Error())
+		return nil, xerrors.ErrUnhandled
+	}
+
+	return &heartbeat, nil
+}
+
+// FindHeartbeats returns last heartbeats of source servers by their id.
+// servers which have never sent a heartbeat are not included
+func (sr *SrvRepository) FindHeartbeats(srvIds []uint) (map[uint]Heartbeat, error) {
+	heartbeats := []Heartbeat{}
+	dbResult := sr.db.Model(&Heartbeat{}).Where("source_server_id IN ?", srvIds).Find(&heartbeats)
+	if dbResult.Error != nil {
+		log.Default().Printf("[Unhandled] error in finding heartbeats of source servers, error: %s\n", dbResult.Error.Error())
+		return nil, xerrors.ErrUnhandled
+	}
+
+	result := map[uint]Heartbeat{}
+	for _, heartbeat := range heartbeats {
+		result[heartbeat.SourceServerID] = heartbeat
+	}
+	return result, nil
+}
@@ -0,0 +1,76 @@
+package sourceserver
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/ARTM2000/archivo/internal/archive/xerrors"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	ManagedConfigServerScope = "server"
+	ManagedConfigGroupScope  = "group"
+)
+
+// ManagedFile is a file which agent of source server backs up, like the files
+// in agent configuration file
+type ManagedFile struct {
+	Path     string `json:"path" validate:"required,filepath"`
+	Interval string `json:"interval" validate:"required"`
+	Rotate   int64  `json:"rotate" validate:"required,gte=1"`
+	Filename string `json:"filename" validate:"omitempty,filename,alphanum"`
+}
+
+// ManagedConfig holds the files of a source server or of a group of source
+// servers. ScopeKey is id of source server or name of group
+type ManagedConfig struct {
+	ID        uint      `gorm:"primaryKey;not null" json:"id"`
+	Scope     string    `gorm:"type:string;not null;uniqueIndex:idx_managed_config_scope" json:"scope"`
+	ScopeKey  string    `gorm:"type:string;not null;uniqueIndex:idx_managed_config_scope" json:"scope_key"`
+	Files     string    `gorm:"type:text;not null" json:"-"`
+	UpdatedBy uint      `gorm:"not null" json:"updated_by"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func (mc *ManagedConfig) ManagedFiles() []ManagedFile {
+	files := []ManagedFile{}
+	if mc == nil || mc.Files == "" {
+		return files
+	}
+	if err := json.Unmarshal([]byte(mc.Files), &files); err != nil {
+		log.Default().Printf("[Unhandled] error in decoding files of managed config '%d', error: %s\n", mc.ID, err.Error())
+	}
+	return files
+}
+
+func (sr *SrvRepository) FindManagedConfig(scope, scopeKey string) (*ManagedConfig, error) {
+	var managedConfig ManagedConfig
+	dbResult := sr.db.Model(&ManagedConfig{}).Where(ManagedConfig{Scope: scope, ScopeKey: scopeKey}).First(&managedConfig)
+	if dbResult.Error != nil {
+		if errors.Is(dbResult.Error, gorm.ErrRecordNotFound) {
+			return nil, xerrors.ErrRecordNotFound
+		}
+		log.Default().Printf("[Unhandled] error in finding managed config of %s '%s', error: %s\n", scope, scopeKey, dbResult.Error.Error())
+		return nil, xerrors.ErrUnhandled
+	}
+
+	return &managedConfig, nil
+}
+
+// SaveManagedConfig creates or replaces managed config of its scope
+func (sr *SrvRepository) SaveManagedConfig(managedConfig *ManagedConfig) error {
+	dbResult := sr.db.Model(&ManagedConfig{}).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "scope"}, {Name: "scope_key"}},
+		DoUpdates: clause.AssignmentColumns([]string{"files", "updated_by", "updated_at"}),
+	}).Create(managedConfig)
+	if dbResult.Error != nil {
+		log.Default().Printf("[Unhandled] error in saving managed config of %s '%s', error: %s\n", managedConfig.Scope, managedConfig.ScopeKey, dbResult.Error.Error())
+		return xerrors.ErrUnhandled
+	}
+
+	return nil
+}
@@ -0,0 +1,71 @@
+package sourceserver
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+)
+
+// requestSigningLabel derives key which agents sign requests by from their
+// api key. it keeps signing key apart from hashed api key, which is only the
+// login verifier. agent derives the same key by this label
+const requestSigningLabel = "archivo-request-signing"
+
+// requestKeyWrapLabel derives key which request signing keys are encrypted
+// by from server signing key, so reading database alone does not give a key
+// to sign agent requests
+const requestKeyWrapLabel = "archivo-request-key-wrap"
+
+var errNoServerSigningKey = errors.New("server signing key is not loaded")
+
+func deriveRequestSigningKey(apiKey string) []byte {
+	mac := hmac.New(sha256.New, []byte(apiKey))
+	mac.Write([]byte(requestSigningLabel))
+	return mac.Sum(nil)
+}
+
+func (sm *SrvManager) requestKeyCipher() (cipher.AEAD, error) {
+	if sm.config.SigningKey == nil {
+		return nil, errNoServerSigningKey
+	}
+	mac := hmac.New(sha256.New, sm.config.SigningKey.Seed())
+	mac.Write([]byte(requestKeyWrapLabel))
+	block, err := aes.NewCipher(mac.Sum(nil))
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// wrapRequestSigningKey returns request signing key of api key, encrypted
+// for storing as nonce and sealed key in hex
+func (sm *SrvManager) wrapRequestSigningKey(apiKey string) (string, error) {
+	aead, err := sm.requestKeyCipher()
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(aead.Seal(nonce, nonce, deriveRequestSigningKey(apiKey), nil)), nil
+}
+
+func (sm *SrvManager) unwrapRequestSigningKey(wrapped string) ([]byte, error) {
+	aead, err := sm.requestKeyCipher()
+	if err != nil {
+		return nil, err
+	}
+	sealed, err := hex.DecodeString(wrapped)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < aead.NonceSize() {
+		return nil, errors.New("wrapped request signing key is too short")
+	}
+	return aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], nil)
+}
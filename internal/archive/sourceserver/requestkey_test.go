@@ -0,0 +1,113 @@
+package sourceserver
+
+import (
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"testing"
+
+	"github.com/ARTM2000/archivo/internal/archive/xerrors"
+)
+
+func newTestSigningKey(t *testing.T) ed25519.PrivateKey {
+	t.Helper()
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("generate signing key: %s", err)
+	}
+	return key
+}
+
+func signTestMessage(key []byte, message []byte) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(message)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestAuthorizeSignedRequest(t *testing.T) {
+	repo := newTestRepository(t)
+	srvManager := NewSrvManager(SrvConfig{SigningKey: newTestSigningKey(t)}, repo)
+	result, err := srvManager.RegisterNewSourceServer("web1")
+	if err != nil {
+		t.Fatalf("register source server: %s", err)
+	}
+	if result.NewServer.RequestSigningKey == "" {
+		t.Fatal("request signing key is not stored")
+	}
+
+	message := []byte("POST\n/api/v1/servers/file/new\n1700000000\nnonce\n" + hex.EncodeToString(make([]byte, sha256.Size)))
+	signature := signTestMessage(deriveRequestSigningKey(result.APIKey), message)
+	if _, err := srvManager.AuthorizeSignedRequest("web1", message, signature); err != nil {
+		t.Fatalf("authorize request signed by derived key: %s", err)
+	}
+
+	// hashed api key is readable from database, so it should not sign requests
+	hashedAPIKey, _ := hex.DecodeString(result.NewServer.HashedAPIKey)
+	if _, err := srvManager.AuthorizeSignedRequest("web1", message, signTestMessage(hashedAPIKey, message)); !errors.Is(err, xerrors.ErrUnauthorized) {
+		t.Fatalf("authorizing request signed by hashed api key returned %v, want %v", err, xerrors.ErrUnauthorized)
+	}
+
+	// another server signing key can not unwrap the stored key
+	otherManager := NewSrvManager(SrvConfig{SigningKey: newTestSigningKey(t)}, repo)
+	if _, err := otherManager.AuthorizeSignedRequest("web1", message, signature); !errors.Is(err, xerrors.ErrUnauthorized) {
+		t.Fatalf("authorizing by other server signing key returned %v, want %v", err, xerrors.ErrUnauthorized)
+	}
+}
+
+func TestRotatedAPIKeyReplacesRequestSigningKey(t *testing.T) {
+	repo := newTestRepository(t)
+	srvManager := NewSrvManager(SrvConfig{SigningKey: newTestSigningKey(t)}, repo)
+	created, err := srvManager.RegisterNewSourceServer("web1")
+	if err != nil {
+		t.Fatalf("register source server: %s", err)
+	}
+	rotated, err := srvManager.RotateSourceServerAPIKey("web1")
+	if err != nil {
+		t.Fatalf("rotate api key: %s", err)
+	}
+
+	message := []byte("GET\n/api/v1/servers/agent/config\n1700000000\nnonce\n" + hex.EncodeToString(make([]byte, sha256.Size)))
+	if _, err := srvManager.AuthorizeSignedRequest("web1", message, signTestMessage(deriveRequestSigningKey(created.APIKey), message)); !errors.Is(err, xerrors.ErrUnauthorized) {
+		t.Fatalf("authorizing request signed by old api key returned %v, want %v", err, xerrors.ErrUnauthorized)
+	}
+	if _, err := srvManager.AuthorizeSignedRequest("web1", message, signTestMessage(deriveRequestSigningKey(rotated.APIKey), message)); err != nil {
+		t.Fatalf("authorize request signed by rotated api key: %s", err)
+	}
+}
+
+func TestSourceServerWithoutRequestSigningKey(t *testing.T) {
+	repo := newTestRepository(t)
+	createTestSrv(t, repo, "web1")
+	srvManager := NewSrvManager(SrvConfig{SigningKey: newTestSigningKey(t)}, repo)
+
+	message := []byte("GET\n/\n1700000000\nnonce\n")
+	if _, err := srvManager.AuthorizeSignedRequest("web1", message, signTestMessage([]byte("key"), message)); !errors.Is(err, xerrors.ErrUnauthorized) {
+		t.Fatalf("authorizing source server without request signing key returned %v, want %v", err, xerrors.ErrUnauthorized)
+	}
+}
+
+func TestAPIKeyRequestStoresRequestSigningKey(t *testing.T) {
+	repo := newTestRepository(t)
+	apiKey := "legacy-api-key"
+	hashedAPIKey := sha256.Sum256([]byte(apiKey))
+	if _, err := repo.CreateNewSrv("web1", hex.EncodeToString(hashedAPIKey[:]), ""); err != nil {
+		t.Fatalf("create source server: %s", err)
+	}
+	srvManager := NewSrvManager(SrvConfig{SigningKey: newTestSigningKey(t)}, repo)
+
+	message := []byte("GET\n/api/v1/servers/store/config\n1700000000\nnonce\n" + hex.EncodeToString(make([]byte, sha256.Size)))
+	signature := signTestMessage(deriveRequestSigningKey(apiKey), message)
+	if _, err := srvManager.AuthorizeSignedRequest("web1", message, signature); !errors.Is(err, xerrors.ErrUnauthorized) {
+		t.Fatalf("authorizing before api key request returned %v, want %v", err, xerrors.ErrUnauthorized)
+	}
+
+	if _, err := srvManager.AuthorizeSourceServer("web1", apiKey); err != nil {
+		t.Fatalf("authorize api key: %s", err)
+	}
+	if _, err := srvManager.AuthorizeSignedRequest("web1", message, signature); err != nil {
+		t.Fatalf("authorize signed request after api key request: %s", err)
+	}
+}
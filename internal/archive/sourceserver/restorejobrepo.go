@@ -0,0 +1,137 @@
+package sourceserver
+
+import (
+	"errors"
+	"log"
+	"time"
+
+	"github.com/ARTM2000/archivo/internal/archive/xerrors"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	RestoreJobPending   = "pending"
+	RestoreJobRunning   = "running"
+	RestoreJobSucceeded = "succeeded"
+	RestoreJobFailed    = "failed"
+)
+
+// RestoreJob is a request of restoring a snapshot on its source server. jobs
+// are created from panel and picked up by agent of source server
+type RestoreJob struct {
+	ID             uint       `gorm:"primaryKey;not null" json:"id"`
+	SourceServerID uint       `gorm:"not null;index" json:"source_server_id"`
+	Filename       string     `gorm:"type:string;not null" json:"filename"`
+	Snapshot       string     `gorm:"type:string;not null" json:"snapshot"`
+	Status         string     `gorm:"type:string;not null;index" json:"status"`
+	Message        string     `gorm:"type:string;not null;default:''" json:"message"`
+	RequestedBy    uint       `gorm:"not null" json:"requested_by"`
+	CreatedAt      time.Time  `json:"created_at"`
+	StartedAt      *time.Time `json:"started_at"`
+	FinishedAt     *time.Time `json:"finished_at"`
+}
+
+func (sr *SrvRepository) CreateRestoreJob(job *RestoreJob) error {
+	dbResult := sr.db.Model(&RestoreJob{}).Create(job)
+	if dbResult.Error != nil {
+		log.Default().Printf("[Unhandled] error in creating restore job %+v, error: %s\n", job, dbResult.Error.Error())
+		return xerrors.ErrUnhandled
+	}
+
+	return nil
+}
+
+func (sr *SrvRepository) FindRestoreJob(id uint, srvId uint) (*RestoreJob, error) {
+	var job RestoreJob
+	dbResult := sr.db.Model(&RestoreJob{}).Where(RestoreJob{ID: id, SourceServerID: srvId}).First(&job)
+	if dbResult.Error != nil {
+		if errors.Is(dbResult.Error, gorm.ErrRecordNotFound) {
+			return nil, xerrors.ErrRecordNotFound
+		}
+		log.Default().Printf("[Unhandled] error in finding restore job '%d' of source server '%d', error: %s\n", id, srvId, dbResult.Error.Error())
+		return nil, xerrors.ErrUnhandled
+	}
+
+	return &job, nil
+}
+
+func (sr *SrvRepository) FindSrvRestoreJobs(srvId uint, option FindAllOption) (*[]RestoreJob, int64, error) {
+	var jobs []RestoreJob
+	var DESC bool
+	if option.SortOrder == "ASC" {
+		DESC = false
+	} else {
+		DESC = true
+	}
+	dbResult := sr.db.Model(&RestoreJob{}).Where(RestoreJob{SourceServerID: srvId}).Order(clause.OrderByColumn{Column: clause.Column{Name: option.SortBy}, Desc: DESC}).Offset(option.Start).Limit(option.End).Find(&jobs)
+	if dbResult.Error != nil {
+		log.Default().Printf("[Unhandled] error in finding restore jobs of source server '%d', error: %s\n", srvId, dbResult.Error.Error())
+		return nil, 0, xerrors.ErrUnhandled
+	}
+
+	var total int64
+	dbResult = sr.db.Model(&RestoreJob{}).Where(RestoreJob{SourceServerID: srvId}).Count(&total)
+	if dbResult.Error != nil {
+		log.Default().Printf("[Unhandled] error in counting restore jobs of source server '%d', error: %s\n", srvId, dbResult.Error.Error())
+		return nil, 0, xerrors.ErrUnhandled
+	}
+
+	return &jobs, total, nil
+}
+
+// ClaimRestoreJob marks the oldest pending restore job of source server as
+// running and returns it. only one agent request can claim a job
+func (sr *SrvRepository) ClaimRestoreJob(srvId uint) (*RestoreJob, error) {
+	var job RestoreJob
+	err := sr.db.Transaction(func(tx *gorm.DB) error {
+		dbResult := tx.Model(&RestoreJob{}).
+			Where(RestoreJob{SourceServerID: srvId, Status: RestoreJobPending}).
+			Order("id").
+			First(&job)
+		if dbResult.Error != nil {
+			return dbResult.Error
+		}
+
+		now := time.Now()
+		dbResult = tx.Model(&RestoreJob{}).
+			Where("id = ? AND status = ?", job.ID, RestoreJobPending).
+			Updates(map[string]interface{}{"status": RestoreJobRunning, "started_at": now})
+		if dbResult.Error != nil {
+			return dbResult.Error
+		}
+		if dbResult.RowsAffected == 0 {
+			// claimed by another request in the meantime
+			return gorm.ErrRecordNotFound
+		}
+
+		job.Status = RestoreJobRunning
+		job.StartedAt = &now
+		return nil
+	})
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, xerrors.ErrRecordNotFound
+		}
+		log.Default().Printf("[Unhandled] error in claiming restore job of source server '%d', error: %s\n", srvId, err.Error())
+		return nil, xerrors.ErrUnhandled
+	}
+
+	return &job, nil
+}
+
+// FinishRestoreJob sets final status of a running restore job
+func (sr *SrvRepository) FinishRestoreJob(id uint, status, message string) error {
+	dbResult := sr.db.Model(&RestoreJob{}).
+		Where("id = ? AND status = ?", id, RestoreJobRunning).
+		Updates(map[string]interface{}{"status": status, "message": message, "finished_at": time.Now()})
+	if dbResult.Error != nil {
+		log.Default().Printf("[Unhandled] error in finishing restore job '%d', error: %s\n", id, dbResult.Error.Error())
+		return xerrors.ErrUnhandled
+	}
+	if dbResult.RowsAffected == 0 {
+		return xerrors.ErrRestoreJobNotRunning
+	}
+
+	return nil
+}
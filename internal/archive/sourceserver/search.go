@@ -0,0 +1,291 @@
+package sourceserver
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"log"
+	"path"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/ARTM2000/archivo/internal/archive/xerrors"
+)
+
+const (
+	// snapshots bigger than this are not indexed for full text search
+	maxIndexedSnapshotSize = 4 * 1024 * 1024
+	minTermLength          = 2
+	maxTermLength          = 64
+	// matching lines of each snapshot which are returned
+	maxSearchLines      = 20
+	maxSearchLineLength = 512
+)
+
+type SearchOption struct {
+	// glob pattern of filename, or of name or path of source file
+	Pattern string
+	// text which lines of snapshots should contain. no content is searched
+	// when it is empty
+	Query string
+	// maximum count of snapshots which match query
+	Limit int
+}
+
+type SearchFile struct {
+	SourceServerID uint      `json:"source_server_id"`
+	SourceServer   string    `json:"source_server"`
+	Filename       string    `json:"filename"`
+	SourcePath     string    `json:"source_path"`
+	Snapshots      int       `json:"snapshots"`
+	UpdatedAt      time.Time `json:"updated_at"`
+}
+
+type SearchLine struct {
+	Number int    `json:"number"`
+	Text   string `json:"text"`
+}
+
+type SearchMatch struct {
+	SourceServerID uint         `json:"source_server_id"`
+	SourceServer   string       `json:"source_server"`
+	Filename       string       `json:"filename"`
+	Snapshot       string       `json:"snapshot"`
+	SourcePath     string       `json:"source_path"`
+	CreatedAt      time.Time    `json:"created_at"`
+	Lines          []SearchLine `json:"lines"`
+}
+
+type SearchResult struct {
+	Files []SearchFile `json:"files"`
+	// snapshots whose content matches query, in order of creation. so the
+	// first match of a file shows when the text appeared in it
+	Matches []SearchMatch `json:"matches"`
+	// more snapshots than limit match query
+	Truncated bool `json:"truncated"`
+}
+
+func isTermRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_'
+}
+
+// searchTerms splits text into unique lower case words, which index and
+// queries are made of
+func searchTerms(text string) []string {
+	seen := map[string]bool{}
+	terms := []string{}
+	for _, word := range strings.FieldsFunc(strings.ToLower(text), func(r rune) bool { return !isTermRune(r) }) {
+		if utf8.RuneCountInString(word) < minTermLength || utf8.RuneCountInString(word) > maxTermLength || seen[word] {
+			continue
+		}
+		seen[word] = true
+		terms = append(terms, word)
+	}
+	return terms
+}
+
+// isText reports whether content of snapshot is searchable text
+func isText(content []byte) bool {
+	return len(content) <= maxIndexedSnapshotSize && bytes.IndexByte(content, 0) == -1 && utf8.Valid(content)
+}
+
+// indexSnapshot records terms of snapshot content for full text search.
+// binary and big snapshots have no terms
+func (sm *SrvManager) indexSnapshot(srcSrvName string, snapshot *Snapshot) error {
+	terms := []string{}
+	if snapshot.ByteSize <= maxIndexedSnapshotSize {
+		content, err := sm.getStoreManager().ReadSnapshot(srcSrvName, snapshot.Filename, snapshot.Name)
+		if err != nil {
+			return err
+		}
+		if isText(*content) {
+			terms = searchTerms(string(*content))
+		}
+	}
+	return sm.srvRepository.ReplaceSnapshotTerms(snapshot.ID, terms)
+}
+
+// RebuildSearchIndex indexes all snapshots again, e.g. the ones which are
+// uploaded before full text search existence
+func (sm *SrvManager) RebuildSearchIndex() (int, error) {
+	sourceServers, err := sm.srvRepository.AllSourceServers()
+	if err != nil {
+		return 0, err
+	}
+
+	indexed := 0
+	for _, srv := range *sourceServers {
+		snapshots, err := sm.srvRepository.FindSrvSnapshots(srv.ID)
+		if err != nil {
+			return indexed, err
+		}
+		for i := range *snapshots {
+			snapshot := &(*snapshots)[i]
+			if err := sm.indexSnapshot(srv.Name, snapshot); err != nil {
+				log.Default().Printf(
+					"error in indexing snapshot '%s' of file '%s' of source server '%s', error: %s",
+					snapshot.Name, snapshot.Filename, srv.Name, err.Error(),
+				)
+				continue
+			}
+			indexed++
+		}
+	}
+	return indexed, nil
+}
+
+// matchPattern matches pattern against filename of store and name and path
+// of source file, case insensitive
+func matchPattern(pattern, filename, sourcePath string) bool {
+	pattern = strings.ToLower(pattern)
+	candidates := []string{strings.ToLower(filename)}
+	if sourcePath != "" {
+		candidates = append(candidates, strings.ToLower(path.Base(sourcePath)), strings.ToLower(sourcePath))
+	}
+	for _, candidate := range candidates {
+		if matched, _ := path.Match(pattern, candidate); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// matchingLines returns lines of content which contain query, case insensitive
+func matchingLines(content []byte, query string) []SearchLine {
+	query = strings.ToLower(query)
+	lines := []SearchLine{}
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	scanner.Buffer(make([]byte, 0, 64*1024), maxIndexedSnapshotSize)
+	for number := 1; scanner.Scan(); number++ {
+		line := scanner.Text()
+		if !strings.Contains(strings.ToLower(line), query) {
+			continue
+		}
+		if len(line) > maxSearchLineLength {
+			line = strings.ToValidUTF8(line[:maxSearchLineLength], "")
+		}
+		lines = append(lines, SearchLine{Number: number, Text: line})
+		if len(lines) == maxSearchLines {
+			break
+		}
+	}
+	return lines
+}
+
+// Search finds files of all source servers which match pattern and, when
+// query is set, snapshots of them whose content contains query. candidate
+// snapshots are found by index and their lines are matched on read
+func (sm *SrvManager) Search(option SearchOption) (*SearchResult, error) {
+	if option.Pattern == "" {
+		option.Pattern = "*"
+	}
+	if _, err := path.Match(option.Pattern, ""); err != nil {
+		return nil, xerrors.ErrInvalidSearchPattern
+	}
+	var terms []string
+	if option.Query != "" {
+		terms = searchTerms(option.Query)
+		if len(terms) == 0 {
+			return nil, xerrors.ErrSearchQueryTooShort
+		}
+	}
+
+	sourceServers, err := sm.srvRepository.AllSourceServers()
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(*sourceServers, func(i, j int) bool {
+		return (*sourceServers)[i].Name < (*sourceServers)[j].Name
+	})
+
+	storeManager := sm.getStoreManager()
+	result := SearchResult{Files: []SearchFile{}, Matches: []SearchMatch{}}
+	srvNames := map[uint]string{}
+	// source path of each matched file, by source server id and filename
+	matchedFiles := map[uint]map[string]string{}
+	for _, srv := range *sourceServers {
+		files, err := storeManager.FilesList(srv.Name)
+		if err != nil {
+			if errors.Is(err, xerrors.ErrNoStoreForSourceServer) {
+				continue
+			}
+			log.Default().Printf("[Unhandled] error in finding files for source server by name '%s', error: %s", srv.Name, err)
+			return nil, xerrors.ErrUnhandled
+		}
+
+		snapshots, err := sm.srvRepository.FindSrvSnapshots(srv.ID)
+		if err != nil {
+			return nil, err
+		}
+		// source path of the last snapshot is the current one
+		sourcePaths := map[string]string{}
+		for _, snapshot := range *snapshots {
+			if snapshot.SourcePath != "" {
+				sourcePaths[snapshot.Filename] = snapshot.SourcePath
+			}
+		}
+
+		srvNames[srv.ID] = srv.Name
+		matchedFiles[srv.ID] = map[string]string{}
+		for _, file := range files {
+			if !matchPattern(option.Pattern, file.FileName, sourcePaths[file.FileName]) {
+				continue
+			}
+			matchedFiles[srv.ID][file.FileName] = sourcePaths[file.FileName]
+			result.Files = append(result.Files, SearchFile{
+				SourceServerID: srv.ID,
+				SourceServer:   srv.Name,
+				Filename:       file.FileName,
+				SourcePath:     sourcePaths[file.FileName],
+				Snapshots:      file.Snapshots,
+				UpdatedAt:      file.UpdatedAt,
+			})
+		}
+	}
+
+	if len(terms) == 0 {
+		return &result, nil
+	}
+
+	candidates, err := sm.srvRepository.FindSnapshotsByTerms(terms)
+	if err != nil {
+		return nil, err
+	}
+	for _, snapshot := range *candidates {
+		sourcePath, matched := matchedFiles[snapshot.SourceServerID][snapshot.Filename]
+		if !matched {
+			continue
+		}
+		content, err := storeManager.ReadSnapshot(srvNames[snapshot.SourceServerID], snapshot.Filename, snapshot.Name)
+		if err != nil {
+			// snapshot is rotated since it is found
+			continue
+		}
+		lines := matchingLines(*content, option.Query)
+		if len(lines) == 0 {
+			continue
+		}
+
+		if option.Limit > 0 && len(result.Matches) == option.Limit {
+			result.Truncated = true
+			break
+		}
+		if snapshot.SourcePath != "" {
+			sourcePath = snapshot.SourcePath
+		}
+		result.Matches = append(result.Matches, SearchMatch{
+			SourceServerID: snapshot.SourceServerID,
+			SourceServer:   srvNames[snapshot.SourceServerID],
+			Filename:       snapshot.Filename,
+			Snapshot:       snapshot.Name,
+			SourcePath:     sourcePath,
+			CreatedAt:      snapshot.CreatedAt,
+			Lines:          lines,
+		})
+	}
+
+	return &result, nil
+}
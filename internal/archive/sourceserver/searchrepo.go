@@ -0,0 +1,58 @@
+package sourceserver
+
+import (
+	"log"
+
+	"github.com/ARTM2000/archivo/internal/archive/xerrors"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// SnapshotTerm is an entry of inverted index of text snapshots, which full
+// text search finds candidate snapshots by
+type SnapshotTerm struct {
+	Term       string `gorm:"type:string;primaryKey" json:"term"`
+	SnapshotID uint   `gorm:"primaryKey;autoIncrement:false;index" json:"snapshot_id"`
+}
+
+// ReplaceSnapshotTerms replaces indexed terms of snapshot
+func (sr *SrvRepository) ReplaceSnapshotTerms(snapshotId uint, terms []string) error {
+	err := sr.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("snapshot_id = ?", snapshotId).Delete(&SnapshotTerm{}).Error; err != nil {
+			return err
+		}
+		if len(terms) == 0 {
+			return nil
+		}
+
+		snapshotTerms := make([]SnapshotTerm, 0, len(terms))
+		for _, term := range terms {
+			snapshotTerms = append(snapshotTerms, SnapshotTerm{Term: term, SnapshotID: snapshotId})
+		}
+		return tx.Clauses(clause.OnConflict{DoNothing: true}).CreateInBatches(snapshotTerms, 500).Error
+	})
+	if err != nil {
+		log.Default().Printf("[Unhandled] error in indexing terms of snapshot '%d', error: %s\n", snapshotId, err.Error())
+		return xerrors.ErrUnhandled
+	}
+
+	return nil
+}
+
+// FindSnapshotsByTerms returns snapshots which have all of terms, in order of
+// creation. rotated snapshots are not included
+func (sr *SrvRepository) FindSnapshotsByTerms(terms []string) (*[]Snapshot, error) {
+	var snapshots []Snapshot
+	matched := sr.db.Model(&SnapshotTerm{}).
+		Select("snapshot_id").
+		Where("term IN ?", terms).
+		Group("snapshot_id").
+		Having("COUNT(*) = ?", len(terms))
+	dbResult := sr.db.Model(&Snapshot{}).Where("id IN (?)", matched).Order("id").Find(&snapshots)
+	if dbResult.Error != nil {
+		log.Default().Printf("[Unhandled] error in finding snapshots by terms %v, error: %s\n", terms, dbResult.Error.Error())
+		return nil, xerrors.ErrUnhandled
+	}
+
+	return &snapshots, nil
+}
@@ -0,0 +1,189 @@
+package sourceserver
+
+import (
+	"errors"
+	"log"
+	"time"
+
+	"github.com/ARTM2000/archivo/internal/archive/xerrors"
+	"gorm.io/gorm"
+)
+
+// FileMetadata describes the source file of a snapshot on its source server,
+// as it was reported by agent on upload
+type FileMetadata struct {
+	SourcePath string     `gorm:"type:string;not null;default:''" json:"source_path"`
+	Mode       *uint32    `json:"mode"`
+	Uid        *int       `json:"uid"`
+	Gid        *int       `json:"gid"`
+	OwnerName  string     `gorm:"type:string;not null;default:''" json:"owner_name"`
+	GroupName  string     `gorm:"type:string;not null;default:''" json:"group_name"`
+	ModTime    *time.Time `json:"mod_time"`
+	Host       string     `gorm:"type:string;not null;default:''" json:"host"`
+}
+
+type Snapshot struct {
+	ID             uint       `gorm:"primaryKey;not null" json:"id"`
+	SourceServerID uint       `gorm:"not null;uniqueIndex:idx_snapshot_file_name" json:"source_server_id"`
+	Filename       string     `gorm:"type:string;not null;uniqueIndex:idx_snapshot_file_name" json:"filename"`
+	Name           string     `gorm:"type:string;not null;uniqueIndex:idx_snapshot_file_name" json:"name"`
+	Checksum       string     `gorm:"type:string;not null" json:"checksum"`
+	ByteSize       int64      `gorm:"not null" json:"byte_size"`
+	Corrupted      bool       `gorm:"type:bool;not null;default:false" json:"corrupted"`
+	VerifiedAt     *time.Time `json:"verified_at"`
+	PrevHash       string     `gorm:"type:string;not null;default:''" json:"prev_hash"`
+	RecordHash     string     `gorm:"type:string;not null;default:''" json:"record_hash"`
+	Signature      string     `gorm:"type:string;not null;default:''" json:"signature"`
+	Pinned         bool       `gorm:"type:bool;not null;default:false" json:"pinned"`
+	PinNote        string     `gorm:"type:string;not null;default:''" json:"pin_note"`
+	PinnedBy       *uint      `json:"pinned_by"`
+	PinnedAt       *time.Time `json:"pinned_at"`
+	FileMetadata   `gorm:"embedded"`
+	CreatedAt      time.Time `gorm:"autoCreateTime:milli" json:"created_at"`
+	// rotated snapshots are soft deleted to keep their file history chain intact
+	DeletedAt gorm.DeletedAt `json:"-"`
+}
+
+func (sr *SrvRepository) CreateSnapshot(snapshot *Snapshot) error {
+	dbResult := sr.db.Model(&Snapshot{}).Create(snapshot)
+	if dbResult.Error != nil {
+		log.Default().Printf("[Unhandled] error in creating snapshot record %+v, error: %s\n", snapshot, dbResult.Error.Error())
+		return xerrors.ErrUnhandled
+	}
+
+	return nil
+}
+
+func (sr *SrvRepository) FindFileSnapshots(srvId uint, filename string) (*[]Snapshot, error) {
+	var snapshots []Snapshot
+	dbResult := sr.db.Model(&Snapshot{}).Where(Snapshot{SourceServerID: srvId, Filename: filename}).Order("name").Find(&snapshots)
+	if dbResult.Error != nil {
+		log.Default().Printf("[Unhandled] error in finding snapshots of source server '%d' filename '%s', error: %s\n", srvId, filename, dbResult.Error.Error())
+		return nil, xerrors.ErrUnhandled
+	}
+
+	return &snapshots, nil
+}
+
+// FindLastFileSnapshot returns the latest snapshot record of file, including
+// the rotated ones
+func (sr *SrvRepository) FindLastFileSnapshot(srvId uint, filename string) (*Snapshot, error) {
+	var snapshot Snapshot
+	dbResult := sr.db.Unscoped().Model(&Snapshot{}).Where(Snapshot{SourceServerID: srvId, Filename: filename}).Order("id DESC").First(&snapshot)
+	if dbResult.Error != nil {
+		if errors.Is(dbResult.Error, gorm.ErrRecordNotFound) {
+			return nil, xerrors.ErrRecordNotFound
+		}
+		log.Default().Printf("[Unhandled] error in finding last snapshot of source server '%d' filename '%s', error: %s\n", srvId, filename, dbResult.Error.Error())
+		return nil, xerrors.ErrUnhandled
+	}
+
+	return &snapshot, nil
+}
+
+// FindFileSnapshotsHistory returns all snapshot records of file in order of
+// creation, including the rotated ones
+func (sr *SrvRepository) FindFileSnapshotsHistory(srvId uint, filename string) (*[]Snapshot, error) {
+	var snapshots []Snapshot
+	dbResult := sr.db.Unscoped().Model(&Snapshot{}).Where(Snapshot{SourceServerID: srvId, Filename: filename}).Order("id").Find(&snapshots)
+	if dbResult.Error != nil {
+		log.Default().Printf("[Unhandled] error in finding snapshots history of source server '%d' filename '%s', error: %s\n", srvId, filename, dbResult.Error.Error())
+		return nil, xerrors.ErrUnhandled
+	}
+
+	return &snapshots, nil
+}
+
+func (sr *SrvRepository) FindSnapshot(srvId uint, filename, name string) (*Snapshot, error) {
+	var snapshot Snapshot
+	dbResult := sr.db.Model(&Snapshot{}).Where(Snapshot{SourceServerID: srvId, Filename: filename, Name: name}).First(&snapshot)
+	if dbResult.Error != nil {
+		if errors.Is(dbResult.Error, gorm.ErrRecordNotFound) {
+			return nil, xerrors.ErrRecordNotFound
+		}
+		log.Default().Printf("[Unhandled] error in finding snapshot '%s' of source server '%d' filename '%s', error: %s\n", name, srvId, filename, dbResult.Error.Error())
+		return nil, xerrors.ErrUnhandled
+	}
+
+	return &snapshot, nil
+}
+
+func (sr *SrvRepository) UpdateSnapshotPin(id uint, pinned bool, note string, userId *uint) error {
+	var pinnedAt *time.Time
+	if pinned {
+		now := time.Now()
+		pinnedAt = &now
+	} else {
+		userId = nil
+		note = ""
+	}
+
+	dbResult := sr.db.Model(&Snapshot{ID: id}).Updates(map[string]interface{}{
+		"pinned":    pinned,
+		"pin_note":  note,
+		"pinned_by": userId,
+		"pinned_at": pinnedAt,
+	})
+	if dbResult.Error != nil {
+		log.Default().Printf("[Unhandled] error in updating snapshot '%d' pin, error: %s\n", id, dbResult.Error.Error())
+		return xerrors.ErrUnhandled
+	}
+
+	return nil
+}
+
+func (sr *SrvRepository) FindSrvSnapshots(srvId uint) (*[]Snapshot, error) {
+	var snapshots []Snapshot
+	dbResult := sr.db.Model(&Snapshot{}).Where(Snapshot{SourceServerID: srvId}).Order("filename").Order("name").Find(&snapshots)
+	if dbResult.Error != nil {
+		log.Default().Printf("[Unhandled] error in finding snapshots of source server '%d', error: %s\n", srvId, dbResult.Error.Error())
+		return nil, xerrors.ErrUnhandled
+	}
+
+	return &snapshots, nil
+}
+
+func (sr *SrvRepository) FindCorruptedSnapshots() (*[]Snapshot, error) {
+	var snapshots []Snapshot
+	dbResult := sr.db.Model(&Snapshot{}).Where("corrupted = ?", true).Order("source_server_id").Order("filename").Order("name").Find(&snapshots)
+	if dbResult.Error != nil {
+		log.Default().Println("[Unhandled] error in finding corrupted snapshots", dbResult.Error)
+		return nil, xerrors.ErrUnhandled
+	}
+
+	return &snapshots, nil
+}
+
+func (sr *SrvRepository) UpdateSnapshotVerification(id uint, corrupted bool, verifiedAt time.Time) error {
+	dbResult := sr.db.Model(&Snapshot{ID: id}).Updates(map[string]interface{}{
+		"corrupted":   corrupted,
+		"verified_at": verifiedAt,
+	})
+	if dbResult.Error != nil {
+		log.Default().Printf("[Unhandled] error in updating snapshot '%d' verification, error: %s\n", id, dbResult.Error.Error())
+		return xerrors.ErrUnhandled
+	}
+
+	return nil
+}
+
+func (sr *SrvRepository) DeleteSnapshots(srvId uint, filename string, names []string) error {
+	if len(names) == 0 {
+		return nil
+	}
+
+	// rotated snapshots are not searchable anymore, so their terms are removed
+	err := sr.db.Transaction(func(tx *gorm.DB) error {
+		rotated := tx.Model(&Snapshot{}).Select("id").Where("source_server_id = ? AND filename = ? AND name IN ?", srvId, filename, names)
+		if err := tx.Where("snapshot_id IN (?)", rotated).Delete(&SnapshotTerm{}).Error; err != nil {
+			return err
+		}
+		return tx.Where("source_server_id = ? AND filename = ? AND name IN ?", srvId, filename, names).Delete(&Snapshot{}).Error
+	})
+	if err != nil {
+		log.Default().Printf("[Unhandled] error in deleting snapshots of source server '%d' filename '%s', error: %s\n", srvId, filename, err.Error())
+		return xerrors.ErrUnhandled
+	}
+
+	return nil
+}
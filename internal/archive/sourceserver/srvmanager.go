@@ -1,20 +1,26 @@
 package sourceserver
 
 import (
+	"crypto/ed25519"
+	"crypto/hmac"
 	"crypto/rand"
 	"crypto/sha256"
 	"encoding/base64"
 	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"log"
 	"mime/multipart"
 	"net/http"
+	"path"
 	"sort"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/ARTM2000/archivo/internal/archive/xerrors"
+	"github.com/robfig/cron/v3"
 )
 
 const GlobalFileRotateLimit = 100
@@ -32,9 +38,66 @@ type newSrvSrcResult struct {
 }
 
 type SrvConfig struct {
-	CorrelationId   string
-	StoreMode       string
-	DiskStoreConfig DiskStoreConfig
+	CorrelationId    string
+	StoreMode        string
+	DiskStoreConfig  DiskStoreConfig
+	SigningKey       ed25519.PrivateKey
+	DefaultQuota     Quota
+	DefaultMaxUpload int64 // zero means no limit
+	// agents which have not sent heartbeat for this duration are offline.
+	// zero means default
+	AgentOfflineAfter time.Duration
+}
+
+// Quota limits total snapshots size and count of a source server. zero value
+// means no limit
+type Quota struct {
+	MaxBytes     int64 `json:"max_bytes"`
+	MaxSnapshots int   `json:"max_snapshots"`
+}
+
+type SourceServerInfo struct {
+	SourceServer
+	UsedBytes     int64       `json:"used_bytes"`
+	UsedSnapshots int         `json:"used_snapshots"`
+	Quota         Quota       `json:"quota"`
+	MaxUpload     int64       `json:"max_upload"`
+	Agent         AgentStatus `json:"agent"`
+}
+
+const defaultAgentOfflineAfter = 3 * time.Minute
+
+// AgentStatus is the state of source server agent by its last heartbeat.
+// agent is flagged when some of its files are unreachable or failing
+type AgentStatus struct {
+	Online           bool       `json:"online"`
+	Flagged          bool       `json:"flagged"`
+	LastSeenAt       *time.Time `json:"last_seen_at"`
+	Version          string     `json:"version"`
+	Hostname         string     `json:"hostname"`
+	FailingFiles     int        `json:"failing_files"`
+	UnreachableFiles int        `json:"unreachable_files"`
+}
+
+// HeartbeatInfo is the last heartbeat of source server agent with files it
+// reported
+type HeartbeatInfo struct {
+	Heartbeat
+	Online  bool            `json:"online"`
+	Flagged bool            `json:"flagged"`
+	Files   []HeartbeatFile `json:"files"`
+}
+
+// ManagedConfigInfo is the managed config of a source server. effective
+// files are files of its group overridden by its own files by filename
+type ManagedConfigInfo struct {
+	Group           string        `json:"group"`
+	Files           []ManagedFile `json:"files"`
+	GroupFiles      []ManagedFile `json:"group_files"`
+	EffectiveFiles  []ManagedFile `json:"effective_files"`
+	Revision        string        `json:"revision"`
+	AppliedRevision string        `json:"applied_revision"`
+	AppliedSeenAt   *time.Time    `json:"applied_seen_at"`
 }
 
 type SrvManager struct {
@@ -50,21 +113,55 @@ type FileList struct {
 }
 
 type SnapshotList struct {
-	ID        uint32    `json:"id"`
-	Name      string    `json:"name"`
-	Size      string    `json:"size"`
-	ByteSize  int64     `json:"byte_size"`
-	Checksum  string    `json:"checksum"`
-	CreatedAt time.Time `json:"created_at"`
+	ID         uint32        `json:"id"`
+	Name       string        `json:"name"`
+	Size       string        `json:"size"`
+	ByteSize   int64         `json:"byte_size"`
+	Checksum   string        `json:"checksum"`
+	Corrupted  bool          `json:"corrupted"`
+	VerifiedAt *time.Time    `json:"verified_at"`
+	Pinned     bool          `json:"pinned"`
+	PinNote    string        `json:"pin_note"`
+	PinnedAt   *time.Time    `json:"pinned_at"`
+	LegalHold  bool          `json:"legal_hold"`
+	Metadata   *FileMetadata `json:"metadata"`
+	CreatedAt  time.Time     `json:"created_at"`
+}
+
+type StoredSnapshot struct {
+	Name     string
+	Checksum string
+	ByteSize int64
+}
+
+type IntegrityMismatch struct {
+	SourceServer string `json:"source_server"`
+	Filename     string `json:"filename"`
+	Snapshot     string `json:"snapshot"`
+	Expected     string `json:"expected"`
+	Actual       string `json:"actual"`
+	Reason       string `json:"reason"`
+}
+
+type IntegrityReport struct {
+	Checked    int                 `json:"checked"`
+	Adopted    int                 `json:"adopted"`
+	Failed     int                 `json:"failed"`
+	Mismatches []IntegrityMismatch `json:"mismatches"`
+	StartedAt  time.Time           `json:"started_at"`
+	FinishedAt time.Time           `json:"finished_at"`
 }
 
 type StoreManager interface {
-	FileStore(srcSrvName, fileName string, file *multipart.FileHeader, correlationId string) error
-	FileRotate(srcSrvName, fileName string, rotate int, correlationId string) error
+	FileStore(srcSrvName, fileName string, file *multipart.FileHeader, checksum string, correlationId string) (*StoredSnapshot, error)
+	FileRotate(srcSrvName, fileName string, rotate int, protected func(snapshot string) bool, correlationId string) ([]string, error)
 	FileStoreValidate(srcSrvName, fileName string, rotate int) error
 	FilesList(srcSrvName string) ([]FileList, error)
 	SnapshotsList(srcSrvName, filename string) ([]SnapshotList, error)
 	ReadSnapshot(srcSrvName, filename, snapshot string) (*[]byte, error)
+	Usage(srcSrvName string) (int64, int, error)
+	DeleteStore(srcSrvName string) error
+	Recover() error
 }
 
 func ByteCountDecimal(b int64) string {
@@ -168,7 +265,7 @@ func (sm *SrvManager) TotalSnapshotsSize() (string, error) {
 	return ByteCountDecimal(totalSnapshotsSize), nil
 }
 
-func (sm *SrvManager) GetListOfAllSourceServers(option FindAllOption) (*[]SourceServer, int64, error) {
+func (sm *SrvManager) GetListOfAllSourceServers(option FindAllOption) (*[]SourceServerInfo, int64, error) {
 	servers, total, err := sm.srvRepository.FindAllServers(option)
 
 	if err != nil {
@@ -176,7 +273,144 @@ func (sm *SrvManager) GetListOfAllSourceServers(option FindAllOption) (*[]Source
 		return nil, 0, xerrors.ErrUnhandled
 	}
 
-	return servers, total, nil
+	srvIds := []uint{}
+	for _, srv := range *servers {
+		srvIds = append(srvIds, srv.ID)
+	}
+	heartbeats, err := sm.srvRepository.FindHeartbeats(srvIds)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	storeManager := sm.getStoreManager()
+	serversInfo := []SourceServerInfo{}
+	for _, srv := range *servers {
+		var heartbeat *Heartbeat
+		if hb, exists := heartbeats[srv.ID]; exists {
+			heartbeat = &hb
+		}
+		usedBytes, usedSnapshots, err := storeManager.Usage(srv.Name)
+		if err != nil {
+			log.Default().Printf("error in getting usage of source server '%s', error: %+v", srv.Name, err)
+		}
+		serversInfo = append(serversInfo, SourceServerInfo{
+			SourceServer:  srv,
+			UsedBytes:     usedBytes,
+			UsedSnapshots: usedSnapshots,
+			Quota:         sm.effectiveQuota(&srv),
+			MaxUpload:     sm.MaxUploadSize(&srv),
+			Agent:         sm.agentStatus(heartbeat),
+		})
+	}
+
+	return &serversInfo, total, nil
+}
+
+func (sm *SrvManager) effectiveQuota(srv *SourceServer) Quota {
+	quota := sm.config.DefaultQuota
+	if srv.QuotaBytes != nil {
+		quota.MaxBytes = *srv.QuotaBytes
+	}
+	if srv.QuotaSnapshots != nil {
+		quota.MaxSnapshots = *srv.QuotaSnapshots
+	}
+	return quota
+}
+
+// checkQuota makes sure that storing a new snapshot of size bytes does not
+// exceed the source server quota, taking snapshots which would be rotated out
+// by this store into account
+func (sm *SrvManager) checkQuota(srv *SourceServer, filename string, rotate int, size int64, protected func(snapshot string) bool) error {
+	quota := sm.effectiveQuota(srv)
+	if quota.MaxBytes == 0 && quota.MaxSnapshots == 0 {
+		return nil
+	}
+
+	usedBytes, usedSnapshots, err := sm.getStoreManager().Usage(srv.Name)
+	if err != nil {
+		return err
+	}
+
+	records, err := sm.srvRepository.FindFileSnapshots(srv.ID, filename)
+	if err != nil {
+		return err
+	}
+	var rotatable []Snapshot
+	for _, rec := range *records {
+		if !protected(rec.Name) {
+			rotatable = append(rotatable, rec)
+		}
+	}
+	// records are sorted by name, so the oldest ones come first
+	freedBytes := int64(0)
+	freedSnapshots := 0
+	for i := 0; i < len(rotatable)+1-rotate && i < len(rotatable); i++ {
+		freedBytes += rotatable[i].ByteSize
+		freedSnapshots++
+	}
+
+	if quota.MaxBytes > 0 && usedBytes+size-freedBytes > quota.MaxBytes {
+		log.Default().Printf(
+			"storage quota of source server '%s' exceeded. used: %d, received: %d, quota: %d",
+			srv.Name, usedBytes, size, quota.MaxBytes,
+		)
+		return xerrors.ErrStorageQuotaExceeded
+	}
+	if quota.MaxSnapshots > 0 && usedSnapshots+1-freedSnapshots > quota.MaxSnapshots {
+		log.Default().Printf(
+			"snapshots quota of source server '%s' exceeded. used: %d, quota: %d",
+			srv.Name, usedSnapshots, quota.MaxSnapshots,
+		)
+		return xerrors.ErrSnapshotsQuotaExceeded
+	}
+
+	return nil
+}
+
+func (sm *SrvManager) SetSourceServerQuota(srcSrvId uint, quotaBytes *int64, quotaSnapshots *int) (*SourceServerInfo, error) {
+	srv, err := sm.srvRepository.UpdateSrvQuota(srcSrvId, quotaBytes, quotaSnapshots)
+	if err != nil {
+		return nil, err
+	}
+
+	return sm.sourceServerInfo(srv)
+}
+
+func (sm *SrvManager) sourceServerInfo(srv *SourceServer) (*SourceServerInfo, error) {
+	usedBytes, usedSnapshots, err := sm.getStoreManager().Usage(srv.Name)
+	if err != nil {
+		return nil, err
+	}
+	heartbeat, err := sm.srvRepository.FindHeartbeat(srv.ID)
+	if err != nil && !errors.Is(err, xerrors.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	return &SourceServerInfo{
+		SourceServer:  *srv,
+		UsedBytes:     usedBytes,
+		UsedSnapshots: usedSnapshots,
+		Quota:         sm.effectiveQuota(srv),
+		MaxUpload:     sm.MaxUploadSize(srv),
+		Agent:         sm.agentStatus(heartbeat),
+	}, nil
+}
+
+// MaxUploadSize is the max size of file that source server can upload
+func (sm *SrvManager) MaxUploadSize(srv *SourceServer) int64 {
+	if srv.MaxUploadBytes != nil {
+		return *srv.MaxUploadBytes
+	}
+	return sm.config.DefaultMaxUpload
+}
+
+func (sm *SrvManager) SetSourceServerMaxUpload(srcSrvId uint, maxUploadBytes *int64) (*SourceServerInfo, error) {
+	srv, err := sm.srvRepository.UpdateSrvMaxUpload(srcSrvId, maxUploadBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	return sm.sourceServerInfo(srv)
 }
 
 func (sm *SrvManager) RegisterNewSourceServer(name string) (*newSrvSrcResult, error) {
@@ -199,8 +433,13 @@ func (sm *SrvManager) RegisterNewSourceServer(name string) (*newSrvSrcResult, er
 
 	hashedBytes := sha256.Sum256([]byte(newSrvAPIKey))
 	newSrvHashedAPIKey := hex.EncodeToString(hashedBytes[:])
+	requestSigningKey, err := sm.wrapRequestSigningKey(newSrvAPIKey)
+	if err != nil {
+		log.Default().Println("error in wrapping request signing key for register new server", err.Error())
+		return nil, xerrors.ErrUnhandled
+	}
 
-	newSrvServer, err := sm.srvRepository.CreateNewSrv(name, newSrvHashedAPIKey)
+	newSrvServer, err := sm.srvRepository.CreateNewSrv(name, newSrvHashedAPIKey, requestSigningKey)
 	if err != nil {
 		log.Default().Println("error in creating new source server", err.Error())
 		return nil, xerrors.ErrUnhandled
@@ -212,6 +451,65 @@ func (sm *SrvManager) RegisterNewSourceServer(name string) (*newSrvSrcResult, er
 	}, nil
 }
 
+// RotateSourceServerAPIKey replaces api key of source server. agent of
+// source server is not authorized until it is configured by the new key
+func (sm *SrvManager) RotateSourceServerAPIKey(name string) (*newSrvSrcResult, error) {
+	srv, err := sm.srvRepository.FindSrvWithName(name)
+	if err != nil {
+		return nil, err
+	}
+
+	newAPIKey, err := sm.generateAPIKey()
+	if err != nil {
+		log.Default().Println("error in creating api-key for rotating source server key", err.Error())
+		return nil, xerrors.ErrUnhandled
+	}
+
+	hashedBytes := sha256.Sum256([]byte(newAPIKey))
+	requestSigningKey, err := sm.wrapRequestSigningKey(newAPIKey)
+	if err != nil {
+		log.Default().Println("error in wrapping request signing key for rotating source server key", err.Error())
+		return nil, xerrors.ErrUnhandled
+	}
+	srv, err = sm.srvRepository.UpdateSrvAPIKey(srv.ID, hex.EncodeToString(hashedBytes[:]), requestSigningKey)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Default().Printf("api key of source server '%s' rotated", srv.Name)
+	return &newSrvSrcResult{
+		APIKey:    newAPIKey,
+		NewServer: srv,
+	}, nil
+}
+
+// DeleteSourceServer deletes source server and its records. stored snapshots
+// are removed too when purge is set. source server under legal hold can not
+// be deleted
+func (sm *SrvManager) DeleteSourceServer(name string, purge bool) (*SourceServer, error) {
+	srv, err := sm.srvRepository.FindSrvWithName(name)
+	if err != nil {
+		return nil, err
+	}
+	if srv.LegalHold {
+		return nil, xerrors.ErrSourceServerUnderLegalHold
+	}
+
+	if err := sm.srvRepository.DeleteSrv(srv.ID); err != nil {
+		return nil, err
+	}
+	log.Default().Printf("source server '%s' deleted", srv.Name)
+
+	if purge {
+		if err := sm.getStoreManager().DeleteStore(srv.Name); err != nil {
+			return nil, err
+		}
+		log.Default().Printf("store of source server '%s' removed", srv.Name)
+	}
+
+	return srv, nil
+}
+
 func (sm *SrvManager) AuthorizeSourceServer(srcSrvName string, apiKey string) (*SourceServer, error) {
 	srv, err := sm.srvRepository.FindSrvWithName(srcSrvName)
 	if err != nil {
@@ -236,6 +534,63 @@ func (sm *SrvManager) AuthorizeSourceServer(srcSrvName string, apiKey string) (*
 		return nil, xerrors.ErrUnauthorized
 	}
 
+	// source servers created before signed requests have no request signing
+	// key. it is stored on their first api key request, so their agents can
+	// sign requests after upgrade without rotating api key
+	if srv.RequestSigningKey == "" && sm.config.SigningKey != nil {
+		requestSigningKey, err := sm.wrapRequestSigningKey(apiKey)
+		if err != nil {
+			log.Default().Printf("error in wrapping request signing key of source server '%s', error: %s", srv.Name, err.Error())
+			return srv, nil
+		}
+		if err := sm.srvRepository.UpdateSrvRequestSigningKey(srv.ID, requestSigningKey); err != nil {
+			return srv, nil
+		}
+		srv.RequestSigningKey = requestSigningKey
+		log.Default().Printf("request signing key of source server '%s' is stored", srv.Name)
+	}
+
+	return srv, nil
+}
+
+// AuthorizeSignedRequest verifies signature of agent request. agents sign
+// requests by a key which is derived from their api key, and server keeps it
+// wrapped by its signing key. source servers created before signed requests
+// get the key on their first api key request, or by rotating api key
+func (sm *SrvManager) AuthorizeSignedRequest(srcSrvName string, message []byte, signature string) (*SourceServer, error) {
+	srv, err := sm.srvRepository.FindSrvWithName(srcSrvName)
+	if err != nil {
+		if errors.Is(err, xerrors.ErrRecordNotFound) {
+			log.Default().Printf("source server with name '%s' not exists\n", srcSrvName)
+			return nil, xerrors.ErrUnauthorized
+		}
+
+		log.Default().Printf("[Unhandled] finding source server with name '%s' failed, error: %s", srcSrvName, err.Error())
+		return nil, xerrors.ErrUnauthorized
+	}
+
+	if srv.RequestSigningKey == "" {
+		log.Default().Printf("source server '%s' has no request signing key, it is stored on its first api key request or by rotating api key", srcSrvName)
+		return nil, xerrors.ErrUnauthorized
+	}
+	signingKey, err := sm.unwrapRequestSigningKey(srv.RequestSigningKey)
+	if err != nil {
+		log.Default().Printf("[Unhandled] request signing key of source server '%s' can not be unwrapped, error: %s", srcSrvName, err.Error())
+		return nil, xerrors.ErrUnauthorized
+	}
+	receivedSignature, err := hex.DecodeString(signature)
+	if err != nil {
+		log.Default().Printf("received signature is not valid hex, signature: '%s'", signature)
+		return nil, xerrors.ErrUnauthorized
+	}
+
+	mac := hmac.New(sha256.New, signingKey)
+	mac.Write(message)
+	if !hmac.Equal(mac.Sum(nil), receivedSignature) {
+		log.Default().Printf("received signature is not valid for source server '%s'", srcSrvName)
+		return nil, xerrors.ErrUnauthorized
+	}
+
 	return srv, nil
 }
 
@@ -251,15 +606,25 @@ func (sm *SrvManager) getStoreManager() StoreManager {
 	}
 }
 
-func (sm *SrvManager) RotateFile(srcSrv *SourceServer, rotate int, fileName string, file *multipart.FileHeader) error {
+// RecoverStore cleans up any leftover of interrupted store operations. it
+// should be called on startup, before accepting any upload
+func (sm *SrvManager) RecoverStore() error {
+	storeManager := sm.getStoreManager()
+	if err := storeManager.Recover(); err != nil {
+		log.Default().Printf("error in recovering store, error: %s", err.Error())
+		return err
+	}
+	return nil
+}
+
+func (sm *SrvManager) RotateFile(srcSrv *SourceServer, rotate int, fileName string, checksum string, file *multipart.FileHeader, metadata FileMetadata) error {
 	srvMetrics := NewSrcSrvMetrics()
 	storeManager := sm.getStoreManager()
 	isOperationSuccessful := false
 
 	// in order to monitor operation status
 	defer func() {
-		log.Default().Println("here in count defer ...")
-		status := FailOperation;
+		status := FailOperation
 		if isOperationSuccessful {
 			status = SuccessOperation
 		}
@@ -279,6 +644,22 @@ func (sm *SrvManager) RotateFile(srcSrv *SourceServer, rotate int, fileName stri
 		sm.config.CorrelationId,
 	)
 
+	// prevent concurrent uploads of the same file to interfere each other
+	unlock := lockFile(srcSrv.Name, fnFilename)
+	defer unlock()
+
+	if maxUpload := sm.MaxUploadSize(srcSrv); maxUpload > 0 && file.Size > maxUpload {
+		log.Default().Printf(
+			"error in file store, source server name: '%s' correlationId: '%s', size: %d, max upload: %d, error: %s",
+			srcSrv.Name,
+			sm.config.CorrelationId,
+			file.Size,
+			maxUpload,
+			xerrors.ErrUploadTooLarge.Error(),
+		)
+		return xerrors.ErrUploadTooLarge
+	}
+
 	if rotate > GlobalFileRotateLimit {
 		log.Default().Printf(
 			"error in file store, source server name: '%s' correlationId: '%s', error: %s",
@@ -300,7 +681,23 @@ func (sm *SrvManager) RotateFile(srcSrv *SourceServer, rotate int, fileName stri
 		return err
 	}
 
-	err = storeManager.FileStore(srcSrv.Name, fnFilename, file, sm.config.CorrelationId)
+	protected, err := sm.rotationProtection(srcSrv, fnFilename)
+	if err != nil {
+		return err
+	}
+
+	err = sm.checkQuota(srcSrv, fnFilename, rotate, file.Size, protected)
+	if err != nil {
+		log.Default().Printf(
+			"error in file store, source server name: '%s' correlationId: '%s', error: %s",
+			srcSrv.Name,
+			sm.config.CorrelationId,
+			err.Error(),
+		)
+		return err
+	}
+
+	stored, err := storeManager.FileStore(srcSrv.Name, fnFilename, file, checksum, sm.config.CorrelationId)
 	if err != nil {
 		log.Default().Printf(
 			"error in file store, source server name: '%s' correlationId: '%s', error: %s",
@@ -311,7 +708,38 @@ func (sm *SrvManager) RotateFile(srcSrv *SourceServer, rotate int, fileName stri
 		return err
 	}
 
-	err = storeManager.FileRotate(srcSrv.Name, fnFilename, rotate, sm.config.CorrelationId)
+	snapshot, err := sm.recordSnapshot(srcSrv.ID, fnFilename, stored, metadata)
+	if err != nil {
+		log.Default().Printf(
+			"error in recording snapshot, source server name: '%s' correlationId: '%s', error: %s",
+			srcSrv.Name,
+			sm.config.CorrelationId,
+			err.Error(),
+		)
+		return err
+	}
+
+	// snapshot is stored anyway, it is only not found by full text search
+	// until index is rebuilt
+	if err := sm.indexSnapshot(srcSrv.Name, snapshot); err != nil {
+		log.Default().Printf(
+			"error in indexing snapshot, source server name: '%s' correlationId: '%s', error: %s",
+			srcSrv.Name,
+			sm.config.CorrelationId,
+			err.Error(),
+		)
+	}
+
+	deleted, err := storeManager.FileRotate(srcSrv.Name, fnFilename, rotate, protected, sm.config.CorrelationId)
+	// records of deleted snapshots should be removed, even if rotation failed in the middle
+	if dErr := sm.srvRepository.DeleteSnapshots(srcSrv.ID, fnFilename, deleted); dErr != nil {
+		log.Default().Printf(
+			"error in deleting rotated snapshot records, source server name: '%s' correlationId: '%s', error: %s",
+			srcSrv.Name,
+			sm.config.CorrelationId,
+			dErr.Error(),
+		)
+	}
 	if err != nil {
 		log.Default().Printf(
 			"error in file rotate, source server name: '%s' correlationId: '%s', error: %s",
@@ -424,6 +852,35 @@ func (sm *SrvManager) GetListOfFileSnapshotsByFilenameAndSrvId(srcSrvId uint, fi
 		return nil, 0, xerrors.ErrUnhandled
 	}
 
+	// recorded checksum is the one received on upload, so it is preferred over
+	// the one calculated from the current content on disk
+	records, err := sm.srvRepository.FindFileSnapshots(srv.ID, filename)
+	if err != nil {
+		return nil, 0, err
+	}
+	recordsByName := map[string]Snapshot{}
+	for _, rec := range *records {
+		recordsByName[rec.Name] = rec
+	}
+	for i := range snapshots {
+		snapshots[i].LegalHold = srv.LegalHold
+		rec, exists := recordsByName[snapshots[i].Name]
+		if !exists {
+			continue
+		}
+		metadata := rec.FileMetadata
+		snapshots[i].Metadata = &metadata
+		snapshots[i].Pinned = rec.Pinned
+		snapshots[i].PinNote = rec.PinNote
+		snapshots[i].PinnedAt = rec.PinnedAt
+		if rec.Checksum != snapshots[i].Checksum {
+			snapshots[i].Corrupted = true
+		}
+		snapshots[i].Checksum = rec.Checksum
+		snapshots[i].Corrupted = snapshots[i].Corrupted || rec.Corrupted
+		snapshots[i].VerifiedAt = rec.VerifiedAt
+	}
+
 	switch options.SortBy {
 	case "name":
 		sort.Slice(snapshots, func(i, j int) bool {
@@ -500,3 +957,887 @@ func (sm *SrvManager) ReadSnapshot(srcSrvId uint, filename, snapshot string) (*[
 
 	return snapshotByte, finalName, nil
 }
+
+// FindRestoreSnapshot selects snapshot of file which agent restores. snapshot
+// is selected by its name, or it is the last snapshot taken at or before
+// received time, or it is the last snapshot of file. checksum of selected
+// snapshot is the recorded one on upload
+func (sm *SrvManager) FindRestoreSnapshot(srcSrv *SourceServer, filename, snapshot string, at *time.Time) (*SnapshotList, error) {
+	if filename != path.Base(filename) || filename == "." || filename == ".." {
+		return nil, xerrors.ErrNoFileStoredOnSourceServerByThisName
+	}
+
+	storeManager := sm.getStoreManager()
+	snapshots, err := storeManager.SnapshotsList(srcSrv.Name, filename)
+	if err != nil {
+		log.Default().Printf("error in finding file snapshots for source server '%s' with filename '%s', error: %s", srcSrv.Name, filename, err)
+		return nil, err
+	}
+
+	records, err := sm.srvRepository.FindFileSnapshots(srcSrv.ID, filename)
+	if err != nil {
+		return nil, err
+	}
+	recordsByName := map[string]Snapshot{}
+	for _, rec := range *records {
+		recordsByName[rec.Name] = rec
+	}
+
+	var selected *SnapshotList
+	for i := range snapshots {
+		snp := &snapshots[i]
+		if rec, exists := recordsByName[snp.Name]; exists {
+			snp.Corrupted = rec.Corrupted || rec.Checksum != snp.Checksum
+			snp.Checksum = rec.Checksum
+			snp.CreatedAt = rec.CreatedAt
+			metadata := rec.FileMetadata
+			snp.Metadata = &metadata
+		}
+
+		if snapshot != "" {
+			if snp.Name == snapshot {
+				selected = snp
+				break
+			}
+			continue
+		}
+		if at != nil && snp.CreatedAt.After(*at) {
+			continue
+		}
+		// snapshots are sorted by name which starts with their creation time
+		selected = snp
+	}
+
+	if selected == nil {
+		log.Default().Printf(
+			"no snapshot to restore found for source server '%s' with filename '%s'. snapshot: '%s', at: %v",
+			srcSrv.Name, filename, snapshot, at,
+		)
+		return nil, xerrors.ErrSnapshotNotFound
+	}
+	return selected, nil
+}
+
+// rotationProtection reports which snapshots of file should be kept by
+// rotation. pinned snapshots and all snapshots of a source server under legal
+// hold are protected
+func (sm *SrvManager) rotationProtection(srcSrv *SourceServer, filename string) (func(snapshot string) bool, error) {
+	if srcSrv.LegalHold {
+		log.Default().Printf(
+			"source server '%s' is under legal hold, rotation of '%s' is suspended. correlationId: '%s'",
+			srcSrv.Name,
+			filename,
+			sm.config.CorrelationId,
+		)
+		return func(string) bool { return true }, nil
+	}
+
+	records, err := sm.srvRepository.FindFileSnapshots(srcSrv.ID, filename)
+	if err != nil {
+		return nil, err
+	}
+	pinned := map[string]bool{}
+	for _, rec := range *records {
+		if rec.Pinned {
+			pinned[rec.Name] = true
+		}
+	}
+
+	return func(snapshot string) bool { return pinned[snapshot] }, nil
+}
+
+func (sm *SrvManager) PinSnapshot(srcSrvId uint, filename, snapshot, note string, userId uint) (*Snapshot, error) {
+	return sm.setSnapshotPin(srcSrvId, filename, snapshot, true, note, &userId)
+}
+
+func (sm *SrvManager) UnpinSnapshot(srcSrvId uint, filename, snapshot string) (*Snapshot, error) {
+	return sm.setSnapshotPin(srcSrvId, filename, snapshot, false, "", nil)
+}
+
+func (sm *SrvManager) setSnapshotPin(srcSrvId uint, filename, snapshot string, pinned bool, note string, userId *uint) (*Snapshot, error) {
+	srv, err := sm.srvRepository.FindSrvWithId(srcSrvId)
+	if err != nil {
+		return nil, err
+	}
+
+	// pin should not race with a rotation of the same file
+	unlock := lockFile(srv.Name, filename)
+	defer unlock()
+
+	rec, err := sm.srvRepository.FindSnapshot(srv.ID, filename, snapshot)
+	if err != nil {
+		if errors.Is(err, xerrors.ErrRecordNotFound) {
+			return nil, xerrors.ErrSnapshotNotFound
+		}
+		return nil, err
+	}
+
+	if err := sm.srvRepository.UpdateSnapshotPin(rec.ID, pinned, note, userId); err != nil {
+		return nil, err
+	}
+
+	log.Default().Printf(
+		"snapshot '%s' of filename '%s' for source server '%s' pinned: %t, note: '%s'",
+		snapshot,
+		filename,
+		srv.Name,
+		pinned,
+		note,
+	)
+
+	return sm.srvRepository.FindSnapshot(srv.ID, filename, snapshot)
+}
+
+func (sm *SrvManager) PlaceLegalHold(srcSrvId uint, note string) (*SourceServer, error) {
+	srv, err := sm.srvRepository.UpdateSrvLegalHold(srcSrvId, true, note)
+	if err != nil {
+		return nil, err
+	}
+	log.Default().Printf("legal hold placed on source server '%s', note: '%s'", srv.Name, note)
+	return srv, nil
+}
+
+func (sm *SrvManager) ReleaseLegalHold(srcSrvId uint) (*SourceServer, error) {
+	srv, err := sm.srvRepository.UpdateSrvLegalHold(srcSrvId, false, "")
+	if err != nil {
+		return nil, err
+	}
+	log.Default().Printf("legal hold released from source server '%s'", srv.Name)
+	return srv, nil
+}
+
+// recordSnapshot stores record of a new snapshot, chained to the previous
+// record of the same file. caller should hold the file lock
+func (sm *SrvManager) recordSnapshot(srcSrvId uint, filename string, stored *StoredSnapshot, metadata FileMetadata) (*Snapshot, error) {
+	prev, err := sm.srvRepository.FindLastFileSnapshot(srcSrvId, filename)
+	if err != nil && !errors.Is(err, xerrors.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	snapshot := Snapshot{
+		SourceServerID: srcSrvId,
+		Filename:       filename,
+		Name:           stored.Name,
+		Checksum:       stored.Checksum,
+		ByteSize:       stored.ByteSize,
+		FileMetadata:   metadata,
+	}
+	sealSnapshot(&snapshot, prev, sm.config.SigningKey)
+
+	if err := sm.srvRepository.CreateSnapshot(&snapshot); err != nil {
+		return nil, err
+	}
+	return &snapshot, nil
+}
+
+// ExportFileManifest creates a signed manifest of the whole history of a file
+// that can be verified offline
+func (sm *SrvManager) ExportFileManifest(srcSrvId uint, filename string) (*Manifest, error) {
+	if sm.config.SigningKey == nil {
+		log.Default().Println("no signing key is configured to sign manifest")
+		return nil, xerrors.ErrUnhandled
+	}
+
+	srv, err := sm.srvRepository.FindSrvWithId(srcSrvId)
+	if err != nil {
+		return nil, err
+	}
+
+	history, err := sm.srvRepository.FindFileSnapshotsHistory(srv.ID, filename)
+	if err != nil {
+		return nil, err
+	}
+	if len(*history) == 0 {
+		return nil, xerrors.ErrNoFileStoredOnSourceServerByThisName
+	}
+
+	manifest := Manifest{
+		SourceServerID: srv.ID,
+		SourceServer:   srv.Name,
+		Filename:       filename,
+		PublicKey:      base64.StdEncoding.EncodeToString(sm.config.SigningKey.Public().(ed25519.PublicKey)),
+		Entries:        []ManifestEntry{},
+		GeneratedAt:    time.Now().UTC().Truncate(time.Millisecond),
+	}
+	for _, rec := range *history {
+		manifest.Entries = append(manifest.Entries, ManifestEntry{
+			Name:       rec.Name,
+			Checksum:   rec.Checksum,
+			ByteSize:   rec.ByteSize,
+			CreatedAt:  rec.CreatedAt.UTC(),
+			Rotated:    rec.DeletedAt.Valid,
+			PrevHash:   rec.PrevHash,
+			RecordHash: rec.RecordHash,
+			Signature:  rec.Signature,
+		})
+		manifest.Head = rec.RecordHash
+	}
+	manifest.Signature = base64.StdEncoding.EncodeToString(ed25519.Sign(sm.config.SigningKey, manifestPayload(&manifest)))
+
+	return &manifest, nil
+}
+
+// VerifySnapshots re-hashes every stored snapshot and compares it with the
+// checksum recorded on upload. snapshots which have no record (stored before
+// checksums were recorded) are adopted by recording their current checksum
+func (sm *SrvManager) VerifySnapshots() (*IntegrityReport, error) {
+	report := IntegrityReport{
+		StartedAt:  time.Now(),
+		Mismatches: []IntegrityMismatch{},
+	}
+
+	sourceServers, err := sm.srvRepository.AllSourceServers()
+	if err != nil {
+		return nil, err
+	}
+
+	storeManager := sm.getStoreManager()
+	for _, ss := range *sourceServers {
+		records, err := sm.srvRepository.FindSrvSnapshots(ss.ID)
+		if err != nil {
+			return nil, err
+		}
+
+		filesList, err := storeManager.FilesList(ss.Name)
+		if err != nil && !errors.Is(err, xerrors.ErrNoStoreForSourceServer) {
+			log.Default().Printf("error in getting filesList for server %s, error: %+v", ss.Name, err)
+			continue
+		}
+
+		// files which only have records are checked too, to find missing snapshots
+		filenames := []string{}
+		seen := map[string]bool{}
+		for _, fl := range filesList {
+			if !seen[fl.FileName] {
+				seen[fl.FileName] = true
+				filenames = append(filenames, fl.FileName)
+			}
+		}
+		for _, rec := range *records {
+			if !seen[rec.Filename] {
+				seen[rec.Filename] = true
+				filenames = append(filenames, rec.Filename)
+			}
+		}
+
+		for _, filename := range filenames {
+			sm.verifyFileSnapshots(storeManager, &ss, filename, &report)
+		}
+	}
+
+	report.FinishedAt = time.Now()
+	return &report, nil
+}
+
+// verifyFileSnapshots compares snapshots of a file on disk with its records.
+// both are read under the file lock, so an upload or rotation running beside
+// the scan is not taken as a snapshot to adopt or a missing one
+func (sm *SrvManager) verifyFileSnapshots(storeManager StoreManager, ss *SourceServer, filename string, report *IntegrityReport) {
+	unlock := lockFile(ss.Name, filename)
+	defer unlock()
+
+	records, err := sm.srvRepository.FindFileSnapshots(ss.ID, filename)
+	if err != nil {
+		report.Failed++
+		return
+	}
+	recordsByName := map[string]Snapshot{}
+	for _, rec := range *records {
+		recordsByName[rec.Name] = rec
+	}
+
+	snapshots, err := storeManager.SnapshotsList(ss.Name, filename)
+	if err != nil && !errors.Is(err, xerrors.ErrNoStoreForSourceServer) && !errors.Is(err, xerrors.ErrNoFileStoredOnSourceServerByThisName) {
+		log.Default().Printf("error in getting snapshots for server '%s' on filename '%s', error: %+v", ss.Name, filename, err)
+		report.Failed++
+		return
+	}
+
+	updateVerification := func(rec *Snapshot, corrupted bool) {
+		if err := sm.srvRepository.UpdateSnapshotVerification(rec.ID, corrupted, time.Now()); err != nil {
+			log.Default().Printf(
+				"error in recording verification of snapshot '%s' for server '%s' on filename '%s', error: %+v",
+				rec.Name, ss.Name, filename, err,
+			)
+			report.Failed++
+		}
+	}
+
+	for _, snp := range snapshots {
+		rec, exists := recordsByName[snp.Name]
+		delete(recordsByName, snp.Name)
+
+		if !exists {
+			_, err := sm.recordSnapshot(ss.ID, filename, &StoredSnapshot{
+				Name:     snp.Name,
+				Checksum: snp.Checksum,
+				ByteSize: snp.ByteSize,
+			}, FileMetadata{})
+			if err != nil {
+				log.Default().Printf("error in adopting snapshot '%s' for server '%s' on filename '%s', error: %+v", snp.Name, ss.Name, filename, err)
+				report.Failed++
+				continue
+			}
+			report.Adopted++
+			continue
+		}
+
+		report.Checked++
+		// records created before hash chain existence have no record hash
+		if rec.RecordHash != "" && rec.RecordHash != recordHash(rec.SourceServerID, rec.Filename, rec.Name, rec.Checksum, rec.ByteSize, rec.CreatedAt, rec.PrevHash) {
+			log.Default().Printf(
+				"integrity mismatch for snapshot '%s' of server '%s' on filename '%s', record is modified",
+				snp.Name, ss.Name, filename,
+			)
+			report.Mismatches = append(report.Mismatches, IntegrityMismatch{
+				SourceServer: ss.Name,
+				Filename:     filename,
+				Snapshot:     snp.Name,
+				Expected:     rec.RecordHash,
+				Reason:       "snapshot record is modified",
+			})
+			updateVerification(&rec, true)
+			continue
+		}
+
+		corrupted := snp.Checksum != rec.Checksum
+		if corrupted {
+			log.Default().Printf(
+				"integrity mismatch for snapshot '%s' of server '%s' on filename '%s', expected: '%s', actual: '%s'",
+				snp.Name, ss.Name, filename, rec.Checksum, snp.Checksum,
+			)
+			report.Mismatches = append(report.Mismatches, IntegrityMismatch{
+				SourceServer: ss.Name,
+				Filename:     filename,
+				Snapshot:     snp.Name,
+				Expected:     rec.Checksum,
+				Actual:       snp.Checksum,
+				Reason:       "checksum mismatch",
+			})
+		}
+		updateVerification(&rec, corrupted)
+	}
+
+	// remaining records have no snapshot on disk anymore
+	for _, rec := range recordsByName {
+		report.Checked++
+		log.Default().Printf(
+			"integrity mismatch for snapshot '%s' of server '%s' on filename '%s', snapshot is missing",
+			rec.Name, ss.Name, filename,
+		)
+		report.Mismatches = append(report.Mismatches, IntegrityMismatch{
+			SourceServer: ss.Name,
+			Filename:     filename,
+			Snapshot:     rec.Name,
+			Expected:     rec.Checksum,
+			Reason:       "snapshot is missing",
+		})
+		updateVerification(&rec, true)
+	}
+}
+
+func (sm *SrvManager) GetListOfCorruptedSnapshots() (*[]IntegrityMismatch, error) {
+	records, err := sm.srvRepository.FindCorruptedSnapshots()
+	if err != nil {
+		return nil, err
+	}
+
+	sourceServers, err := sm.srvRepository.AllSourceServers()
+	if err != nil {
+		return nil, err
+	}
+	srvNames := map[uint]string{}
+	for _, ss := range *sourceServers {
+		srvNames[ss.ID] = ss.Name
+	}
+
+	mismatches := []IntegrityMismatch{}
+	for _, rec := range *records {
+		mismatches = append(mismatches, IntegrityMismatch{
+			SourceServer: srvNames[rec.SourceServerID],
+			Filename:     rec.Filename,
+			Snapshot:     rec.Name,
+			Expected:     rec.Checksum,
+			Reason:       "checksum mismatch or missing snapshot",
+		})
+	}
+
+	return &mismatches, nil
+}
+
+// CreateRestoreJob queues restoring snapshot of file on its source server. the
+// job is picked up by agent of source server on its next poll
+func (sm *SrvManager) CreateRestoreJob(srcSrvId uint, filename, snapshot string, userId uint) (*RestoreJob, error) {
+	srv, err := sm.srvRepository.FindSrvWithId(srcSrvId)
+	if err != nil {
+		if errors.Is(err, xerrors.ErrRecordNotFound) {
+			log.Default().Printf("source server with ID '%d' not exists\n", srcSrvId)
+			return nil, xerrors.ErrRecordNotFound
+		}
+
+		log.Default().Printf("[Unhandled] finding source server with ID '%d' failed, error: %s", srcSrvId, err.Error())
+		return nil, xerrors.ErrUnhandled
+	}
+
+	if _, err := sm.FindRestoreSnapshot(srv, filename, snapshot, nil); err != nil {
+		return nil, err
+	}
+
+	job := RestoreJob{
+		SourceServerID: srv.ID,
+		Filename:       filename,
+		Snapshot:       snapshot,
+		Status:         RestoreJobPending,
+		RequestedBy:    userId,
+	}
+	if err := sm.srvRepository.CreateRestoreJob(&job); err != nil {
+		return nil, err
+	}
+
+	log.Default().Printf(
+		"restore job '%d' of snapshot '%s' of file '%s' queued for source server '%s' by user '%d'. correlationId: '%s'",
+		job.ID, snapshot, filename, srv.Name, userId, sm.config.CorrelationId,
+	)
+	return &job, nil
+}
+
+func (sm *SrvManager) GetListOfRestoreJobs(srcSrvId uint, option FindAllOption) (*[]RestoreJob, int64, error) {
+	if _, err := sm.srvRepository.FindSrvWithId(srcSrvId); err != nil {
+		if errors.Is(err, xerrors.ErrRecordNotFound) {
+			log.Default().Printf("source server with ID '%d' not exists\n", srcSrvId)
+			return nil, 0, xerrors.ErrRecordNotFound
+		}
+
+		log.Default().Printf("[Unhandled] finding source server with ID '%d' failed, error: %s", srcSrvId, err.Error())
+		return nil, 0, xerrors.ErrUnhandled
+	}
+
+	return sm.srvRepository.FindSrvRestoreJobs(srcSrvId, option)
+}
+
+// NextRestoreJob claims the oldest pending restore job of source server. nil
+// is returned when there is no pending job
+func (sm *SrvManager) NextRestoreJob(srcSrv *SourceServer) (*RestoreJob, error) {
+	job, err := sm.srvRepository.ClaimRestoreJob(srcSrv.ID)
+	if err != nil {
+		if errors.Is(err, xerrors.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	log.Default().Printf(
+		"restore job '%d' claimed by source server '%s'. correlationId: '%s'",
+		job.ID, srcSrv.Name, sm.config.CorrelationId,
+	)
+	return job, nil
+}
+
+// ReportRestoreJob records result of a restore job which is reported by agent
+func (sm *SrvManager) ReportRestoreJob(srcSrv *SourceServer, jobId uint, succeeded bool, message string) (*RestoreJob, error) {
+	job, err := sm.srvRepository.FindRestoreJob(jobId, srcSrv.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	status := RestoreJobFailed
+	if succeeded {
+		status = RestoreJobSucceeded
+	}
+	if err := sm.srvRepository.FinishRestoreJob(job.ID, status, message); err != nil {
+		return nil, err
+	}
+
+	log.Default().Printf(
+		"restore job '%d' of source server '%s' finished with status '%s', message: '%s'. correlationId: '%s'",
+		job.ID, srcSrv.Name, status, message, sm.config.CorrelationId,
+	)
+	return sm.srvRepository.FindRestoreJob(job.ID, srcSrv.ID)
+}
+
+func managedFileKey(file ManagedFile) string {
+	if file.Filename != "" {
+		return file.Filename
+	}
+	return path.Base(file.Path)
+}
+
+func validateManagedFiles(files []ManagedFile) error {
+	keys := map[string]bool{}
+	for _, file := range files {
+		if !path.IsAbs(file.Path) {
+			return fmt.Errorf("%w: every paths should be absolute. invalid path: %s", xerrors.ErrInvalidManagedFiles, file.Path)
+		}
+		if _, err := cron.ParseStandard(file.Interval); err != nil {
+			return fmt.Errorf("%w: interval of '%s' is invalid: %s", xerrors.ErrInvalidManagedFiles, file.Path, err.Error())
+		}
+		if file.Rotate < 1 {
+			return fmt.Errorf("%w: rotate of '%s' should be bigger than 0", xerrors.ErrInvalidManagedFiles, file.Path)
+		}
+		key := managedFileKey(file)
+		if keys[key] {
+			return fmt.Errorf("%w: '%s' is a duplicate filename", xerrors.ErrInvalidManagedFiles, key)
+		}
+		keys[key] = true
+	}
+	return nil
+}
+
+// effectiveManagedFiles returns files of group overridden by files of source
+// server, and a revision which changes on every change of them
+func effectiveManagedFiles(groupFiles, srvFiles []ManagedFile) ([]ManagedFile, string) {
+	files := []ManagedFile{}
+	indexes := map[string]int{}
+	for _, file := range append(append([]ManagedFile{}, groupFiles...), srvFiles...) {
+		key := managedFileKey(file)
+		if i, exists := indexes[key]; exists {
+			files[i] = file
+			continue
+		}
+		indexes[key] = len(files)
+		files = append(files, file)
+	}
+
+	filesByte, _ := json.Marshal(files)
+	hash := sha256.Sum256(filesByte)
+	return files, hex.EncodeToString(hash[:])[:12]
+}
+
+func (sm *SrvManager) findManagedFiles(scope, scopeKey string) ([]ManagedFile, error) {
+	managedConfig, err := sm.srvRepository.FindManagedConfig(scope, scopeKey)
+	if err != nil {
+		if errors.Is(err, xerrors.ErrRecordNotFound) {
+			return []ManagedFile{}, nil
+		}
+		return nil, err
+	}
+	return managedConfig.ManagedFiles(), nil
+}
+
+func (sm *SrvManager) managedConfigInfo(srv *SourceServer) (*ManagedConfigInfo, error) {
+	srvFiles, err := sm.findManagedFiles(ManagedConfigServerScope, strconv.FormatUint(uint64(srv.ID), 10))
+	if err != nil {
+		return nil, err
+	}
+	groupFiles := []ManagedFile{}
+	if srv.Group != "" {
+		groupFiles, err = sm.findManagedFiles(ManagedConfigGroupScope, srv.Group)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	effective, revision := effectiveManagedFiles(groupFiles, srvFiles)
+	return &ManagedConfigInfo{
+		Group:           srv.Group,
+		Files:           srvFiles,
+		GroupFiles:      groupFiles,
+		EffectiveFiles:  effective,
+		Revision:        revision,
+		AppliedRevision: srv.ConfigRevision,
+		AppliedSeenAt:   srv.ConfigSeenAt,
+	}, nil
+}
+
+func (sm *SrvManager) GetManagedConfig(srcSrvId uint) (*ManagedConfigInfo, error) {
+	srv, err := sm.srvRepository.FindSrvWithId(srcSrvId)
+	if err != nil {
+		return nil, err
+	}
+	return sm.managedConfigInfo(srv)
+}
+
+func (sm *SrvManager) SetManagedFiles(srcSrvId uint, files []ManagedFile, userId uint) (*ManagedConfigInfo, error) {
+	srv, err := sm.srvRepository.FindSrvWithId(srcSrvId)
+	if err != nil {
+		return nil, err
+	}
+	if err := sm.saveManagedFiles(ManagedConfigServerScope, strconv.FormatUint(uint64(srv.ID), 10), files, userId); err != nil {
+		return nil, err
+	}
+	return sm.managedConfigInfo(srv)
+}
+
+func (sm *SrvManager) GetGroupManagedFiles(group string) ([]ManagedFile, error) {
+	return sm.findManagedFiles(ManagedConfigGroupScope, group)
+}
+
+func (sm *SrvManager) SetGroupManagedFiles(group string, files []ManagedFile, userId uint) ([]ManagedFile, error) {
+	if err := sm.saveManagedFiles(ManagedConfigGroupScope, group, files, userId); err != nil {
+		return nil, err
+	}
+	return sm.findManagedFiles(ManagedConfigGroupScope, group)
+}
+
+func (sm *SrvManager) saveManagedFiles(scope, scopeKey string, files []ManagedFile, userId uint) error {
+	if err := validateManagedFiles(files); err != nil {
+		return err
+	}
+
+	filesByte, err := json.Marshal(files)
+	if err != nil {
+		log.Default().Printf("[Unhandled] error in encoding managed files, error: %s", err.Error())
+		return xerrors.ErrUnhandled
+	}
+
+	log.Default().Printf(
+		"managed files of %s '%s' updated by user '%d'. correlationId: '%s'",
+		scope, scopeKey, userId, sm.config.CorrelationId,
+	)
+	return sm.srvRepository.SaveManagedConfig(&ManagedConfig{
+		Scope:     scope,
+		ScopeKey:  scopeKey,
+		Files:     string(filesByte),
+		UpdatedBy: userId,
+	})
+}
+
+func (sm *SrvManager) SetSourceServerGroup(srcSrvId uint, group string) (*ManagedConfigInfo, error) {
+	srv, err := sm.srvRepository.UpdateSrvGroup(srcSrvId, group)
+	if err != nil {
+		return nil, err
+	}
+	return sm.managedConfigInfo(srv)
+}
+
+// AgentManagedConfig returns effective managed files of source server to its
+// agent. revision which agent currently runs is recorded
+func (sm *SrvManager) AgentManagedConfig(srcSrv *SourceServer, appliedRevision string) (*ManagedConfigInfo, error) {
+	if err := sm.srvRepository.UpdateSrvConfigRevision(srcSrv.ID, appliedRevision); err != nil {
+		return nil, err
+	}
+	return sm.managedConfigInfo(srcSrv)
+}
+
+func (sm *SrvManager) agentOfflineAfter() time.Duration {
+	if sm.config.AgentOfflineAfter == 0 {
+		return defaultAgentOfflineAfter
+	}
+	return sm.config.AgentOfflineAfter
+}
+
+// agentStatus summarizes heartbeat of agent. agents without any heartbeat
+// are offline
+func (sm *SrvManager) agentStatus(heartbeat *Heartbeat) AgentStatus {
+	if heartbeat == nil {
+		return AgentStatus{}
+	}
+
+	receivedAt := heartbeat.ReceivedAt
+	return AgentStatus{
+		Online:           time.Since(receivedAt) <= sm.agentOfflineAfter(),
+		Flagged:          heartbeat.FailingFiles > 0 || heartbeat.UnreachableFiles > 0,
+		LastSeenAt:       &receivedAt,
+		Version:          heartbeat.Version,
+		Hostname:         heartbeat.Hostname,
+		FailingFiles:     heartbeat.FailingFiles,
+		UnreachableFiles: heartbeat.UnreachableFiles,
+	}
+}
+
+// RecordHeartbeat replaces the last heartbeat of source server. files with
+// last upload failed or not reachable by agent are counted, so they can be
+// flagged in servers list
+func (sm *SrvManager) RecordHeartbeat(srcSrv *SourceServer, heartbeat Heartbeat, files []HeartbeatFile) error {
+	heartbeat.SourceServerID = srcSrv.ID
+	heartbeat.ReceivedAt = time.Now()
+	heartbeat.FailingFiles = 0
+	heartbeat.UnreachableFiles = 0
+	for _, file := range files {
+		if file.LastError != "" {
+			heartbeat.FailingFiles++
+		}
+		if !file.Reachable {
+			heartbeat.UnreachableFiles++
+		}
+	}
+
+	filesBytes, err := json.Marshal(files)
+	if err != nil {
+		log.Default().Printf("[Unhandled] error in encoding heartbeat files of source server '%s', error: %s", srcSrv.Name, err.Error())
+		return xerrors.ErrUnhandled
+	}
+	heartbeat.Files = string(filesBytes)
+
+	return sm.srvRepository.SaveHeartbeat(&heartbeat)
+}
+
+func (sm *SrvManager) GetHeartbeat(srcSrvId uint) (*HeartbeatInfo, error) {
+	if _, err := sm.srvRepository.FindSrvWithId(srcSrvId); err != nil {
+		if errors.Is(err, xerrors.ErrRecordNotFound) {
+			log.Default().Printf("source server with ID '%d' not exists\n", srcSrvId)
+			return nil, xerrors.ErrRecordNotFound
+		}
+
+		log.Default().Printf("[Unhandled] finding source server with ID '%d' failed, error: %s", srcSrvId, err.Error())
+		return nil, xerrors.ErrUnhandled
+	}
+
+	heartbeat, err := sm.srvRepository.FindHeartbeat(srcSrvId)
+	if err != nil {
+		return nil, err
+	}
+
+	status := sm.agentStatus(heartbeat)
+	return &HeartbeatInfo{
+		Heartbeat: *heartbeat,
+		Online:    status.Online,
+		Flagged:   status.Flagged,
+		Files:     heartbeat.HeartbeatFiles(),
+	}, nil
+}
+
+type newEnrollmentTokenResult struct {
+	EnrollmentToken *EnrollmentToken
+	Token           string
+}
+
+// CreateEnrollmentToken creates a token which agents can enroll by up to
+// maxUses times until it expires. only hash of token is stored, so it is
+// returned just once
+func (sm *SrvManager) CreateEnrollmentToken(note, group string, maxUses int, ttl time.Duration, createdBy uint) (*newEnrollmentTokenResult, error) {
+	plainToken, err := sm.generateAPIKey()
+	if err != nil {
+		log.Default().Println("error in creating enrollment token", err.Error())
+		return nil, xerrors.ErrUnhandled
+	}
+
+	hashedBytes := sha256.Sum256([]byte(plainToken))
+	token := EnrollmentToken{
+		Note:        note,
+		HashedToken: hex.EncodeToString(hashedBytes[:]),
+		Group:       group,
+		MaxUses:     maxUses,
+		ExpiresAt:   time.Now().Add(ttl),
+		CreatedBy:   createdBy,
+	}
+	if err := sm.srvRepository.CreateEnrollmentToken(&token); err != nil {
+		return nil, err
+	}
+
+	log.Default().Printf("enrollment token '%d' created by user '%d' for group '%s'\n", token.ID, createdBy, group)
+	return &newEnrollmentTokenResult{
+		EnrollmentToken: &token,
+		Token:           plainToken,
+	}, nil
+}
+
+func (sm *SrvManager) GetListOfEnrollmentTokens(option FindAllOption) (*[]EnrollmentToken, int64, error) {
+	return sm.srvRepository.FindEnrollmentTokens(option)
+}
+
+func (sm *SrvManager) RevokeEnrollmentToken(tokenId uint) (*EnrollmentToken, error) {
+	token, err := sm.srvRepository.RevokeEnrollmentToken(tokenId)
+	if err != nil {
+		if errors.Is(err, xerrors.ErrRecordNotFound) {
+			log.Default().Printf("enrollment token with ID '%d' not exists\n", tokenId)
+		}
+		return nil, err
+	}
+
+	log.Default().Printf("enrollment token '%d' revoked\n", tokenId)
+	return token, nil
+}
+
+// EnrollSourceServer registers a new source server by an enrollment token,
+// the same as RegisterNewSourceServer
+func (sm *SrvManager) EnrollSourceServer(token, name string) (*newSrvSrcResult, error) {
+	newSrvAPIKey, err := sm.generateAPIKey()
+	if err != nil {
+		log.Default().Println("error in creating api-key for enroll new server", err.Error())
+		return nil, xerrors.ErrUnhandled
+	}
+
+	requestSigningKey, err := sm.wrapRequestSigningKey(newSrvAPIKey)
+	if err != nil {
+		log.Default().Println("error in wrapping request signing key for enroll new server", err.Error())
+		return nil, xerrors.ErrUnhandled
+	}
+
+	hashedTokenBytes := sha256.Sum256([]byte(token))
+	hashedAPIKeyBytes := sha256.Sum256([]byte(newSrvAPIKey))
+	newSrvServer, err := sm.srvRepository.EnrollSrv(
+		hex.EncodeToString(hashedTokenBytes[:]),
+		name,
+		hex.EncodeToString(hashedAPIKeyBytes[:]),
+		requestSigningKey,
+	)
+	if err != nil {
+		if errors.Is(err, xerrors.ErrInvalidEnrollmentToken) {
+			log.Default().Printf("enrollment of source server '%s' rejected, token is not usable\n", name)
+		}
+		if errors.Is(err, xerrors.ErrSourceServerWithThisNameExists) {
+			log.Default().Printf("source server with following name exists! name: '%s'\n", name)
+		}
+		return nil, err
+	}
+
+	log.Default().Printf("source server '%s' enrolled in group '%s'\n", newSrvServer.Name, newSrvServer.Group)
+	return &newSrvSrcResult{
+		APIKey:    newSrvAPIKey,
+		NewServer: newSrvServer,
+	}, nil
+}
+
+// AuthorizeSourceServerCert finds source server of a verified client
+// certificate by its names, which are common name and dns names of it.
+// certificate should be issued for the same source server and not revoked
+func (sm *SrvManager) AuthorizeSourceServerCert(names []string, serial string) (*SourceServer, error) {
+	cert, err := sm.srvRepository.FindAgentCertificate(serial)
+	if err != nil {
+		log.Default().Printf("agent certificate '%s' is not issued by archivo, error: %s\n", serial, err.Error())
+		return nil, xerrors.ErrUnauthorized
+	}
+	if cert.RevokedAt != nil {
+		log.Default().Printf("agent certificate '%s' is revoked\n", serial)
+		return nil, xerrors.ErrUnauthorized
+	}
+
+	for _, name := range names {
+		srv, err := sm.srvRepository.FindSrvWithName(name)
+		if err != nil {
+			continue
+		}
+		if srv.ID == cert.SourceServerID {
+			return srv, nil
+		}
+	}
+
+	log.Default().Printf("no source server of agent certificate '%s' found by names %v\n", serial, names)
+	return nil, xerrors.ErrUnauthorized
+}
+
+func (sm *SrvManager) RecordAgentCertificate(srcSrvName, serial string, notAfter time.Time) (*AgentCertificate, error) {
+	srv, err := sm.srvRepository.FindSrvWithName(srcSrvName)
+	if err != nil {
+		return nil, err
+	}
+
+	cert := AgentCertificate{
+		Serial:         serial,
+		SourceServerID: srv.ID,
+		NotAfter:       notAfter,
+	}
+	if err := sm.srvRepository.CreateAgentCertificate(&cert); err != nil {
+		return nil, err
+	}
+	return &cert, nil
+}
+
+func (sm *SrvManager) GetAgentCertificates() (*[]AgentCertificate, error) {
+	return sm.srvRepository.FindAgentCertificates()
+}
+
+// RevokeAgentCertificates revokes certificate by its serial, or all of
+// certificates of a source server by its name
+func (sm *SrvManager) RevokeAgentCertificates(serial, srcSrvName string) (int64, error) {
+	if serial != "" {
+		return sm.srvRepository.RevokeAgentCertificates(AgentCertificate{Serial: serial})
+	}
+
+	srv, err := sm.srvRepository.FindSrvWithName(srcSrvName)
+	if err != nil {
+		return 0, err
+	}
+	return sm.srvRepository.RevokeAgentCertificates(AgentCertificate{SourceServerID: srv.ID})
+}
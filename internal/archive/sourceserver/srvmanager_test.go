@@ -0,0 +1,83 @@
+package sourceserver
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+)
+
+func mismatchReasons(report *IntegrityReport) []string {
+	reasons := []string{}
+	for _, m := range report.Mismatches {
+		reasons = append(reasons, m.Filename+"/"+m.Snapshot+": "+m.Reason)
+	}
+	sort.Strings(reasons)
+	return reasons
+}
+
+func TestVerifySnapshots(t *testing.T) {
+	repo := newTestRepository(t)
+	createTestSrv(t, repo, "web1")
+	storePath := t.TempDir()
+	srvManager := NewSrvManager(SrvConfig{StoreMode: "disk", DiskStoreConfig: DiskStoreConfig{Path: storePath}, SigningKey: newTestSigningKey(t)}, repo)
+	writeTestSnapshots(t, filepath.Join(storePath, "web1", "nginx"), "1000", "2000")
+	writeTestSnapshots(t, filepath.Join(storePath, "web1", "redis"), "1000")
+
+	report, err := srvManager.VerifySnapshots()
+	if err != nil {
+		t.Fatalf("verify snapshots: %s", err)
+	}
+	if report.Adopted != 3 || report.Checked != 0 || report.Failed != 0 || len(report.Mismatches) != 0 {
+		t.Fatalf("first scan is %+v, want 3 adopted", report)
+	}
+
+	// adopted snapshots are checked, not adopted again
+	report, err = srvManager.VerifySnapshots()
+	if err != nil {
+		t.Fatalf("verify snapshots: %s", err)
+	}
+	if report.Adopted != 0 || report.Checked != 3 || report.Failed != 0 || len(report.Mismatches) != 0 {
+		t.Fatalf("second scan is %+v, want 3 checked", report)
+	}
+
+	if err := os.Remove(filepath.Join(storePath, "web1", "nginx", "1000")); err != nil {
+		t.Fatalf("remove snapshot: %s", err)
+	}
+	if err := os.WriteFile(filepath.Join(storePath, "web1", "nginx", "2000"), []byte("changed"), 0o644); err != nil {
+		t.Fatalf("change snapshot: %s", err)
+	}
+	if err := os.RemoveAll(filepath.Join(storePath, "web1", "redis")); err != nil {
+		t.Fatalf("remove file directory: %s", err)
+	}
+
+	report, err = srvManager.VerifySnapshots()
+	if err != nil {
+		t.Fatalf("verify snapshots: %s", err)
+	}
+	want := []string{
+		"nginx/1000: snapshot is missing",
+		"nginx/2000: checksum mismatch",
+		"redis/1000: snapshot is missing",
+	}
+	got := mismatchReasons(report)
+	if len(got) != len(want) {
+		t.Fatalf("mismatches are %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("mismatches are %v, want %v", got, want)
+		}
+	}
+	if report.Adopted != 0 || report.Failed != 0 {
+		t.Fatalf("third scan is %+v, want no adopted or failed", report)
+	}
+
+	corrupted, err := repo.FindCorruptedSnapshots()
+	if err != nil {
+		t.Fatalf("find corrupted snapshots: %s", err)
+	}
+	if len(*corrupted) != 3 {
+		t.Fatalf("%d snapshots are flagged corrupted, want 3", len(*corrupted))
+	}
+}
@@ -13,12 +13,20 @@ const defaultBucketStoreSize = 3 * 24 * time.Hour // default is 3 days
 var buckets []Bucket = []Bucket{}
 var mu sync.Mutex
 
+var stopBuckets = make(chan struct{})
+var stopBucketsOnce sync.Once
+
 func init() {
 	createEmptyBucket(defaultBucketDurationSize)
 	go func() {
 		ticker := time.NewTicker(defaultBucketDurationSize)
+		defer ticker.Stop()
 		for {
-			<-ticker.C
+			select {
+			case <-stopBuckets:
+				return
+			case <-ticker.C:
+			}
 			createEmptyBucket(defaultBucketDurationSize)
 		}
 	}()
@@ -26,15 +34,44 @@ func init() {
 	go func() {
 		storeTimer := time.NewTimer(defaultBucketStoreSize)
 		storeTicker := time.NewTicker(defaultBucketDurationSize)
-		<-storeTimer.C
+		defer storeTicker.Stop()
+		select {
+		case <-stopBuckets:
+			storeTimer.Stop()
+			return
+		case <-storeTimer.C:
+		}
 		log.Default().Println("start rotating....")
 		for {
-			<-storeTicker.C
+			select {
+			case <-stopBuckets:
+				return
+			case <-storeTicker.C:
+			}
 			rotateStoredBuckets(defaultBucketStoreSize)
 		}
 	}()
 }
 
+// FlushSrcSrvMetrics stops creating and rotating buckets and closes the last
+// bucket at current time, so operations which are counted until shutdown are
+// reported with their real time span
+func FlushSrcSrvMetrics() {
+	stopBucketsOnce.Do(func() {
+		close(stopBuckets)
+	})
+
+	mu.Lock()
+	defer mu.Unlock()
+	lastBucket := &buckets[len(buckets)-1]
+	lastBucket.To = time.Now()
+	log.Default().Printf(
+		"source server metrics flushed. last bucket success: %d, fail: %d",
+		atomic.LoadInt64(&lastBucket.TotalSuccess),
+		atomic.LoadInt64(&lastBucket.TotalFail),
+	)
+}
+
 const (
 	FailOperation = iota
 	SuccessOperation
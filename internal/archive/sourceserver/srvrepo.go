@@ -3,6 +3,7 @@ package sourceserver
 import (
 	"errors"
 	"log"
+	"strconv"
 	"time"
 
 	"github.com/ARTM2000/archivo/internal/archive/xerrors"
@@ -10,11 +11,26 @@ import (
 	"gorm.io/gorm/clause"
 )
 
+// SourceServer quota and max upload fields override the global default ones
+// when they are not nil. ConfigRevision is the managed config revision which
+// agent of source server runs. RequestSigningKey is the wrapped key which
+// agent signs requests by, it is empty for source servers whose api key is
+// created before signed requests
 type SourceServer struct {
-	ID           uint      `gorm:"primaryKey;not null" json:"id"`
-	Name         string    `gorm:"type:string;not null;unique" json:"name"`
-	HashedAPIKey string    `gorm:"type:string;not null" json:"-"`
-	CreatedAt    time.Time `gorm:"autoUpdateTime:milli" json:"created_at"`
+	ID                uint       `gorm:"primaryKey;not null" json:"id"`
+	Name              string     `gorm:"type:string;not null;unique" json:"name"`
+	HashedAPIKey      string     `gorm:"type:string;not null" json:"-"`
+	RequestSigningKey string     `gorm:"type:string;not null;default:''" json:"-"`
+	LegalHold         bool       `gorm:"type:bool;not null;default:false" json:"legal_hold"`
+	LegalHoldNote     string     `gorm:"type:string;not null;default:''" json:"legal_hold_note"`
+	LegalHoldAt       *time.Time `json:"legal_hold_at"`
+	QuotaBytes        *int64     `json:"quota_bytes"`
+	QuotaSnapshots    *int       `json:"quota_snapshots"`
+	MaxUploadBytes    *int64     `json:"max_upload_bytes"`
+	Group             string     `gorm:"type:string;not null;default:'';index" json:"group"`
+	ConfigRevision    string     `gorm:"type:string;not null;default:''" json:"config_revision"`
+	ConfigSeenAt      *time.Time `json:"config_seen_at"`
+	CreatedAt         time.Time  `gorm:"autoUpdateTime:milli" json:"created_at"`
 }
 
 func NewSrvRepository(db *gorm.DB) SrvRepository {
@@ -61,10 +77,11 @@ func (sr *SrvRepository) FindSrvWithId(id uint) (*SourceServer, error) {
 	return &srv, nil
 }
 
-func (sr *SrvRepository) CreateNewSrv(name string, hashedAPIKey string) (*SourceServer, error) {
+func (sr *SrvRepository) CreateNewSrv(name string, hashedAPIKey string, requestSigningKey string) (*SourceServer, error) {
 	var newSrv = SourceServer{
-		Name:         name,
-		HashedAPIKey: hashedAPIKey,
+		Name:              name,
+		HashedAPIKey:      hashedAPIKey,
+		RequestSigningKey: requestSigningKey,
 	}
 	dbResult := sr.db.Model(&SourceServer{}).Create(&newSrv)
 
@@ -81,6 +98,146 @@ func (sr *SrvRepository) CreateNewSrv(name string, hashedAPIKey string) (*Source
 	return &newSrv, nil
 }
 
+func (sr *SrvRepository) UpdateSrvLegalHold(id uint, hold bool, note string) (*SourceServer, error) {
+	srv, err := sr.FindSrvWithId(id)
+	if err != nil {
+		return nil, err
+	}
+
+	srv.LegalHold = hold
+	srv.LegalHoldNote = ""
+	srv.LegalHoldAt = nil
+	if hold {
+		now := time.Now()
+		srv.LegalHoldNote = note
+		srv.LegalHoldAt = &now
+	}
+
+	dbResult := sr.db.Model(srv).Select("legal_hold", "legal_hold_note", "legal_hold_at").Updates(srv)
+	if dbResult.Error != nil {
+		log.Default().Printf("[Unhandled] error in updating legal hold of source server with id: '%d', error: %s\n", id, dbResult.Error.Error())
+		return nil, xerrors.ErrUnhandled
+	}
+
+	return srv, nil
+}
+
+func (sr *SrvRepository) UpdateSrvQuota(id uint, quotaBytes *int64, quotaSnapshots *int) (*SourceServer, error) {
+	srv, err := sr.FindSrvWithId(id)
+	if err != nil {
+		return nil, err
+	}
+
+	srv.QuotaBytes = quotaBytes
+	srv.QuotaSnapshots = quotaSnapshots
+	dbResult := sr.db.Model(srv).Select("quota_bytes", "quota_snapshots").Updates(srv)
+	if dbResult.Error != nil {
+		log.Default().Printf("[Unhandled] error in updating quota of source server with id: '%d', error: %s\n", id, dbResult.Error.Error())
+		return nil, xerrors.ErrUnhandled
+	}
+
+	return srv, nil
+}
+
+func (sr *SrvRepository) UpdateSrvGroup(id uint, group string) (*SourceServer, error) {
+	srv, err := sr.FindSrvWithId(id)
+	if err != nil {
+		return nil, err
+	}
+
+	srv.Group = group
+	dbResult := sr.db.Model(srv).Select("group").Updates(srv)
+	if dbResult.Error != nil {
+		log.Default().Printf("[Unhandled] error in updating group of source server with id: '%d', error: %s\n", id, dbResult.Error.Error())
+		return nil, xerrors.ErrUnhandled
+	}
+
+	return srv, nil
+}
+
+func (sr *SrvRepository) UpdateSrvConfigRevision(id uint, revision string) error {
+	now := time.Now()
+	dbResult := sr.db.Model(&SourceServer{ID: id}).Select("config_revision", "config_seen_at").Updates(SourceServer{
+		ConfigRevision: revision,
+		ConfigSeenAt:   &now,
+	})
+	if dbResult.Error != nil {
+		log.Default().Printf("[Unhandled] error in updating config revision of source server with id: '%d', error: %s\n", id, dbResult.Error.Error())
+		return xerrors.ErrUnhandled
+	}
+
+	return nil
+}
+
+func (sr *SrvRepository) UpdateSrvMaxUpload(id uint, maxUploadBytes *int64) (*SourceServer, error) {
+	srv, err := sr.FindSrvWithId(id)
+	if err != nil {
+		return nil, err
+	}
+
+	srv.MaxUploadBytes = maxUploadBytes
+	dbResult := sr.db.Model(srv).Select("max_upload_bytes").Updates(srv)
+	if dbResult.Error != nil {
+		log.Default().Printf("[Unhandled] error in updating max upload of source server with id: '%d', error: %s\n", id, dbResult.Error.Error())
+		return nil, xerrors.ErrUnhandled
+	}
+
+	return srv, nil
+}
+
+func (sr *SrvRepository) UpdateSrvAPIKey(id uint, hashedAPIKey string, requestSigningKey string) (*SourceServer, error) {
+	srv, err := sr.FindSrvWithId(id)
+	if err != nil {
+		return nil, err
+	}
+
+	srv.HashedAPIKey = hashedAPIKey
+	srv.RequestSigningKey = requestSigningKey
+	dbResult := sr.db.Model(srv).Select("hashed_api_key", "request_signing_key").Updates(srv)
+	if dbResult.Error != nil {
+		log.Default().Printf("[Unhandled] error in updating api key of source server with id: '%d', error: %s\n", id, dbResult.Error.Error())
+		return nil, xerrors.ErrUnhandled
+	}
+
+	return srv, nil
+}
+
+func (sr *SrvRepository) UpdateSrvRequestSigningKey(id uint, requestSigningKey string) error {
+	dbResult := sr.db.Model(&SourceServer{}).Where("id = ?", id).Update("request_signing_key", requestSigningKey)
+	if dbResult.Error != nil {
+		log.Default().Printf("[Unhandled] error in updating request signing key of source server with id: '%d', error: %s\n", id, dbResult.Error.Error())
+		return xerrors.ErrUnhandled
+	}
+
+	return nil
+}
+
+// DeleteSrv deletes source server along with all records which belong to it
+func (sr *SrvRepository) DeleteSrv(id uint) error {
+	err := sr.db.Transaction(func(tx *gorm.DB) error {
+		snapshots := tx.Unscoped().Model(&Snapshot{}).Select("id").Where("source_server_id = ?", id)
+		if err := tx.Where("snapshot_id IN (?)", snapshots).Delete(&SnapshotTerm{}).Error; err != nil {
+			return err
+		}
+		for _, model := range []interface{}{&Snapshot{}, &RestoreJob{}, &Heartbeat{}, &AgentCertificate{}} {
+			if err := tx.Unscoped().Where("source_server_id = ?", id).Delete(model).Error; err != nil {
+				return err
+			}
+		}
+		dbResult := tx.Where(ManagedConfig{Scope: ManagedConfigServerScope, ScopeKey: strconv.FormatUint(uint64(id), 10)}).Delete(&ManagedConfig{})
+		if dbResult.Error != nil {
+			return dbResult.Error
+		}
+		return tx.Delete(&SourceServer{}, id).Error
+	})
+	if err != nil {
+		log.Default().Printf("[Unhandled] error in deleting source server with id: '%d', error: %s\n", id, err.Error())
+		return xerrors.ErrUnhandled
+	}
+
+	return nil
+}
+
 type FindAllOption struct {
 	SortBy    string
 	SortOrder string
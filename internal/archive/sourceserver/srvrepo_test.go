@@ -0,0 +1,197 @@
+package sourceserver
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/ARTM2000/archivo/internal/archive/migration"
+	"github.com/ARTM2000/archivo/internal/archive/xerrors"
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func newTestRepository(t *testing.T) SrvRepository {
+	t.Helper()
+	dsn := "file:" + filepath.Join(t.TempDir(), "archivo.db") + "?_pragma=foreign_keys(1)&_txlock=immediate"
+	db, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{Logger: logger.Discard, TranslateError: true})
+	if err != nil {
+		t.Fatalf("open database: %s", err)
+	}
+	migrator, err := migration.NewMigrator(db, nil)
+	if err != nil {
+		t.Fatalf("new migrator: %s", err)
+	}
+	if _, err := migrator.Up(); err != nil {
+		t.Fatalf("migrate database: %s", err)
+	}
+	return NewSrvRepository(db)
+}
+
+func createTestSrv(t *testing.T, repo SrvRepository, name string) *SourceServer {
+	t.Helper()
+	srv, err := repo.CreateNewSrv(name, "hashed-"+name, "")
+	if err != nil {
+		t.Fatalf("create source server '%s': %s", name, err)
+	}
+	return srv
+}
+
+func createTestSnapshot(t *testing.T, repo SrvRepository, srvId uint, filename, name string) *Snapshot {
+	t.Helper()
+	snapshot := Snapshot{SourceServerID: srvId, Filename: filename, Name: name, Checksum: "checksum-" + name, ByteSize: 1}
+	if err := repo.CreateSnapshot(&snapshot); err != nil {
+		t.Fatalf("create snapshot '%s': %s", name, err)
+	}
+	return &snapshot
+}
+
+func snapshotNames(snapshots *[]Snapshot) []string {
+	names := []string{}
+	for _, snapshot := range *snapshots {
+		names = append(names, snapshot.Name)
+	}
+	return names
+}
+
+func TestCreateAndFindSrv(t *testing.T) {
+	repo := newTestRepository(t)
+	srv := createTestSrv(t, repo, "web1")
+
+	byName, err := repo.FindSrvWithName("web1")
+	if err != nil {
+		t.Fatalf("find source server by name: %s", err)
+	}
+	if byName.ID != srv.ID || byName.HashedAPIKey != "hashed-web1" {
+		t.Fatalf("found source server %+v, want %+v", byName, srv)
+	}
+	if _, err := repo.FindSrvWithId(srv.ID); err != nil {
+		t.Fatalf("find source server by id: %s", err)
+	}
+
+	if _, err := repo.CreateNewSrv("web1", "other", ""); !errors.Is(err, xerrors.ErrDuplicateViolation) {
+		t.Fatalf("creating duplicate source server returned %v, want %v", err, xerrors.ErrDuplicateViolation)
+	}
+	if _, err := repo.FindSrvWithName("web2"); !errors.Is(err, xerrors.ErrRecordNotFound) {
+		t.Fatalf("finding missing source server returned %v, want %v", err, xerrors.ErrRecordNotFound)
+	}
+}
+
+func TestDeleteSnapshotsKeepsHistory(t *testing.T) {
+	repo := newTestRepository(t)
+	srv := createTestSrv(t, repo, "web1")
+	first := createTestSnapshot(t, repo, srv.ID, "nginx", "1000")
+	second := createTestSnapshot(t, repo, srv.ID, "nginx", "2000")
+	createTestSnapshot(t, repo, srv.ID, "nginx", "3000")
+	if err := repo.ReplaceSnapshotTerms(first.ID, []string{"listen", "server"}); err != nil {
+		t.Fatalf("index snapshot: %s", err)
+	}
+	if err := repo.ReplaceSnapshotTerms(second.ID, []string{"listen"}); err != nil {
+		t.Fatalf("index snapshot: %s", err)
+	}
+
+	if err := repo.DeleteSnapshots(srv.ID, "nginx", []string{"1000"}); err != nil {
+		t.Fatalf("delete snapshots: %s", err)
+	}
+
+	current, err := repo.FindFileSnapshots(srv.ID, "nginx")
+	if err != nil {
+		t.Fatalf("find file snapshots: %s", err)
+	}
+	if names := snapshotNames(current); len(names) != 2 || names[0] != "2000" || names[1] != "3000" {
+		t.Fatalf("file snapshots are %v, want [2000 3000]", names)
+	}
+	history, err := repo.FindFileSnapshotsHistory(srv.ID, "nginx")
+	if err != nil {
+		t.Fatalf("find file snapshots history: %s", err)
+	}
+	if len(*history) != 3 || !(*history)[0].DeletedAt.Valid {
+		t.Fatalf("history does not keep rotated snapshot: %v", snapshotNames(history))
+	}
+
+	matched, err := repo.FindSnapshotsByTerms([]string{"listen"})
+	if err != nil {
+		t.Fatalf("find snapshots by terms: %s", err)
+	}
+	if names := snapshotNames(matched); len(names) != 1 || names[0] != "2000" {
+		t.Fatalf("snapshots with term are %v, want [2000]", names)
+	}
+}
+
+func TestFindSnapshotsByTerms(t *testing.T) {
+	repo := newTestRepository(t)
+	srv := createTestSrv(t, repo, "web1")
+	first := createTestSnapshot(t, repo, srv.ID, "nginx", "1000")
+	second := createTestSnapshot(t, repo, srv.ID, "nginx", "2000")
+	if err := repo.ReplaceSnapshotTerms(first.ID, []string{"listen", "server", "80"}); err != nil {
+		t.Fatalf("index snapshot: %s", err)
+	}
+	if err := repo.ReplaceSnapshotTerms(second.ID, []string{"listen", "server", "443"}); err != nil {
+		t.Fatalf("index snapshot: %s", err)
+	}
+
+	matched, err := repo.FindSnapshotsByTerms([]string{"listen", "server"})
+	if err != nil {
+		t.Fatalf("find snapshots by terms: %s", err)
+	}
+	if names := snapshotNames(matched); len(names) != 2 || names[0] != "1000" || names[1] != "2000" {
+		t.Fatalf("snapshots with terms are %v, want [1000 2000]", names)
+	}
+
+	matched, err = repo.FindSnapshotsByTerms([]string{"listen", "443"})
+	if err != nil {
+		t.Fatalf("find snapshots by terms: %s", err)
+	}
+	if names := snapshotNames(matched); len(names) != 1 || names[0] != "2000" {
+		t.Fatalf("snapshots with terms are %v, want [2000]", names)
+	}
+
+	// replacing terms drops the old ones
+	if err := repo.ReplaceSnapshotTerms(second.ID, []string{"listen"}); err != nil {
+		t.Fatalf("index snapshot: %s", err)
+	}
+	matched, err = repo.FindSnapshotsByTerms([]string{"443"})
+	if err != nil {
+		t.Fatalf("find snapshots by terms: %s", err)
+	}
+	if len(*matched) != 0 {
+		t.Fatalf("snapshots with replaced term are %v, want none", snapshotNames(matched))
+	}
+}
+
+func TestDeleteSrv(t *testing.T) {
+	repo := newTestRepository(t)
+	srv := createTestSrv(t, repo, "web1")
+	other := createTestSrv(t, repo, "web2")
+	snapshot := createTestSnapshot(t, repo, srv.ID, "nginx", "1000")
+	otherSnapshot := createTestSnapshot(t, repo, other.ID, "nginx", "1000")
+	if err := repo.ReplaceSnapshotTerms(snapshot.ID, []string{"listen"}); err != nil {
+		t.Fatalf("index snapshot: %s", err)
+	}
+	if err := repo.ReplaceSnapshotTerms(otherSnapshot.ID, []string{"listen"}); err != nil {
+		t.Fatalf("index snapshot: %s", err)
+	}
+
+	if err := repo.DeleteSrv(srv.ID); err != nil {
+		t.Fatalf("delete source server: %s", err)
+	}
+
+	if _, err := repo.FindSrvWithId(srv.ID); !errors.Is(err, xerrors.ErrRecordNotFound) {
+		t.Fatalf("finding deleted source server returned %v, want %v", err, xerrors.ErrRecordNotFound)
+	}
+	history, err := repo.FindFileSnapshotsHistory(srv.ID, "nginx")
+	if err != nil {
+		t.Fatalf("find file snapshots history: %s", err)
+	}
+	if len(*history) != 0 {
+		t.Fatalf("snapshots of deleted source server are kept: %v", snapshotNames(history))
+	}
+	matched, err := repo.FindSnapshotsByTerms([]string{"listen"})
+	if err != nil {
+		t.Fatalf("find snapshots by terms: %s", err)
+	}
+	if len(*matched) != 1 || (*matched)[0].SourceServerID != other.ID {
+		t.Fatalf("snapshots with term are %+v, want only the one of other source server", *matched)
+	}
+}
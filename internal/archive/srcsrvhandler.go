@@ -1,13 +1,16 @@
 package archive
 
 import (
+	"bufio"
 	"errors"
 	"fmt"
 	"log"
 	"mime/multipart"
+	"strconv"
 	"strings"
 	"time"
 
+	"github.com/ARTM2000/archivo/internal/archive/auth"
 	"github.com/ARTM2000/archivo/internal/archive/sourceserver"
 	"github.com/ARTM2000/archivo/internal/archive/xerrors"
 	"github.com/ARTM2000/archivo/internal/validate"
@@ -16,6 +19,18 @@ import (
 
 const (
 	SrcSrvLocalName = "srcsrv"
+	// StoreRoutePrefix is prefix of routes which agents upload files through
+	StoreRoutePrefix = "/api/v1/servers/store/"
+	// multipartOverhead is the allowed size of multipart form fields and
+	// boundaries on top of uploaded file size
+	multipartOverhead = 64 * 1024
+	// headers of snapshot which is sent to agent for restore
+	SnapshotNameHeader     = "X-Snapshot-Name"
+	SnapshotChecksumHeader = "X-Snapshot-Checksum"
+	SnapshotModeHeader     = "X-Snapshot-Mode"
+	SnapshotUidHeader      = "X-Snapshot-Uid"
+	SnapshotGidHeader      = "X-Snapshot-Gid"
+	SnapshotMtimeHeader    = "X-Snapshot-Mtime"
 )
 
 type registerNewSourceServer struct {
@@ -26,6 +41,47 @@ type rotateSrcSrvFile struct {
 	File     *multipart.FileHeader `form:"file" validate:"required"`
 	FileName string                `form:"filename" validate:"omitempty,filename,alphanum"`
 	Rotate   int                   `form:"rotate" validate:"required,number"`
+	Checksum string                `form:"checksum" validate:"omitempty,hexadecimal,len=64"`
+	// metadata of source file
+	Path  string `form:"path" validate:"omitempty,max=4096"`
+	Mode  string `form:"mode" validate:"omitempty,numeric,max=6"`
+	Uid   *int   `form:"uid" validate:"omitempty,gte=0"`
+	Gid   *int   `form:"gid" validate:"omitempty,gte=0"`
+	Owner string `form:"owner" validate:"omitempty,max=255"`
+	Group string `form:"group" validate:"omitempty,max=255"`
+	Mtime int64  `form:"mtime" validate:"omitempty,gt=0"`
+	Host  string `form:"host" validate:"omitempty,max=255"`
+}
+
+func (r *rotateSrcSrvFile) metadata() (sourceserver.FileMetadata, error) {
+	metadata := sourceserver.FileMetadata{
+		SourcePath: r.Path,
+		Uid:        r.Uid,
+		Gid:        r.Gid,
+		OwnerName:  r.Owner,
+		GroupName:  r.Group,
+		Host:       r.Host,
+	}
+	if r.Mode != "" {
+		// mode is received in octal, e.g. 0644
+		mode, err := strconv.ParseUint(r.Mode, 8, 32)
+		if err != nil {
+			return metadata, fmt.Errorf("mode should be in octal format")
+		}
+		fileMode := uint32(mode)
+		metadata.Mode = &fileMode
+	}
+	if r.Mtime != 0 {
+		mtime := time.UnixMilli(r.Mtime)
+		metadata.ModTime = &mtime
+	}
+	return metadata, nil
+}
+
+type restoreSnapshotData struct {
+	Filename string `query:"filename" validate:"required"`
+	Snapshot string `query:"snapshot" validate:"omitempty"`
+	At       int64  `query:"at" validate:"omitempty,number"`
 }
 
 type listData struct {
@@ -40,11 +96,74 @@ type snapshotListData struct {
 	Filename string `params:"filename" validate:"required"`
 }
 
+type exportSourceServerData struct {
+	SrvId uint `params:"srvId" validate:"required,number"`
+	// unix milliseconds, default is now
+	At int64 `query:"at" validate:"omitempty,number"`
+}
+
 type downloadSnapshotData struct {
 	snapshotListData
 	Snapshot string `params:"snapshot" validate:"required"`
 }
 
+type pinSnapshotDto struct {
+	Note string `json:"note" validate:"omitempty,max=255"`
+}
+
+type legalHoldDto struct {
+	Note string `json:"note" validate:"required,max=255"`
+}
+
+type srvQuotaDto struct {
+	MaxBytes     *int64 `json:"max_bytes" validate:"omitempty,gte=0"`
+	MaxSnapshots *int   `json:"max_snapshots" validate:"omitempty,gte=0"`
+}
+
+type srvMaxUploadDto struct {
+	MaxBytes *int64 `json:"max_bytes" validate:"omitempty,gte=0"`
+}
+
+type restoreJobParams struct {
+	JobId uint `params:"jobId" validate:"required,number"`
+}
+
+type restoreJobReportDto struct {
+	Succeeded *bool  `json:"succeeded" validate:"required"`
+	Message   string `json:"message" validate:"omitempty,max=1024"`
+}
+
+type managedFilesDto struct {
+	Files []sourceserver.ManagedFile `json:"files" validate:"dive"`
+}
+
+type srvGroupDto struct {
+	Group string `json:"group" validate:"omitempty,max=64,printascii"`
+}
+
+type groupParams struct {
+	Group string `params:"group" validate:"required,max=64"`
+}
+
+type agentConfigQuery struct {
+	Revision string `query:"revision" validate:"omitempty,max=64"`
+}
+
+type heartbeatDto struct {
+	Version        string                       `json:"version" validate:"omitempty,max=64"`
+	Hostname       string                       `json:"hostname" validate:"omitempty,max=255"`
+	OS             string                       `json:"os" validate:"omitempty,max=32"`
+	Arch           string                       `json:"arch" validate:"omitempty,max=32"`
+	ConfigRevision string                       `json:"config_revision" validate:"omitempty,max=64"`
+	PendingUploads int                          `json:"pending_uploads" validate:"omitempty,gte=0"`
+	RunningUploads int                          `json:"running_uploads" validate:"omitempty,gte=0"`
+	Files          []sourceserver.HeartbeatFile `json:"files"`
+}
+
+type srvParams struct {
+	SrvId uint `params:"srvId" validate:"required,number"`
+}
+
 type timeWindow struct {
 	From int64 `query:"from" validate:"required,number"`
 	To   int64 `query:"to" validate:"required,number"`
@@ -68,7 +187,14 @@ func (api *API) getListOfSourceServers(c *fiber.Ctx) error {
 	}
 
 	srcsrvManager := sourceserver.NewSrvManager(
-		sourceserver.SrvConfig{},
+		sourceserver.SrvConfig{
+			CorrelationId:     c.GetRespHeader(fiber.HeaderXRequestID),
+			StoreMode:         api.Config.FileStore.Mode,
+			DiskStoreConfig:   sourceserver.DiskStoreConfig(api.Config.FileStore.DiskConfig),
+			DefaultQuota:      sourceserver.Quota(api.Config.DefaultQuota),
+			DefaultMaxUpload:  api.Config.Upload.MaxUploadSize(),
+			AgentOfflineAfter: api.Config.AgentOfflineAfter,
+		},
 		sourceserver.NewSrvRepository(api.DB),
 	)
 
@@ -282,109 +408,1153 @@ func (api *API) downloadSnapshot(c *fiber.Ctx) error {
 	return nil
 }
 
-func (api *API) registerNewSourceServer(c *fiber.Ctx) error {
-	var registerData registerNewSourceServer
-	if err := c.BodyParser(&registerData); err != nil {
-		log.Default().Println(err.Error())
+func (api *API) exportFileManifest(c *fiber.Ctx) error {
+	params := snapshotListData{}
+	if err := c.ParamsParser(&params); err != nil {
 		return fiber.NewError(fiber.StatusBadRequest, err.Error())
 	}
+	if errs, ok := validate.ValidateStruct[snapshotListData](&params); !ok {
+		log.Default().Println(errs[0].Message)
+		return fiber.NewError(fiber.StatusUnprocessableEntity, errs[0].Message)
+	}
 
-	if errs, ok := validate.ValidateStruct[registerNewSourceServer](&registerData); !ok {
+	srcsrvManager := sourceserver.NewSrvManager(
+		sourceserver.SrvConfig{
+			CorrelationId:   c.GetRespHeader(fiber.HeaderXRequestID),
+			StoreMode:       api.Config.FileStore.Mode,
+			DiskStoreConfig: sourceserver.DiskStoreConfig(api.Config.FileStore.DiskConfig),
+			SigningKey:      api.SigningKey,
+		},
+		sourceserver.NewSrvRepository(api.DB),
+	)
+
+	manifest, err := srcsrvManager.ExportFileManifest(params.SrvId, params.Filename)
+	if err != nil {
+		log.Default().Printf("error in exporting manifest of source server by id '%d' and filename '%s'. error: %s", params.SrvId, params.Filename, err.Error())
+		if errors.Is(err, xerrors.ErrRecordNotFound) || errors.Is(err, xerrors.ErrNoFileStoredOnSourceServerByThisName) {
+			return fiber.NewError(fiber.StatusNotFound, err.Error())
+		}
+		return fiber.NewError(fiber.StatusInternalServerError, "internal server error")
+	}
+
+	c.Append(fiber.HeaderContentDisposition, fmt.Sprintf("attachment; filename=%d-%s-manifest.json", params.SrvId, params.Filename))
+	return c.Status(fiber.StatusOK).JSON(manifest)
+}
+
+func (api *API) exportSourceServer(c *fiber.Ctx) error {
+	params := exportSourceServerData{}
+	if err := c.ParamsParser(&params); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, err.Error())
+	}
+	if err := c.QueryParser(&params); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, err.Error())
+	}
+	if errs, ok := validate.ValidateStruct[exportSourceServerData](&params); !ok {
 		log.Default().Println(errs[0].Message)
 		return fiber.NewError(fiber.StatusUnprocessableEntity, errs[0].Message)
 	}
 
 	srcsrvManager := sourceserver.NewSrvManager(
-		sourceserver.SrvConfig{},
+		sourceserver.SrvConfig{
+			CorrelationId:   c.GetRespHeader(fiber.HeaderXRequestID),
+			StoreMode:       api.Config.FileStore.Mode,
+			DiskStoreConfig: sourceserver.DiskStoreConfig(api.Config.FileStore.DiskConfig),
+		},
 		sourceserver.NewSrvRepository(api.DB),
 	)
-	newSourceServerD, err := srcsrvManager.RegisterNewSourceServer(registerData.Name)
+
+	at := time.Now()
+	if params.At != 0 {
+		at = time.UnixMilli(params.At)
+	}
+
+	srv, export, err := srcsrvManager.PrepareExport(params.SrvId, at)
 	if err != nil {
-		if errors.Is(err, xerrors.ErrSourceServerWithThisNameExists) {
-			return fiber.NewError(fiber.StatusConflict, "source server with this name exists")
+		log.Default().Printf("error in preparing export of source server by id '%d' at '%s'. error: %s", params.SrvId, at, err.Error())
+		if errors.Is(err, xerrors.ErrRecordNotFound) {
+			return fiber.NewError(fiber.StatusNotFound, err.Error())
 		}
+		return fiber.NewError(fiber.StatusInternalServerError, "internal server error")
+	}
 
-		log.Default().Println("[Unhandled] error for registering new source server", err.Error())
+	// bundle is streamed after handler returns, so errors can only be logged
+	correlationId := c.GetRespHeader(fiber.HeaderXRequestID)
+	c.Set(fiber.HeaderContentType, "application/gzip")
+	c.Append(fiber.HeaderContentDisposition, fmt.Sprintf("attachment; filename=%s.tar.gz", export.Dirname()))
+	c.Status(fiber.StatusOK).Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		if err := srcsrvManager.WriteExport(srv, export, w); err != nil {
+			log.Default().Printf("error in writing export of source server '%s', error: %s. correlationId: '%s'", srv.Name, err.Error(), correlationId)
+			return
+		}
+		if err := w.Flush(); err != nil {
+			log.Default().Printf("error in writing export of source server '%s', error: %s. correlationId: '%s'", srv.Name, err.Error(), correlationId)
+		}
+	})
+	return nil
+}
+
+func (api *API) pinSnapshot(c *fiber.Ctx) error {
+	params := downloadSnapshotData{}
+	if err := c.ParamsParser(&params); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, err.Error())
+	}
+	if errs, ok := validate.ValidateStruct[downloadSnapshotData](&params); !ok {
+		log.Default().Println(errs[0].Message)
+		return fiber.NewError(fiber.StatusUnprocessableEntity, errs[0].Message)
+	}
+
+	var pinData pinSnapshotDto
+	if err := c.BodyParser(&pinData); err != nil {
+		log.Default().Println(err.Error())
+		return fiber.NewError(fiber.StatusBadRequest, err.Error())
+	}
+	if errs, ok := validate.ValidateStruct[pinSnapshotDto](&pinData); !ok {
+		log.Default().Println(errs[0].Message)
+		return fiber.NewError(fiber.StatusUnprocessableEntity, errs[0].Message)
+	}
+
+	srcsrvManager := sourceserver.NewSrvManager(
+		sourceserver.SrvConfig{
+			CorrelationId: c.GetRespHeader(fiber.HeaderXRequestID),
+		},
+		sourceserver.NewSrvRepository(api.DB),
+	)
+
+	user := c.Locals(UserLocalName).(*auth.User)
+
+	snapshot, err := srcsrvManager.PinSnapshot(params.SrvId, params.Filename, params.Snapshot, pinData.Note, user.ID)
+	if err != nil {
+		if errors.Is(err, xerrors.ErrRecordNotFound) || errors.Is(err, xerrors.ErrSnapshotNotFound) {
+			return fiber.NewError(fiber.StatusNotFound, err.Error())
+		}
+		log.Default().Println("[Unhandled] error for pinning snapshot", err.Error())
 		return fiber.NewError(fiber.StatusInternalServerError, "internal server error")
 	}
 
 	return c.Status(fiber.StatusOK).JSON(FormatResponse(c, Data{
-		Message: "new source server created",
+		Message: "snapshot pinned",
 		Data: map[string]interface{}{
-			"id":      newSourceServerD.NewServer.ID,
-			"name":    newSourceServerD.NewServer.Name,
-			"api_key": newSourceServerD.APIKey,
+			"snapshot": snapshot,
 		},
 	}))
 }
 
-func (api *API) authorizeSourceServerMiddleware(c *fiber.Ctx) error {
-	sourceServerName := c.Get("X-Agent1-Name")
-	if strings.TrimSpace(sourceServerName) == "" {
-		log.Default().Println("unauthorized agent1 request. no agent name received")
-		return fiber.NewError(fiber.StatusUnauthorized, "unauthorized request")
+func (api *API) unpinSnapshot(c *fiber.Ctx) error {
+	params := downloadSnapshotData{}
+	if err := c.ParamsParser(&params); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, err.Error())
+	}
+	if errs, ok := validate.ValidateStruct[downloadSnapshotData](&params); !ok {
+		log.Default().Println(errs[0].Message)
+		return fiber.NewError(fiber.StatusUnprocessableEntity, errs[0].Message)
 	}
 
-	authHeader := c.Get(fiber.HeaderAuthorization)
-	if strings.TrimSpace(authHeader) == "" {
-		log.Default().Println("unauthorized agent1 request. no api key received")
-		return fiber.NewError(fiber.StatusUnauthorized, "unauthorized request")
+	srcsrvManager := sourceserver.NewSrvManager(
+		sourceserver.SrvConfig{
+			CorrelationId: c.GetRespHeader(fiber.HeaderXRequestID),
+		},
+		sourceserver.NewSrvRepository(api.DB),
+	)
+
+	snapshot, err := srcsrvManager.UnpinSnapshot(params.SrvId, params.Filename, params.Snapshot)
+	if err != nil {
+		if errors.Is(err, xerrors.ErrRecordNotFound) || errors.Is(err, xerrors.ErrSnapshotNotFound) {
+			return fiber.NewError(fiber.StatusNotFound, err.Error())
+		}
+		log.Default().Println("[Unhandled] error for unpinning snapshot", err.Error())
+		return fiber.NewError(fiber.StatusInternalServerError, "internal server error")
+	}
+
+	return c.Status(fiber.StatusOK).JSON(FormatResponse(c, Data{
+		Message: "snapshot unpinned",
+		Data: map[string]interface{}{
+			"snapshot": snapshot,
+		},
+	}))
+}
+
+func (api *API) placeLegalHold(c *fiber.Ctx) error {
+	params := srvParams{}
+	if err := c.ParamsParser(&params); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, err.Error())
+	}
+	if errs, ok := validate.ValidateStruct[srvParams](&params); !ok {
+		log.Default().Println(errs[0].Message)
+		return fiber.NewError(fiber.StatusUnprocessableEntity, errs[0].Message)
+	}
+
+	var holdData legalHoldDto
+	if err := c.BodyParser(&holdData); err != nil {
+		log.Default().Println(err.Error())
+		return fiber.NewError(fiber.StatusBadRequest, err.Error())
+	}
+	if errs, ok := validate.ValidateStruct[legalHoldDto](&holdData); !ok {
+		log.Default().Println(errs[0].Message)
+		return fiber.NewError(fiber.StatusUnprocessableEntity, errs[0].Message)
 	}
 
 	srcsrvManager := sourceserver.NewSrvManager(
 		sourceserver.SrvConfig{},
 		sourceserver.NewSrvRepository(api.DB),
 	)
-	srcSrv, err := srcsrvManager.AuthorizeSourceServer(sourceServerName, authHeader)
+
+	srv, err := srcsrvManager.PlaceLegalHold(params.SrvId, holdData.Note)
 	if err != nil {
-		log.Default().Printf("error in authorizing agent request, %s", err.Error())
-		return fiber.NewError(fiber.StatusUnauthorized, "unauthorized request")
+		if errors.Is(err, xerrors.ErrRecordNotFound) {
+			return fiber.NewError(fiber.StatusNotFound, err.Error())
+		}
+		log.Default().Println("[Unhandled] error for placing legal hold", err.Error())
+		return fiber.NewError(fiber.StatusInternalServerError, "internal server error")
 	}
 
-	c.Locals(SrcSrvLocalName, srcSrv)
-	return c.Next()
+	return c.Status(fiber.StatusOK).JSON(FormatResponse(c, Data{
+		Message: "legal hold placed",
+		Data: map[string]interface{}{
+			"server": srv,
+		},
+	}))
 }
 
-func (api *API) rotateSrcSrvFile(c *fiber.Ctx) error {
-	var rotateData rotateSrcSrvFile
-	if err := c.BodyParser(&rotateData); err != nil {
+func (api *API) releaseLegalHold(c *fiber.Ctx) error {
+	params := srvParams{}
+	if err := c.ParamsParser(&params); err != nil {
 		return fiber.NewError(fiber.StatusBadRequest, err.Error())
 	}
+	if errs, ok := validate.ValidateStruct[srvParams](&params); !ok {
+		log.Default().Println(errs[0].Message)
+		return fiber.NewError(fiber.StatusUnprocessableEntity, errs[0].Message)
+	}
 
-	var fileLoadErr error
-	rotateData.File, fileLoadErr = c.FormFile("file")
-	if fileLoadErr != nil {
-		log.Default().Println(fileLoadErr.Error())
+	srcsrvManager := sourceserver.NewSrvManager(
+		sourceserver.SrvConfig{},
+		sourceserver.NewSrvRepository(api.DB),
+	)
+
+	srv, err := srcsrvManager.ReleaseLegalHold(params.SrvId)
+	if err != nil {
+		if errors.Is(err, xerrors.ErrRecordNotFound) {
+			return fiber.NewError(fiber.StatusNotFound, err.Error())
+		}
+		log.Default().Println("[Unhandled] error for releasing legal hold", err.Error())
+		return fiber.NewError(fiber.StatusInternalServerError, "internal server error")
 	}
 
-	if errs, ok := validate.ValidateStruct[rotateSrcSrvFile](&rotateData); !ok {
+	return c.Status(fiber.StatusOK).JSON(FormatResponse(c, Data{
+		Message: "legal hold released",
+		Data: map[string]interface{}{
+			"server": srv,
+		},
+	}))
+}
+
+func (api *API) setSourceServerQuota(c *fiber.Ctx) error {
+	params := srvParams{}
+	if err := c.ParamsParser(&params); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, err.Error())
+	}
+	if errs, ok := validate.ValidateStruct[srvParams](&params); !ok {
+		log.Default().Println(errs[0].Message)
+		return fiber.NewError(fiber.StatusUnprocessableEntity, errs[0].Message)
+	}
+
+	var quotaData srvQuotaDto
+	if err := c.BodyParser(&quotaData); err != nil {
+		log.Default().Println(err.Error())
+		return fiber.NewError(fiber.StatusBadRequest, err.Error())
+	}
+	if errs, ok := validate.ValidateStruct[srvQuotaDto](&quotaData); !ok {
 		log.Default().Println(errs[0].Message)
 		return fiber.NewError(fiber.StatusUnprocessableEntity, errs[0].Message)
 	}
 
 	srcsrvManager := sourceserver.NewSrvManager(
 		sourceserver.SrvConfig{
-			CorrelationId:   c.GetRespHeader(fiber.HeaderXRequestID),
-			StoreMode:       api.Config.FileStore.Mode,
-			DiskStoreConfig: sourceserver.DiskStoreConfig(api.Config.FileStore.DiskConfig),
+			CorrelationId:     c.GetRespHeader(fiber.HeaderXRequestID),
+			StoreMode:         api.Config.FileStore.Mode,
+			DiskStoreConfig:   sourceserver.DiskStoreConfig(api.Config.FileStore.DiskConfig),
+			DefaultQuota:      sourceserver.Quota(api.Config.DefaultQuota),
+			DefaultMaxUpload:  api.Config.Upload.MaxUploadSize(),
+			AgentOfflineAfter: api.Config.AgentOfflineAfter,
 		},
 		sourceserver.NewSrvRepository(api.DB),
 	)
 
-	srcsrv := c.Locals(SrcSrvLocalName).(*sourceserver.SourceServer)
-
-	err := srcsrvManager.RotateFile(srcsrv, rotateData.Rotate, rotateData.FileName, rotateData.File)
+	srvInfo, err := srcsrvManager.SetSourceServerQuota(params.SrvId, quotaData.MaxBytes, quotaData.MaxSnapshots)
 	if err != nil {
-		log.Default().Println("error in file rotation. error:", err.Error())
-		if errors.Is(err, xerrors.ErrFileRotateCountIsLowerThanPreviousOne) ||
-			errors.Is(err, xerrors.ErrRotateGlobalLimitReached) {
-			return fiber.NewError(fiber.StatusConflict, err.Error())
+		if errors.Is(err, xerrors.ErrRecordNotFound) {
+			return fiber.NewError(fiber.StatusNotFound, err.Error())
 		}
+		log.Default().Println("[Unhandled] error for setting source server quota", err.Error())
 		return fiber.NewError(fiber.StatusInternalServerError, "internal server error")
 	}
 
 	return c.Status(fiber.StatusOK).JSON(FormatResponse(c, Data{
-		Message: "done",
+		Message: "source server quota updated",
+		Data: map[string]interface{}{
+			"server": srvInfo,
+		},
+	}))
+}
+
+func (api *API) setSourceServerMaxUpload(c *fiber.Ctx) error {
+	params := srvParams{}
+	if err := c.ParamsParser(&params); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, err.Error())
+	}
+	if errs, ok := validate.ValidateStruct[srvParams](&params); !ok {
+		log.Default().Println(errs[0].Message)
+		return fiber.NewError(fiber.StatusUnprocessableEntity, errs[0].Message)
+	}
+
+	var maxUploadData srvMaxUploadDto
+	if err := c.BodyParser(&maxUploadData); err != nil {
+		log.Default().Println(err.Error())
+		return fiber.NewError(fiber.StatusBadRequest, err.Error())
+	}
+	if errs, ok := validate.ValidateStruct[srvMaxUploadDto](&maxUploadData); !ok {
+		log.Default().Println(errs[0].Message)
+		return fiber.NewError(fiber.StatusUnprocessableEntity, errs[0].Message)
+	}
+
+	srcsrvManager := sourceserver.NewSrvManager(
+		sourceserver.SrvConfig{
+			CorrelationId:     c.GetRespHeader(fiber.HeaderXRequestID),
+			StoreMode:         api.Config.FileStore.Mode,
+			DiskStoreConfig:   sourceserver.DiskStoreConfig(api.Config.FileStore.DiskConfig),
+			DefaultQuota:      sourceserver.Quota(api.Config.DefaultQuota),
+			DefaultMaxUpload:  api.Config.Upload.MaxUploadSize(),
+			AgentOfflineAfter: api.Config.AgentOfflineAfter,
+		},
+		sourceserver.NewSrvRepository(api.DB),
+	)
+
+	srvInfo, err := srcsrvManager.SetSourceServerMaxUpload(params.SrvId, maxUploadData.MaxBytes)
+	if err != nil {
+		if errors.Is(err, xerrors.ErrRecordNotFound) {
+			return fiber.NewError(fiber.StatusNotFound, err.Error())
+		}
+		log.Default().Println("[Unhandled] error for setting source server max upload", err.Error())
+		return fiber.NewError(fiber.StatusInternalServerError, "internal server error")
+	}
+
+	return c.Status(fiber.StatusOK).JSON(FormatResponse(c, Data{
+		Message: "source server max upload updated",
+		Data: map[string]interface{}{
+			"server": srvInfo,
+		},
+	}))
+}
+
+func (api *API) registerNewSourceServer(c *fiber.Ctx) error {
+	var registerData registerNewSourceServer
+	if err := c.BodyParser(&registerData); err != nil {
+		log.Default().Println(err.Error())
+		return fiber.NewError(fiber.StatusBadRequest, err.Error())
+	}
+
+	if errs, ok := validate.ValidateStruct[registerNewSourceServer](&registerData); !ok {
+		log.Default().Println(errs[0].Message)
+		return fiber.NewError(fiber.StatusUnprocessableEntity, errs[0].Message)
+	}
+
+	srcsrvManager := sourceserver.NewSrvManager(
+		sourceserver.SrvConfig{
+			SigningKey: api.SigningKey,
+		},
+		sourceserver.NewSrvRepository(api.DB),
+	)
+	newSourceServerD, err := srcsrvManager.RegisterNewSourceServer(registerData.Name)
+	if err != nil {
+		if errors.Is(err, xerrors.ErrSourceServerWithThisNameExists) {
+			return fiber.NewError(fiber.StatusConflict, "source server with this name exists")
+		}
+
+		log.Default().Println("[Unhandled] error for registering new source server", err.Error())
+		return fiber.NewError(fiber.StatusInternalServerError, "internal server error")
+	}
+
+	return c.Status(fiber.StatusOK).JSON(FormatResponse(c, Data{
+		Message: "new source server created",
+		Data: map[string]interface{}{
+			"id":      newSourceServerD.NewServer.ID,
+			"name":    newSourceServerD.NewServer.Name,
+			"api_key": newSourceServerD.APIKey,
+		},
+	}))
+}
+
+func (api *API) authorizeSourceServerMiddleware(c *fiber.Ctx) error {
+	if api.Config.TLS.RequireAgentCert {
+		return api.authorizeSourceServerCert(c)
+	}
+
+	sourceServerName := c.Get("X-Agent1-Name")
+	if strings.TrimSpace(sourceServerName) == "" {
+		log.Default().Println("unauthorized agent1 request. no agent name received")
+		return fiber.NewError(fiber.StatusUnauthorized, "unauthorized request")
+	}
+
+	if c.Get(agentSignatureHeader) != "" {
+		return api.authorizeSignedRequest(c, sourceServerName)
+	}
+	if !api.Config.AgentAuth.AllowAPIKey {
+		log.Default().Printf("unauthorized agent1 request. request of '%s' is not signed and api key authorization is disabled", sourceServerName)
+		return fiber.NewError(fiber.StatusUnauthorized, "unsigned request, api key authorization is disabled")
+	}
+
+	authHeader := c.Get(fiber.HeaderAuthorization)
+	if strings.TrimSpace(authHeader) == "" {
+		log.Default().Println("unauthorized agent1 request. no api key received")
+		return fiber.NewError(fiber.StatusUnauthorized, "unauthorized request")
+	}
+
+	srcsrvManager := sourceserver.NewSrvManager(
+		sourceserver.SrvConfig{
+			SigningKey: api.SigningKey,
+		},
+		sourceserver.NewSrvRepository(api.DB),
+	)
+	srcSrv, err := srcsrvManager.AuthorizeSourceServer(sourceServerName, authHeader)
+	if err != nil {
+		log.Default().Printf("error in authorizing agent request, %s", err.Error())
+		return fiber.NewError(fiber.StatusUnauthorized, "unauthorized request")
+	}
+
+	c.Locals(SrcSrvLocalName, srcSrv)
+	return c.Next()
+}
+
+// authorizeSourceServerCert authorizes agent by its client certificate.
+// agent name and api key are optional, but should match source server of
+// certificate if they are sent
+func (api *API) authorizeSourceServerCert(c *fiber.Ctx) error {
+	state := c.Context().TLSConnectionState()
+	if state == nil || len(state.VerifiedChains) == 0 {
+		log.Default().Println("unauthorized agent1 request. no verified client certificate received")
+		return fiber.NewError(fiber.StatusUnauthorized, "unauthorized request")
+	}
+	cert := state.PeerCertificates[0]
+
+	srcsrvManager := sourceserver.NewSrvManager(
+		sourceserver.SrvConfig{
+			SigningKey: api.SigningKey,
+		},
+		sourceserver.NewSrvRepository(api.DB),
+	)
+	srcSrv, err := srcsrvManager.AuthorizeSourceServerCert(certificateNames(cert), certificateSerial(cert))
+	if err != nil {
+		log.Default().Printf("error in authorizing agent certificate, %s", err.Error())
+		return fiber.NewError(fiber.StatusUnauthorized, "unauthorized request")
+	}
+
+	if sourceServerName := c.Get("X-Agent1-Name"); sourceServerName != "" && sourceServerName != srcSrv.Name {
+		log.Default().Printf("agent name '%s' does not match certificate of source server '%s'", sourceServerName, srcSrv.Name)
+		return fiber.NewError(fiber.StatusUnauthorized, "unauthorized request")
+	}
+	if authHeader := c.Get(fiber.HeaderAuthorization); strings.TrimSpace(authHeader) != "" {
+		if _, err := srcsrvManager.AuthorizeSourceServer(srcSrv.Name, authHeader); err != nil {
+			log.Default().Printf("error in authorizing agent request, %s", err.Error())
+			return fiber.NewError(fiber.StatusUnauthorized, "unauthorized request")
+		}
+	}
+
+	c.Locals(SrcSrvLocalName, srcSrv)
+	return c.Next()
+}
+
+// requestBodyLimitMiddleware rejects requests with big bodies. as request
+// bodies are streamed, this limit is not enforced by fiber itself. upload
+// routes are limited by uploadLimitMiddleware
+func (api *API) requestBodyLimitMiddleware(c *fiber.Ctx) error {
+	if strings.HasPrefix(c.Path(), StoreRoutePrefix) {
+		return c.Next()
+	}
+
+	contentLength := c.Request().Header.ContentLength()
+	if contentLength == -1 {
+		return fiber.NewError(fiber.StatusLengthRequired, "content length is required")
+	}
+	if contentLength > api.Config.Upload.MemoryBodyLimit() {
+		return fiber.NewError(fiber.StatusRequestEntityTooLarge, "request body is too large")
+	}
+
+	return c.Next()
+}
+
+func (api *API) uploadLimitMiddleware(c *fiber.Ctx) error {
+	srcsrvManager := sourceserver.NewSrvManager(
+		sourceserver.SrvConfig{
+			DefaultMaxUpload: api.Config.Upload.MaxUploadSize(),
+		},
+		sourceserver.NewSrvRepository(api.DB),
+	)
+
+	srcsrv := c.Locals(SrcSrvLocalName).(*sourceserver.SourceServer)
+	maxUpload := srcsrvManager.MaxUploadSize(srcsrv)
+
+	// content length of chunked requests is not known, in that case uploaded
+	// file size is checked after parsing
+	contentLength := c.Request().Header.ContentLength()
+	if maxUpload > 0 && int64(contentLength) > maxUpload+multipartOverhead {
+		log.Default().Printf(
+			"upload of source server '%s' rejected. content length: %d, max upload: %d",
+			srcsrv.Name,
+			contentLength,
+			maxUpload,
+		)
+		return fiber.NewError(fiber.StatusRequestEntityTooLarge, xerrors.ErrUploadTooLarge.Error())
+	}
+
+	return c.Next()
+}
+
+func (api *API) getSourceServerLimits(c *fiber.Ctx) error {
+	srcsrvManager := sourceserver.NewSrvManager(
+		sourceserver.SrvConfig{
+			DefaultMaxUpload: api.Config.Upload.MaxUploadSize(),
+		},
+		sourceserver.NewSrvRepository(api.DB),
+	)
+
+	srcsrv := c.Locals(SrcSrvLocalName).(*sourceserver.SourceServer)
+
+	return c.Status(fiber.StatusOK).JSON(FormatResponse(c, Data{
+		Data: map[string]interface{}{
+			"max_upload_size": srcsrvManager.MaxUploadSize(srcsrv),
+		},
+	}))
+}
+
+func (api *API) rotateSrcSrvFile(c *fiber.Ctx) error {
+	var rotateData rotateSrcSrvFile
+	if err := c.BodyParser(&rotateData); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, err.Error())
+	}
+
+	var fileLoadErr error
+	rotateData.File, fileLoadErr = c.FormFile("file")
+	if fileLoadErr != nil {
+		log.Default().Println(fileLoadErr.Error())
+	}
+
+	if errs, ok := validate.ValidateStruct[rotateSrcSrvFile](&rotateData); !ok {
+		log.Default().Println(errs[0].Message)
+		return fiber.NewError(fiber.StatusUnprocessableEntity, errs[0].Message)
+	}
+	metadata, err := rotateData.metadata()
+	if err != nil {
+		return fiber.NewError(fiber.StatusUnprocessableEntity, err.Error())
+	}
+
+	srcsrvManager := sourceserver.NewSrvManager(
+		sourceserver.SrvConfig{
+			CorrelationId:    c.GetRespHeader(fiber.HeaderXRequestID),
+			StoreMode:        api.Config.FileStore.Mode,
+			DiskStoreConfig:  sourceserver.DiskStoreConfig(api.Config.FileStore.DiskConfig),
+			SigningKey:       api.SigningKey,
+			DefaultQuota:     sourceserver.Quota(api.Config.DefaultQuota),
+			DefaultMaxUpload: api.Config.Upload.MaxUploadSize(),
+		},
+		sourceserver.NewSrvRepository(api.DB),
+	)
+
+	srcsrv := c.Locals(SrcSrvLocalName).(*sourceserver.SourceServer)
+
+	err = srcsrvManager.RotateFile(srcsrv, rotateData.Rotate, rotateData.FileName, rotateData.Checksum, rotateData.File, metadata)
+	if err != nil {
+		log.Default().Println("error in file rotation. error:", err.Error())
+		if errors.Is(err, xerrors.ErrFileRotateCountIsLowerThanPreviousOne) ||
+			errors.Is(err, xerrors.ErrRotateGlobalLimitReached) {
+			return fiber.NewError(fiber.StatusConflict, err.Error())
+		}
+		if errors.Is(err, xerrors.ErrChecksumMismatch) {
+			return fiber.NewError(fiber.StatusUnprocessableEntity, err.Error())
+		}
+		if errors.Is(err, xerrors.ErrUploadTooLarge) {
+			return fiber.NewError(fiber.StatusRequestEntityTooLarge, err.Error())
+		}
+		if errors.Is(err, xerrors.ErrStorageQuotaExceeded) ||
+			errors.Is(err, xerrors.ErrSnapshotsQuotaExceeded) {
+			return fiber.NewError(fiber.StatusConflict, err.Error())
+		}
+		return fiber.NewError(fiber.StatusInternalServerError, "internal server error")
+	}
+
+	return c.Status(fiber.StatusOK).JSON(FormatResponse(c, Data{
+		Message: "done",
+	}))
+}
+
+// readSrcSrvSnapshot sends snapshot of file to its own source server, so agent
+// can restore it
+func (api *API) readSrcSrvSnapshot(c *fiber.Ctx) error {
+	params := restoreSnapshotData{}
+	if err := c.QueryParser(&params); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, err.Error())
+	}
+	if errs, ok := validate.ValidateStruct[restoreSnapshotData](&params); !ok {
+		log.Default().Println(errs[0].Message)
+		return fiber.NewError(fiber.StatusUnprocessableEntity, errs[0].Message)
+	}
+
+	srcsrvManager := sourceserver.NewSrvManager(
+		sourceserver.SrvConfig{
+			CorrelationId:   c.GetRespHeader(fiber.HeaderXRequestID),
+			StoreMode:       api.Config.FileStore.Mode,
+			DiskStoreConfig: sourceserver.DiskStoreConfig(api.Config.FileStore.DiskConfig),
+		},
+		sourceserver.NewSrvRepository(api.DB),
+	)
+
+	srcsrv := c.Locals(SrcSrvLocalName).(*sourceserver.SourceServer)
+
+	var at *time.Time
+	if params.At != 0 {
+		atTime := time.UnixMilli(params.At)
+		at = &atTime
+	}
+
+	snapshot, err := srcsrvManager.FindRestoreSnapshot(srcsrv, params.Filename, params.Snapshot, at)
+	if err != nil {
+		if errors.Is(err, xerrors.ErrSnapshotNotFound) ||
+			errors.Is(err, xerrors.ErrNoStoreForSourceServer) ||
+			errors.Is(err, xerrors.ErrNoFileStoredOnSourceServerByThisName) {
+			return fiber.NewError(fiber.StatusNotFound, err.Error())
+		}
+		return fiber.NewError(fiber.StatusInternalServerError, "internal server error")
+	}
+
+	snapshotByte, _, err := srcsrvManager.ReadSnapshot(srcsrv.ID, params.Filename, snapshot.Name)
+	if err != nil {
+		if errors.Is(err, xerrors.ErrSnapshotNotFound) {
+			return fiber.NewError(fiber.StatusNotFound, err.Error())
+		}
+		return fiber.NewError(fiber.StatusInternalServerError, "internal server error")
+	}
+
+	c.Append(fiber.HeaderContentType, "application/octet-stream")
+	c.Append(SnapshotNameHeader, snapshot.Name)
+	c.Append(SnapshotChecksumHeader, snapshot.Checksum)
+	if snapshot.Metadata != nil {
+		if snapshot.Metadata.Mode != nil {
+			c.Append(SnapshotModeHeader, fmt.Sprintf("%04o", *snapshot.Metadata.Mode))
+		}
+		if snapshot.Metadata.Uid != nil {
+			c.Append(SnapshotUidHeader, strconv.Itoa(*snapshot.Metadata.Uid))
+		}
+		if snapshot.Metadata.Gid != nil {
+			c.Append(SnapshotGidHeader, strconv.Itoa(*snapshot.Metadata.Gid))
+		}
+		if snapshot.Metadata.ModTime != nil {
+			c.Append(SnapshotMtimeHeader, strconv.FormatInt(snapshot.Metadata.ModTime.UnixMilli(), 10))
+		}
+	}
+	_, err = c.Status(fiber.StatusOK).Write(*snapshotByte)
+	if err != nil {
+		log.Default().Printf("error in writing file to response, error: %+v", err)
+		return fiber.NewError(fiber.StatusInternalServerError, "internal server error")
+	}
+	return nil
+}
+
+func (api *API) createRestoreJob(c *fiber.Ctx) error {
+	params := downloadSnapshotData{}
+	if err := c.ParamsParser(&params); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, err.Error())
+	}
+	if errs, ok := validate.ValidateStruct[downloadSnapshotData](&params); !ok {
+		log.Default().Println(errs[0].Message)
+		return fiber.NewError(fiber.StatusUnprocessableEntity, errs[0].Message)
+	}
+
+	srcsrvManager := sourceserver.NewSrvManager(
+		sourceserver.SrvConfig{
+			CorrelationId:   c.GetRespHeader(fiber.HeaderXRequestID),
+			StoreMode:       api.Config.FileStore.Mode,
+			DiskStoreConfig: sourceserver.DiskStoreConfig(api.Config.FileStore.DiskConfig),
+		},
+		sourceserver.NewSrvRepository(api.DB),
+	)
+
+	user := c.Locals(UserLocalName).(*auth.User)
+
+	job, err := srcsrvManager.CreateRestoreJob(params.SrvId, params.Filename, params.Snapshot, user.ID)
+	if err != nil {
+		if errors.Is(err, xerrors.ErrRecordNotFound) ||
+			errors.Is(err, xerrors.ErrSnapshotNotFound) ||
+			errors.Is(err, xerrors.ErrNoStoreForSourceServer) ||
+			errors.Is(err, xerrors.ErrNoFileStoredOnSourceServerByThisName) {
+			return fiber.NewError(fiber.StatusNotFound, err.Error())
+		}
+		log.Default().Println("[Unhandled] error for creating restore job", err.Error())
+		return fiber.NewError(fiber.StatusInternalServerError, "internal server error")
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(FormatResponse(c, Data{
+		Message: "restore job created",
+		Data: map[string]interface{}{
+			"job": job,
+		},
+	}))
+}
+
+func (api *API) getListOfRestoreJobs(c *fiber.Ctx) error {
+	params := srvParams{}
+	if err := c.ParamsParser(&params); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, err.Error())
+	}
+	if errs, ok := validate.ValidateStruct[srvParams](&params); !ok {
+		log.Default().Println(errs[0].Message)
+		return fiber.NewError(fiber.StatusUnprocessableEntity, errs[0].Message)
+	}
+
+	var lData listData
+	if err := c.QueryParser(&lData); err != nil {
+		log.Default().Println(err.Error())
+		return fiber.NewError(fiber.StatusBadRequest, err.Error())
+	}
+	if errs, ok := validate.ValidateStruct[listData](&lData); !ok {
+		log.Default().Println(errs[0].Message)
+		return fiber.NewError(fiber.StatusUnprocessableEntity, errs[0].Message)
+	}
+
+	if lData.Start == nil {
+		var initialStart = 0
+		lData.Start = &initialStart
+	}
+	if lData.End == nil {
+		var initialEnd = 10
+		lData.End = &initialEnd
+	}
+
+	srcsrvManager := sourceserver.NewSrvManager(
+		sourceserver.SrvConfig{
+			CorrelationId: c.GetRespHeader(fiber.HeaderXRequestID),
+		},
+		sourceserver.NewSrvRepository(api.DB),
+	)
+
+	jobs, total, err := srcsrvManager.GetListOfRestoreJobs(
+		params.SrvId,
+		sourceserver.FindAllOption{
+			SortBy:    lData.SortBy,
+			SortOrder: lData.SortOrder,
+			Start:     *lData.Start,
+			End:       *lData.End,
+		},
+	)
+	if err != nil {
+		if errors.Is(err, xerrors.ErrRecordNotFound) {
+			return fiber.NewError(fiber.StatusNotFound, err.Error())
+		}
+		return fiber.NewError(fiber.StatusInternalServerError, "internal server error")
+	}
+
+	return c.Status(fiber.StatusOK).JSON(FormatResponse(c, Data{
+		Data: map[string]interface{}{
+			"list":  jobs,
+			"total": total,
+		},
+	}))
+}
+
+func (api *API) nextRestoreJob(c *fiber.Ctx) error {
+	srcsrvManager := sourceserver.NewSrvManager(
+		sourceserver.SrvConfig{
+			CorrelationId: c.GetRespHeader(fiber.HeaderXRequestID),
+		},
+		sourceserver.NewSrvRepository(api.DB),
+	)
+
+	srcsrv := c.Locals(SrcSrvLocalName).(*sourceserver.SourceServer)
+
+	job, err := srcsrvManager.NextRestoreJob(srcsrv)
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "internal server error")
+	}
+
+	return c.Status(fiber.StatusOK).JSON(FormatResponse(c, Data{
+		Data: map[string]interface{}{
+			"job": job,
+		},
+	}))
+}
+
+func (api *API) reportRestoreJob(c *fiber.Ctx) error {
+	params := restoreJobParams{}
+	if err := c.ParamsParser(&params); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, err.Error())
+	}
+	if errs, ok := validate.ValidateStruct[restoreJobParams](&params); !ok {
+		log.Default().Println(errs[0].Message)
+		return fiber.NewError(fiber.StatusUnprocessableEntity, errs[0].Message)
+	}
+
+	var reportData restoreJobReportDto
+	if err := c.BodyParser(&reportData); err != nil {
+		log.Default().Println(err.Error())
+		return fiber.NewError(fiber.StatusBadRequest, err.Error())
+	}
+	if errs, ok := validate.ValidateStruct[restoreJobReportDto](&reportData); !ok {
+		log.Default().Println(errs[0].Message)
+		return fiber.NewError(fiber.StatusUnprocessableEntity, errs[0].Message)
+	}
+
+	srcsrvManager := sourceserver.NewSrvManager(
+		sourceserver.SrvConfig{
+			CorrelationId: c.GetRespHeader(fiber.HeaderXRequestID),
+		},
+		sourceserver.NewSrvRepository(api.DB),
+	)
+
+	srcsrv := c.Locals(SrcSrvLocalName).(*sourceserver.SourceServer)
+
+	job, err := srcsrvManager.ReportRestoreJob(srcsrv, params.JobId, *reportData.Succeeded, reportData.Message)
+	if err != nil {
+		if errors.Is(err, xerrors.ErrRecordNotFound) {
+			return fiber.NewError(fiber.StatusNotFound, err.Error())
+		}
+		if errors.Is(err, xerrors.ErrRestoreJobNotRunning) {
+			return fiber.NewError(fiber.StatusConflict, err.Error())
+		}
+		return fiber.NewError(fiber.StatusInternalServerError, "internal server error")
+	}
+
+	return c.Status(fiber.StatusOK).JSON(FormatResponse(c, Data{
+		Message: "restore job reported",
+		Data: map[string]interface{}{
+			"job": job,
+		},
+	}))
+}
+
+func (api *API) getManagedConfig(c *fiber.Ctx) error {
+	params := srvParams{}
+	if err := c.ParamsParser(&params); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, err.Error())
+	}
+	if errs, ok := validate.ValidateStruct[srvParams](&params); !ok {
+		log.Default().Println(errs[0].Message)
+		return fiber.NewError(fiber.StatusUnprocessableEntity, errs[0].Message)
+	}
+
+	srcsrvManager := sourceserver.NewSrvManager(
+		sourceserver.SrvConfig{
+			CorrelationId: c.GetRespHeader(fiber.HeaderXRequestID),
+		},
+		sourceserver.NewSrvRepository(api.DB),
+	)
+
+	managedConfig, err := srcsrvManager.GetManagedConfig(params.SrvId)
+	if err != nil {
+		if errors.Is(err, xerrors.ErrRecordNotFound) {
+			return fiber.NewError(fiber.StatusNotFound, err.Error())
+		}
+		return fiber.NewError(fiber.StatusInternalServerError, "internal server error")
+	}
+
+	return c.Status(fiber.StatusOK).JSON(FormatResponse(c, Data{
+		Data: map[string]interface{}{
+			"config": managedConfig,
+		},
+	}))
+}
+
+func (api *API) setManagedFiles(c *fiber.Ctx) error {
+	params := srvParams{}
+	if err := c.ParamsParser(&params); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, err.Error())
+	}
+	if errs, ok := validate.ValidateStruct[srvParams](&params); !ok {
+		log.Default().Println(errs[0].Message)
+		return fiber.NewError(fiber.StatusUnprocessableEntity, errs[0].Message)
+	}
+
+	var filesData managedFilesDto
+	if err := c.BodyParser(&filesData); err != nil {
+		log.Default().Println(err.Error())
+		return fiber.NewError(fiber.StatusBadRequest, err.Error())
+	}
+	if errs, ok := validate.ValidateStruct[managedFilesDto](&filesData); !ok {
+		log.Default().Println(errs[0].Message)
+		return fiber.NewError(fiber.StatusUnprocessableEntity, errs[0].Message)
+	}
+
+	srcsrvManager := sourceserver.NewSrvManager(
+		sourceserver.SrvConfig{
+			CorrelationId: c.GetRespHeader(fiber.HeaderXRequestID),
+		},
+		sourceserver.NewSrvRepository(api.DB),
+	)
+
+	user := c.Locals(UserLocalName).(*auth.User)
+
+	managedConfig, err := srcsrvManager.SetManagedFiles(params.SrvId, filesData.Files, user.ID)
+	if err != nil {
+		if errors.Is(err, xerrors.ErrRecordNotFound) {
+			return fiber.NewError(fiber.StatusNotFound, err.Error())
+		}
+		if errors.Is(err, xerrors.ErrInvalidManagedFiles) {
+			return fiber.NewError(fiber.StatusUnprocessableEntity, err.Error())
+		}
+		log.Default().Println("[Unhandled] error for setting managed files", err.Error())
+		return fiber.NewError(fiber.StatusInternalServerError, "internal server error")
+	}
+
+	return c.Status(fiber.StatusOK).JSON(FormatResponse(c, Data{
+		Message: "managed files updated",
+		Data: map[string]interface{}{
+			"config": managedConfig,
+		},
+	}))
+}
+
+func (api *API) setSourceServerGroup(c *fiber.Ctx) error {
+	params := srvParams{}
+	if err := c.ParamsParser(&params); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, err.Error())
+	}
+	if errs, ok := validate.ValidateStruct[srvParams](&params); !ok {
+		log.Default().Println(errs[0].Message)
+		return fiber.NewError(fiber.StatusUnprocessableEntity, errs[0].Message)
+	}
+
+	var groupData srvGroupDto
+	if err := c.BodyParser(&groupData); err != nil {
+		log.Default().Println(err.Error())
+		return fiber.NewError(fiber.StatusBadRequest, err.Error())
+	}
+	if errs, ok := validate.ValidateStruct[srvGroupDto](&groupData); !ok {
+		log.Default().Println(errs[0].Message)
+		return fiber.NewError(fiber.StatusUnprocessableEntity, errs[0].Message)
+	}
+
+	srcsrvManager := sourceserver.NewSrvManager(
+		sourceserver.SrvConfig{
+			CorrelationId: c.GetRespHeader(fiber.HeaderXRequestID),
+		},
+		sourceserver.NewSrvRepository(api.DB),
+	)
+
+	managedConfig, err := srcsrvManager.SetSourceServerGroup(params.SrvId, groupData.Group)
+	if err != nil {
+		if errors.Is(err, xerrors.ErrRecordNotFound) {
+			return fiber.NewError(fiber.StatusNotFound, err.Error())
+		}
+		log.Default().Println("[Unhandled] error for setting source server group", err.Error())
+		return fiber.NewError(fiber.StatusInternalServerError, "internal server error")
+	}
+
+	return c.Status(fiber.StatusOK).JSON(FormatResponse(c, Data{
+		Message: "source server group updated",
+		Data: map[string]interface{}{
+			"config": managedConfig,
+		},
+	}))
+}
+
+func (api *API) getGroupManagedFiles(c *fiber.Ctx) error {
+	params := groupParams{}
+	if err := c.ParamsParser(&params); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, err.Error())
+	}
+	if errs, ok := validate.ValidateStruct[groupParams](&params); !ok {
+		log.Default().Println(errs[0].Message)
+		return fiber.NewError(fiber.StatusUnprocessableEntity, errs[0].Message)
+	}
+
+	srcsrvManager := sourceserver.NewSrvManager(
+		sourceserver.SrvConfig{
+			CorrelationId: c.GetRespHeader(fiber.HeaderXRequestID),
+		},
+		sourceserver.NewSrvRepository(api.DB),
+	)
+
+	files, err := srcsrvManager.GetGroupManagedFiles(params.Group)
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "internal server error")
+	}
+
+	return c.Status(fiber.StatusOK).JSON(FormatResponse(c, Data{
+		Data: map[string]interface{}{
+			"group": params.Group,
+			"files": files,
+		},
+	}))
+}
+
+func (api *API) setGroupManagedFiles(c *fiber.Ctx) error {
+	params := groupParams{}
+	if err := c.ParamsParser(&params); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, err.Error())
+	}
+	if errs, ok := validate.ValidateStruct[groupParams](&params); !ok {
+		log.Default().Println(errs[0].Message)
+		return fiber.NewError(fiber.StatusUnprocessableEntity, errs[0].Message)
+	}
+
+	var filesData managedFilesDto
+	if err := c.BodyParser(&filesData); err != nil {
+		log.Default().Println(err.Error())
+		return fiber.NewError(fiber.StatusBadRequest, err.Error())
+	}
+	if errs, ok := validate.ValidateStruct[managedFilesDto](&filesData); !ok {
+		log.Default().Println(errs[0].Message)
+		return fiber.NewError(fiber.StatusUnprocessableEntity, errs[0].Message)
+	}
+
+	srcsrvManager := sourceserver.NewSrvManager(
+		sourceserver.SrvConfig{
+			CorrelationId: c.GetRespHeader(fiber.HeaderXRequestID),
+		},
+		sourceserver.NewSrvRepository(api.DB),
+	)
+
+	user := c.Locals(UserLocalName).(*auth.User)
+
+	files, err := srcsrvManager.SetGroupManagedFiles(params.Group, filesData.Files, user.ID)
+	if err != nil {
+		if errors.Is(err, xerrors.ErrInvalidManagedFiles) {
+			return fiber.NewError(fiber.StatusUnprocessableEntity, err.Error())
+		}
+		log.Default().Println("[Unhandled] error for setting group managed files", err.Error())
+		return fiber.NewError(fiber.StatusInternalServerError, "internal server error")
+	}
+
+	return c.Status(fiber.StatusOK).JSON(FormatResponse(c, Data{
+		Message: "group managed files updated",
+		Data: map[string]interface{}{
+			"group": params.Group,
+			"files": files,
+		},
+	}))
+}
+
+func (api *API) getAgentManagedConfig(c *fiber.Ctx) error {
+	query := agentConfigQuery{}
+	if err := c.QueryParser(&query); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, err.Error())
+	}
+	if errs, ok := validate.ValidateStruct[agentConfigQuery](&query); !ok {
+		log.Default().Println(errs[0].Message)
+		return fiber.NewError(fiber.StatusUnprocessableEntity, errs[0].Message)
+	}
+
+	srcsrvManager := sourceserver.NewSrvManager(
+		sourceserver.SrvConfig{
+			CorrelationId: c.GetRespHeader(fiber.HeaderXRequestID),
+		},
+		sourceserver.NewSrvRepository(api.DB),
+	)
+
+	srcsrv := c.Locals(SrcSrvLocalName).(*sourceserver.SourceServer)
+
+	managedConfig, err := srcsrvManager.AgentManagedConfig(srcsrv, query.Revision)
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "internal server error")
+	}
+
+	return c.Status(fiber.StatusOK).JSON(FormatResponse(c, Data{
+		Data: map[string]interface{}{
+			"revision": managedConfig.Revision,
+			"files":    managedConfig.EffectiveFiles,
+		},
+	}))
+}
+
+func (api *API) receiveHeartbeat(c *fiber.Ctx) error {
+	var heartbeatData heartbeatDto
+	if err := c.BodyParser(&heartbeatData); err != nil {
+		log.Default().Println(err.Error())
+		return fiber.NewError(fiber.StatusBadRequest, err.Error())
+	}
+	if errs, ok := validate.ValidateStruct[heartbeatDto](&heartbeatData); !ok {
+		log.Default().Println(errs[0].Message)
+		return fiber.NewError(fiber.StatusUnprocessableEntity, errs[0].Message)
+	}
+
+	srcsrvManager := sourceserver.NewSrvManager(
+		sourceserver.SrvConfig{
+			CorrelationId: c.GetRespHeader(fiber.HeaderXRequestID),
+		},
+		sourceserver.NewSrvRepository(api.DB),
+	)
+
+	srcsrv := c.Locals(SrcSrvLocalName).(*sourceserver.SourceServer)
+
+	err := srcsrvManager.RecordHeartbeat(srcsrv, sourceserver.Heartbeat{
+		Version:        heartbeatData.Version,
+		Hostname:       heartbeatData.Hostname,
+		OS:             heartbeatData.OS,
+		Arch:           heartbeatData.Arch,
+		ConfigRevision: heartbeatData.ConfigRevision,
+		PendingUploads: heartbeatData.PendingUploads,
+		RunningUploads: heartbeatData.RunningUploads,
+	}, heartbeatData.Files)
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "internal server error")
+	}
+
+	return c.Status(fiber.StatusOK).JSON(FormatResponse(c, Data{
+		Message: "heartbeat received",
+	}))
+}
+
+func (api *API) getSourceServerHeartbeat(c *fiber.Ctx) error {
+	params := srvParams{}
+	if err := c.ParamsParser(&params); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, err.Error())
+	}
+	if errs, ok := validate.ValidateStruct[srvParams](&params); !ok {
+		log.Default().Println(errs[0].Message)
+		return fiber.NewError(fiber.StatusUnprocessableEntity, errs[0].Message)
+	}
+
+	srcsrvManager := sourceserver.NewSrvManager(
+		sourceserver.SrvConfig{
+			CorrelationId:     c.GetRespHeader(fiber.HeaderXRequestID),
+			AgentOfflineAfter: api.Config.AgentOfflineAfter,
+		},
+		sourceserver.NewSrvRepository(api.DB),
+	)
+
+	heartbeat, err := srcsrvManager.GetHeartbeat(params.SrvId)
+	if err != nil {
+		if errors.Is(err, xerrors.ErrRecordNotFound) {
+			return fiber.NewError(fiber.StatusNotFound, "no heartbeat received from source server")
+		}
+		return fiber.NewError(fiber.StatusInternalServerError, "internal server error")
+	}
+
+	return c.Status(fiber.StatusOK).JSON(FormatResponse(c, Data{
+		Data: map[string]interface{}{
+			"heartbeat": heartbeat,
+		},
+	}))
+}
+
+func (api *API) getCorruptedSnapshots(c *fiber.Ctx) error {
+	srcsrvManager := sourceserver.NewSrvManager(
+		sourceserver.SrvConfig{
+			CorrelationId:   c.GetRespHeader(fiber.HeaderXRequestID),
+			StoreMode:       api.Config.FileStore.Mode,
+			DiskStoreConfig: sourceserver.DiskStoreConfig(api.Config.FileStore.DiskConfig),
+		},
+		sourceserver.NewSrvRepository(api.DB),
+	)
+
+	mismatches, err := srcsrvManager.GetListOfCorruptedSnapshots()
+	if err != nil {
+		log.Default().Println("[Unhandled] error for finding corrupted snapshots", err.Error())
+		return fiber.NewError(fiber.StatusInternalServerError, "internal server error")
+	}
+
+	return c.Status(fiber.StatusOK).JSON(FormatResponse(c, Data{
+		Data: map[string]interface{}{
+			"list":        mismatches,
+			"total":       len(*mismatches),
+			"last_report": lastIntegrityReport(),
+		},
 	}))
 }
 
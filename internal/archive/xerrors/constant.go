@@ -21,4 +21,15 @@ var (
 	ErrSnapshotNotFound                      = errors.New("unable to locate this snapshot")
 	ErrUserInitialPasswordHasBeenChanged     = errors.New("user initial password has been changed")
 	ErrToTimeShouldBeAfterFromTime           = errors.New("to time should be after from time")
+	ErrStorageQuotaExceeded                  = errors.New("source server storage quota exceeded")
+	ErrSnapshotsQuotaExceeded                = errors.New("source server snapshots count quota exceeded")
+	ErrUploadTooLarge                        = errors.New("uploaded file is larger than max upload size of source server")
+	ErrChecksumMismatch                      = errors.New("received checksum does not match file content")
+	ErrRestoreJobNotRunning                  = errors.New("restore job is not running")
+	ErrInvalidManagedFiles                   = errors.New("invalid managed files")
+	ErrInvalidEnrollmentToken                = errors.New("enrollment token is invalid, expired or used up")
+	ErrAdminCanNotBeDisabled                 = errors.New("admin user can not be disabled")
+	ErrSourceServerUnderLegalHold            = errors.New("source server is under legal hold")
+	ErrInvalidSearchPattern                  = errors.New("search pattern is not a valid glob pattern")
+	ErrSearchQueryTooShort                   = errors.New("search query should contain a word of at least 2 letters or digits")
 )
@@ -0,0 +1,289 @@
+package client
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// envelope is the format of archivo server responses
+type envelope struct {
+	TrackId string          `json:"track_id"`
+	Error   bool            `json:"error"`
+	Message string          `json:"message"`
+	Data    json.RawMessage `json:"data"`
+}
+
+type list struct {
+	List  json.RawMessage `json:"list"`
+	Total int             `json:"total"`
+}
+
+type user struct {
+	ID                    uint   `json:"id"`
+	Username              string `json:"username"`
+	Email                 string `json:"email"`
+	IsAdmin               bool   `json:"is_admin"`
+	ChangeInitialPassword bool   `json:"change_initial_password"`
+}
+
+type sourceServer struct {
+	ID            uint   `json:"id"`
+	Name          string `json:"name"`
+	Group         string `json:"group"`
+	LegalHold     bool   `json:"legal_hold"`
+	UsedBytes     int64  `json:"used_bytes"`
+	UsedSnapshots int    `json:"used_snapshots"`
+	Agent         struct {
+		Online     bool       `json:"online"`
+		Flagged    bool       `json:"flagged"`
+		LastSeenAt *time.Time `json:"last_seen_at"`
+	} `json:"agent"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type file struct {
+	FileName  string    `json:"filename"`
+	Snapshots int       `json:"snapshots"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+type snapshot struct {
+	Name      string `json:"name"`
+	Size      string `json:"size"`
+	ByteSize  int64  `json:"byte_size"`
+	Checksum  string `json:"checksum"`
+	Corrupted bool   `json:"corrupted"`
+	Pinned    bool   `json:"pinned"`
+	Metadata  *struct {
+		SourcePath string `json:"source_path"`
+		Host       string `json:"host"`
+	} `json:"metadata"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+const (
+	// servers are listed page by page
+	serversPageSize = 100
+	// files and snapshots of a source server are listed at once
+	maxListEnd = 1000000
+)
+
+// apiClient requests dashboard api of archivo server, as user of session
+type apiClient struct {
+	session *session
+	http    *http.Client
+}
+
+func newAPIClient(s *session, caFile string) (*apiClient, error) {
+	if s.Server == "" {
+		return nil, fmt.Errorf("archivo server is not set, login first or pass --server")
+	}
+	if caFile == "" {
+		return &apiClient{session: s, http: &http.Client{}}, nil
+	}
+
+	caPEM, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(caPEM) {
+		return nil, fmt.Errorf("no certificate found in ca file '%s'", caFile)
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &tls.Config{MinVersion: tls.VersionTLS12, RootCAs: pool}
+	return &apiClient{session: s, http: &http.Client{Transport: transport}}, nil
+}
+
+// do sends request to api path of server. responses other than 2xx are
+// returned as error with message of server
+func (ac *apiClient) do(method, path string, query url.Values, body io.Reader, contentType string) (*http.Response, error) {
+	requestUrl := strings.TrimSuffix(ac.session.Server, "/") + "/api/v1" + path
+	if len(query) > 0 {
+		requestUrl += "?" + query.Encode()
+	}
+	req, err := http.NewRequest(method, requestUrl, body)
+	if err != nil {
+		return nil, err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if ac.session.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+ac.session.Token)
+	}
+	for _, cookie := range ac.session.Cookies {
+		req.AddCookie(&http.Cookie{Name: cookie.Name, Value: cookie.Value})
+	}
+
+	res, err := ac.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode >= 200 && res.StatusCode < 300 {
+		return res, nil
+	}
+
+	defer res.Body.Close()
+	var env envelope
+	resBody, _ := io.ReadAll(res.Body)
+	if err := json.Unmarshal(resBody, &env); err != nil || env.Message == "" {
+		return nil, fmt.Errorf("non 2xx status code %d received. response: %s", res.StatusCode, resBody)
+	}
+	if res.StatusCode == http.StatusUnauthorized {
+		return nil, fmt.Errorf("%s, login again or check your token. track-id: '%s'", env.Message, env.TrackId)
+	}
+	return nil, fmt.Errorf("%s. status: %d, track-id: '%s'", env.Message, res.StatusCode, env.TrackId)
+}
+
+// data requests path and decodes data of its response into out
+func (ac *apiClient) data(method, path string, query url.Values, body interface{}, out interface{}) error {
+	var reqBody io.Reader
+	contentType := ""
+	if body != nil {
+		bodyBytes, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reqBody = strings.NewReader(string(bodyBytes))
+		contentType = "application/json"
+	}
+
+	res, err := ac.do(method, path, query, reqBody, contentType)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	var env envelope
+	if err := json.NewDecoder(res.Body).Decode(&env); err != nil {
+		return err
+	}
+	if out == nil {
+		return nil
+	}
+	return json.Unmarshal(env.Data, out)
+}
+
+func (ac *apiClient) me() (*user, error) {
+	var data struct {
+		User user `json:"user"`
+	}
+	if err := ac.data(http.MethodGet, "/auth/me", nil, nil, &data); err != nil {
+		return nil, err
+	}
+	return &data.User, nil
+}
+
+// sourceServers lists all source servers. raw is the list as server returns
+// it, for json output
+func (ac *apiClient) sourceServers() ([]sourceServer, []json.RawMessage, error) {
+	servers := []sourceServer{}
+	raw := []json.RawMessage{}
+	for {
+		query := url.Values{
+			"sort_by":    {"id"},
+			"sort_order": {"ASC"},
+			"start":      {fmt.Sprint(len(servers))},
+			"end":        {fmt.Sprint(serversPageSize)},
+		}
+		var page list
+		if err := ac.data(http.MethodGet, "/servers", query, nil, &page); err != nil {
+			return nil, nil, err
+		}
+		var pageServers []sourceServer
+		var pageRaw []json.RawMessage
+		if err := json.Unmarshal(page.List, &pageServers); err != nil {
+			return nil, nil, err
+		}
+		if err := json.Unmarshal(page.List, &pageRaw); err != nil {
+			return nil, nil, err
+		}
+		servers = append(servers, pageServers...)
+		raw = append(raw, pageRaw...)
+		if len(pageServers) == 0 || len(servers) >= page.Total {
+			return servers, raw, nil
+		}
+	}
+}
+
+// sourceServer finds source server by its name or id
+func (ac *apiClient) sourceServer(nameOrId string) (*sourceServer, error) {
+	servers, _, err := ac.sourceServers()
+	if err != nil {
+		return nil, err
+	}
+	for _, srv := range servers {
+		if srv.Name == nameOrId {
+			return &srv, nil
+		}
+	}
+	for _, srv := range servers {
+		if fmt.Sprint(srv.ID) == nameOrId {
+			return &srv, nil
+		}
+	}
+	return nil, fmt.Errorf("no source server by name or id '%s' found", nameOrId)
+}
+
+func (ac *apiClient) files(srv *sourceServer) ([]file, json.RawMessage, error) {
+	query := url.Values{
+		"sort_by":    {"filename"},
+		"sort_order": {"ASC"},
+		"start":      {"0"},
+		"end":        {fmt.Sprint(maxListEnd)},
+	}
+	var data list
+	if err := ac.data(http.MethodGet, fmt.Sprintf("/servers/%d/files", srv.ID), query, nil, &data); err != nil {
+		return nil, nil, err
+	}
+	files := []file{}
+	if err := json.Unmarshal(data.List, &files); err != nil {
+		return nil, nil, err
+	}
+	return files, data.List, nil
+}
+
+func (ac *apiClient) snapshots(srv *sourceServer, filename string) ([]snapshot, json.RawMessage, error) {
+	query := url.Values{
+		"sort_by":    {"name"},
+		"sort_order": {"ASC"},
+		"start":      {"0"},
+		"end":        {fmt.Sprint(maxListEnd)},
+	}
+	var data list
+	if err := ac.data(http.MethodGet, fmt.Sprintf("/servers/%d/files/%s", srv.ID, url.PathEscape(filename)), query, nil, &data); err != nil {
+		return nil, nil, err
+	}
+	snapshots := []snapshot{}
+	if err := json.Unmarshal(data.List, &snapshots); err != nil {
+		return nil, nil, err
+	}
+	return snapshots, data.List, nil
+}
+
+// download streams content of snapshot to w
+func (ac *apiClient) download(srv *sourceServer, filename, snapshotName string, w io.Writer) error {
+	res, err := ac.do(
+		http.MethodGet,
+		fmt.Sprintf("/servers/%d/files/%s/%s/download", srv.ID, url.PathEscape(filename), url.PathEscape(snapshotName)),
+		nil,
+		nil,
+		"",
+	)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	_, err = io.Copy(w, res.Body)
+	return err
+}
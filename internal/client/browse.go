@@ -0,0 +1,369 @@
+package client
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/pmezard/go-difflib/difflib"
+	"github.com/spf13/cobra"
+)
+
+const latestSnapshot = "latest"
+
+// printOutput prints value as json or rows as table, by output flag
+func printOutput(cmd *cobra.Command, value interface{}, header []string, rows [][]string) {
+	if commandOutput(cmd) == outputJSON {
+		if raw, ok := value.(json.RawMessage); ok && (len(raw) == 0 || string(raw) == "null") {
+			value = []interface{}{}
+		}
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(value); err != nil {
+			log.Fatalln(err.Error())
+		}
+		return
+	}
+
+	writer := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(writer, strings.Join(header, "\t"))
+	for _, row := range rows {
+		fmt.Fprintln(writer, strings.Join(row, "\t"))
+	}
+	writer.Flush()
+}
+
+func formatTime(t *time.Time) string {
+	if t == nil || t.IsZero() {
+		return "-"
+	}
+	return t.Format(time.RFC3339)
+}
+
+func formatBool(b bool) string {
+	if b {
+		return "yes"
+	}
+	return "no"
+}
+
+// findSnapshot finds snapshot of file by its name. latest is the snapshot
+// which is created last
+func findSnapshot(client *apiClient, srv *sourceServer, filename, name string) (*snapshot, error) {
+	snapshots, _, err := client.snapshots(srv, filename)
+	if err != nil {
+		return nil, err
+	}
+	if len(snapshots) == 0 {
+		return nil, fmt.Errorf("no snapshot of file '%s' found on source server '%s'", filename, srv.Name)
+	}
+
+	if name == latestSnapshot {
+		sort.SliceStable(snapshots, func(i, j int) bool {
+			return snapshots[i].CreatedAt.After(snapshots[j].CreatedAt)
+		})
+		return &snapshots[0], nil
+	}
+	for _, s := range snapshots {
+		if s.Name == name {
+			return &s, nil
+		}
+	}
+	return nil, fmt.Errorf("no snapshot by name '%s' found for file '%s' of source server '%s'", name, filename, srv.Name)
+}
+
+// verifyChecksum compares sha256 of downloaded content with checksum which
+// server recorded for snapshot
+func verifyChecksum(s *snapshot, sum []byte) error {
+	if s.Checksum == "" {
+		return nil
+	}
+	if !strings.EqualFold(s.Checksum, hex.EncodeToString(sum)) {
+		return fmt.Errorf("checksum of downloaded snapshot '%s' does not match, expected '%s' got '%s'", s.Name, s.Checksum, hex.EncodeToString(sum))
+	}
+	return nil
+}
+
+func snapshotContent(client *apiClient, srv *sourceServer, filename string, s *snapshot) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := client.download(srv, filename, s.Name, &buf); err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(buf.Bytes())
+	if err := verifyChecksum(s, sum[:]); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+var serversCmd = &cobra.Command{
+	Use:   "servers",
+	Short: "List source servers",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, _ []string) {
+		client := commandClient(cmd)
+		servers, raw, err := client.sourceServers()
+		if err != nil {
+			log.Fatalln("error in listing source servers.", err.Error())
+		}
+		sort.SliceStable(servers, func(i, j int) bool {
+			return servers[i].Name < servers[j].Name
+		})
+
+		rows := [][]string{}
+		for _, srv := range servers {
+			rows = append(rows, []string{
+				fmt.Sprint(srv.ID),
+				srv.Name,
+				srv.Group,
+				formatBool(srv.Agent.Online),
+				formatTime(srv.Agent.LastSeenAt),
+				fmt.Sprint(srv.UsedSnapshots),
+				formatBool(srv.LegalHold),
+			})
+		}
+		printOutput(cmd, raw, []string{"ID", "NAME", "GROUP", "ONLINE", "LAST SEEN", "SNAPSHOTS", "LEGAL HOLD"}, rows)
+	},
+}
+
+var filesCmd = &cobra.Command{
+	Use:   "files <server>",
+	Short: "List files of source server, server is name or id of it",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		client := commandClient(cmd)
+		srv, err := client.sourceServer(args[0])
+		if err != nil {
+			log.Fatalln(err.Error())
+		}
+		files, raw, err := client.files(srv)
+		if err != nil {
+			log.Fatalln("error in listing files.", err.Error())
+		}
+		sort.SliceStable(files, func(i, j int) bool {
+			return files[i].FileName < files[j].FileName
+		})
+
+		rows := [][]string{}
+		for _, f := range files {
+			rows = append(rows, []string{f.FileName, fmt.Sprint(f.Snapshots), formatTime(&f.UpdatedAt)})
+		}
+		printOutput(cmd, raw, []string{"FILENAME", "SNAPSHOTS", "UPDATED AT"}, rows)
+	},
+}
+
+var snapshotsCmd = &cobra.Command{
+	Use:   "snapshots <server> <file>",
+	Short: "List snapshots of file of source server",
+	Args:  cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		client := commandClient(cmd)
+		srv, err := client.sourceServer(args[0])
+		if err != nil {
+			log.Fatalln(err.Error())
+		}
+		snapshots, raw, err := client.snapshots(srv, args[1])
+		if err != nil {
+			log.Fatalln("error in listing snapshots.", err.Error())
+		}
+		sort.SliceStable(snapshots, func(i, j int) bool {
+			return snapshots[i].CreatedAt.Before(snapshots[j].CreatedAt)
+		})
+
+		rows := [][]string{}
+		for _, s := range snapshots {
+			sourcePath := "-"
+			if s.Metadata != nil && s.Metadata.SourcePath != "" {
+				sourcePath = s.Metadata.SourcePath
+			}
+			rows = append(rows, []string{
+				s.Name,
+				s.Size,
+				formatTime(&s.CreatedAt),
+				formatBool(s.Pinned),
+				formatBool(s.Corrupted),
+				sourcePath,
+			})
+		}
+		printOutput(cmd, raw, []string{"NAME", "SIZE", "CREATED AT", "PINNED", "CORRUPTED", "SOURCE PATH"}, rows)
+	},
+}
+
+var downloadCmd = &cobra.Command{
+	Use:   "download <server> <file> [snapshot]",
+	Short: "Download snapshot of file, the latest snapshot if no snapshot is given",
+	Args:  cobra.RangeArgs(2, 3),
+	Run: func(cmd *cobra.Command, args []string) {
+		out, err := cmd.Flags().GetString("out")
+		if err != nil {
+			log.Fatalln(err.Error())
+		}
+		force, err := cmd.Flags().GetBool("force")
+		if err != nil {
+			log.Fatalln(err.Error())
+		}
+		snapshotName := latestSnapshot
+		if len(args) == 3 {
+			snapshotName = args[2]
+		}
+
+		client := commandClient(cmd)
+		srv, err := client.sourceServer(args[0])
+		if err != nil {
+			log.Fatalln(err.Error())
+		}
+		s, err := findSnapshot(client, srv, args[1], snapshotName)
+		if err != nil {
+			log.Fatalln(err.Error())
+		}
+
+		hash := sha256.New()
+		if out == "-" {
+			if err := client.download(srv, args[1], s.Name, io.MultiWriter(os.Stdout, hash)); err != nil {
+				log.Fatalln("error in downloading snapshot.", err.Error())
+			}
+			if err := verifyChecksum(s, hash.Sum(nil)); err != nil {
+				log.Fatalln(err.Error())
+			}
+			return
+		}
+
+		if out == "" {
+			out = filepath.Base(args[1])
+		}
+		if _, err := os.Stat(out); err == nil && !force {
+			log.Fatalf("'%s' already exists, pass --force to overwrite it\n", out)
+		}
+
+		// snapshot is written to a temporary file next to destination and
+		// moved to it only after its checksum is verified
+		tmp, err := os.CreateTemp(filepath.Dir(out), ".archivo-cli-*")
+		if err != nil {
+			log.Fatalln(err.Error())
+		}
+		defer os.Remove(tmp.Name())
+		err = client.download(srv, args[1], s.Name, io.MultiWriter(tmp, hash))
+		if closeErr := tmp.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			log.Fatalln("error in downloading snapshot.", err.Error())
+		}
+		if err := verifyChecksum(s, hash.Sum(nil)); err != nil {
+			log.Fatalln(err.Error())
+		}
+		if err := os.Rename(tmp.Name(), out); err != nil {
+			log.Fatalln(err.Error())
+		}
+
+		result := map[string]interface{}{
+			"server":   srv.Name,
+			"filename": args[1],
+			"snapshot": s.Name,
+			"checksum": s.Checksum,
+			"path":     out,
+		}
+		if commandOutput(cmd) == outputJSON {
+			printOutput(cmd, result, nil, nil)
+			return
+		}
+		fmt.Printf("snapshot '%s' of '%s' is downloaded to '%s'\n", s.Name, args[1], out)
+	},
+}
+
+var diffCmd = &cobra.Command{
+	Use:   "diff <server> <file> <snapshot> [other-snapshot]",
+	Short: "Show unified diff of two snapshots of file. other snapshot is the latest one if it is not given",
+	Args:  cobra.RangeArgs(3, 4),
+	Run: func(cmd *cobra.Command, args []string) {
+		local, err := cmd.Flags().GetString("local")
+		if err != nil {
+			log.Fatalln(err.Error())
+		}
+		if local != "" && len(args) == 4 {
+			log.Fatalln("other snapshot and --local can not be used together")
+		}
+
+		client := commandClient(cmd)
+		srv, err := client.sourceServer(args[0])
+		if err != nil {
+			log.Fatalln(err.Error())
+		}
+		from, err := findSnapshot(client, srv, args[1], args[2])
+		if err != nil {
+			log.Fatalln(err.Error())
+		}
+		fromContent, err := snapshotContent(client, srv, args[1], from)
+		if err != nil {
+			log.Fatalln("error in downloading snapshot.", err.Error())
+		}
+
+		var toName string
+		var toContent []byte
+		if local != "" {
+			toName = local
+			toContent, err = os.ReadFile(local)
+			if err != nil {
+				log.Fatalln(err.Error())
+			}
+		} else {
+			otherName := latestSnapshot
+			if len(args) == 4 {
+				otherName = args[3]
+			}
+			to, err := findSnapshot(client, srv, args[1], otherName)
+			if err != nil {
+				log.Fatalln(err.Error())
+			}
+			toName = to.Name
+			toContent, err = snapshotContent(client, srv, args[1], to)
+			if err != nil {
+				log.Fatalln("error in downloading snapshot.", err.Error())
+			}
+		}
+
+		identical := bytes.Equal(fromContent, toContent)
+		diff := ""
+		if !identical {
+			if bytes.IndexByte(fromContent, 0) != -1 || bytes.IndexByte(toContent, 0) != -1 {
+				diff = fmt.Sprintf("binary contents of '%s' and '%s' differ\n", from.Name, toName)
+			} else {
+				diff, err = difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+					A:        difflib.SplitLines(string(fromContent)),
+					B:        difflib.SplitLines(string(toContent)),
+					FromFile: from.Name,
+					ToFile:   toName,
+					Context:  3,
+				})
+				if err != nil {
+					log.Fatalln(err.Error())
+				}
+			}
+		}
+
+		if commandOutput(cmd) == outputJSON {
+			printOutput(cmd, map[string]interface{}{
+				"from":      from.Name,
+				"to":        toName,
+				"identical": identical,
+				"diff":      diff,
+			}, nil, nil)
+			return
+		}
+		if identical {
+			fmt.Printf("'%s' and '%s' are identical\n", from.Name, toName)
+			return
+		}
+		fmt.Print(diff)
+	},
+}
@@ -0,0 +1,231 @@
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/mitchellh/go-homedir"
+	"github.com/spf13/cobra"
+)
+
+const (
+	outputTable = "table"
+	outputJSON  = "json"
+)
+
+type sessionCookie struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// session is what client is logged in archivo server by. it is either a
+// personal access token or cookies of a dashboard session
+type session struct {
+	Server  string          `json:"server"`
+	Token   string          `json:"token,omitempty"`
+	Cookies []sessionCookie `json:"cookies,omitempty"`
+}
+
+func sessionPath(cmd *cobra.Command) string {
+	path, err := cmd.Flags().GetString("session")
+	if err != nil {
+		log.Fatalln(err.Error())
+	}
+	if strings.TrimSpace(path) != "" {
+		return path
+	}
+	home, err := homedir.Dir()
+	if err != nil {
+		log.Fatalln(err)
+	}
+	return filepath.Join(home, ".archivo-cli.json")
+}
+
+// loadSession reads saved session. server and token of flags or environment
+// override the saved ones
+func loadSession(cmd *cobra.Command) *session {
+	s := &session{}
+	sessionBytes, err := os.ReadFile(sessionPath(cmd))
+	if err != nil && !os.IsNotExist(err) {
+		log.Fatalln("error in reading session.", err.Error())
+	}
+	if err == nil {
+		if err := json.Unmarshal(sessionBytes, s); err != nil {
+			log.Fatalln("error in parsing session.", err.Error())
+		}
+	}
+
+	server, token := serverAndToken(cmd)
+	if server != "" && server != s.Server {
+		// saved session belongs to another server
+		s = &session{Server: server}
+	}
+	if token != "" {
+		s.Token = token
+		s.Cookies = nil
+	}
+	return s
+}
+
+func saveSession(cmd *cobra.Command, s *session) {
+	sessionBytes, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		log.Fatalln(err.Error())
+	}
+	// session is a credential, so only its owner can read it
+	if err := os.WriteFile(sessionPath(cmd), sessionBytes, 0600); err != nil {
+		log.Fatalln("error in saving session.", err.Error())
+	}
+}
+
+func serverAndToken(cmd *cobra.Command) (string, string) {
+	server, err := cmd.Flags().GetString("server")
+	if err != nil {
+		log.Fatalln(err.Error())
+	}
+	token, err := cmd.Flags().GetString("token")
+	if err != nil {
+		log.Fatalln(err.Error())
+	}
+	if server == "" {
+		server = os.Getenv("ARCHIVO_SERVER")
+	}
+	if token == "" {
+		token = os.Getenv("ARCHIVO_TOKEN")
+	}
+	return strings.TrimSuffix(server, "/"), token
+}
+
+func commandClient(cmd *cobra.Command) *apiClient {
+	// output is checked before anything is requested
+	commandOutput(cmd)
+	caFile, err := cmd.Flags().GetString("ca-file")
+	if err != nil {
+		log.Fatalln(err.Error())
+	}
+	client, err := newAPIClient(loadSession(cmd), caFile)
+	if err != nil {
+		log.Fatalln(err.Error())
+	}
+	return client
+}
+
+func commandOutput(cmd *cobra.Command) string {
+	output, err := cmd.Flags().GetString("output")
+	if err != nil {
+		log.Fatalln(err.Error())
+	}
+	if output != outputTable && output != outputJSON {
+		log.Fatalf("output should be one of '%s' or '%s'\n", outputTable, outputJSON)
+	}
+	return output
+}
+
+// readPassword reads password from environment or a line of stdin
+func readPassword() string {
+	if password := os.Getenv("ARCHIVO_PASSWORD"); password != "" {
+		return password
+	}
+	fmt.Fprint(os.Stderr, "password: ")
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && line == "" {
+		log.Fatalln("error in reading password.", err.Error())
+	}
+	return strings.TrimRight(line, "\r\n")
+}
+
+var loginCmd = &cobra.Command{
+	Use:   "login",
+	Short: "Login to archivo server by email and password, or by a personal access token",
+	Run: func(cmd *cobra.Command, _ []string) {
+		email, err := cmd.Flags().GetString("email")
+		if err != nil {
+			log.Fatalln(err.Error())
+		}
+		client := commandClient(cmd)
+		if client.session.Token == "" && email == "" {
+			log.Fatalln("one of email or token is required")
+		}
+
+		if client.session.Token == "" {
+			client.session.Cookies = nil
+			body, err := json.Marshal(map[string]string{"email": email, "password": readPassword()})
+			if err != nil {
+				log.Fatalln(err.Error())
+			}
+			res, err := client.do(http.MethodPost, "/auth/login", nil, bytes.NewReader(body), "application/json")
+			if err != nil {
+				log.Fatalln("error in login.", err.Error())
+			}
+			res.Body.Close()
+			for _, cookie := range res.Cookies() {
+				client.session.Cookies = append(client.session.Cookies, sessionCookie{Name: cookie.Name, Value: cookie.Value})
+			}
+		}
+
+		me, err := client.me()
+		if err != nil {
+			log.Fatalln("error in login.", err.Error())
+		}
+		if me.ChangeInitialPassword {
+			log.Fatalln("initial password should be changed on dashboard first")
+		}
+		saveSession(cmd, client.session)
+		fmt.Printf("logged in '%s' as '%s'\n", client.session.Server, me.Username)
+	},
+}
+
+var logoutCmd = &cobra.Command{
+	Use:   "logout",
+	Short: "Logout from archivo server and remove saved session",
+	Run: func(cmd *cobra.Command, _ []string) {
+		client := commandClient(cmd)
+		if len(client.session.Cookies) > 0 {
+			if err := client.data(http.MethodPost, "/auth/logout", nil, nil, nil); err != nil {
+				log.Default().Println("error in logout.", err.Error())
+			}
+		}
+		if err := os.Remove(sessionPath(cmd)); err != nil && !os.IsNotExist(err) {
+			log.Fatalln("error in removing session.", err.Error())
+		}
+		fmt.Println("logged out")
+	},
+}
+
+var cliCmd = &cobra.Command{
+	Use:   "archivo-cli",
+	Short: "Browse and download snapshots of Archivo server",
+}
+
+func init() {
+	cliCmd.PersistentFlags().StringP("server", "s", "", "url of archivo server, e.g. https://archivo.example.com (default is the one of saved session or $ARCHIVO_SERVER)")
+	cliCmd.PersistentFlags().String("token", "", "personal access token, which is issued by 'archivo user token' (default is $ARCHIVO_TOKEN)")
+	cliCmd.PersistentFlags().String("ca-file", "", "ca certificate which archivo server certificate is verified by")
+	cliCmd.PersistentFlags().String("session", "", "path of saved session (default is $HOME/.archivo-cli.json)")
+	cliCmd.PersistentFlags().StringP("output", "o", outputTable, "output format, table or json")
+
+	loginCmd.Flags().String("email", "", "email of user, password is read from $ARCHIVO_PASSWORD or stdin")
+	downloadCmd.Flags().StringP("out", "O", "", "path which snapshot is written to, '-' for stdout (default is filename in current directory)")
+	downloadCmd.Flags().Bool("force", false, "overwrite existing file")
+	diffCmd.Flags().String("local", "", "compare snapshot with this local file instead of another snapshot")
+}
+
+func CmdExecute() {
+	cliCmd.AddCommand(loginCmd)
+	cliCmd.AddCommand(logoutCmd)
+	cliCmd.AddCommand(serversCmd)
+	cliCmd.AddCommand(filesCmd)
+	cliCmd.AddCommand(snapshotsCmd)
+	cliCmd.AddCommand(downloadCmd)
+	cliCmd.AddCommand(diffCmd)
+	if err := cliCmd.Execute(); err != nil {
+		log.Fatalln(err.Error())
+	}
+}
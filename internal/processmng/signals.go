@@ -18,3 +18,14 @@ func OnInterrupt(cbs ...func()) {
 		cb()
 	}
 }
+
+// OnHangup calls cb on every hangup signal, e.g. to reload configuration
+func OnHangup(cb func()) {
+	sigs := make(chan os.Signal, 1)
+
+	signal.Notify(sigs, syscall.SIGHUP)
+	for range sigs {
+		log.Default().Println("hangup signal received")
+		cb()
+	}
+}
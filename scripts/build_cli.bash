@@ -1,6 +1,7 @@
 #!/bin/bash
 
 BUILD_DIR="build"
+VERSION="${VERSION:-$(git describe --tags --always 2>/dev/null || echo dev)}"
 
 builder() {
   printf "build application ${1} with entrypoint ${2}\n"
@@ -20,7 +21,7 @@ builder() {
       if [ $GOOS = "windows" ]; then
         output_name="$output_name.exe"
       fi
-      env GOOS=$GOOS GOARCH=$GOARCH go build -o $output_name ${ENTRY_FILE} && \
+      env GOOS=$GOOS GOARCH=$GOARCH go build -ldflags "-X github.com/ARTM2000/archivo/internal/agent.Version=${VERSION}" -o $output_name ${ENTRY_FILE} && \
         # Create checksum for compiled binary
         shasum -a 256 $output_name > $output_name.sha256 && \
         # Archive compiled binary and checksum
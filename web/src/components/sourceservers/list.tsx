@@ -1,4 +1,4 @@
-import { TableCell, TableRow, Checkbox } from '@mui/material';
+import { TableCell, TableRow, Checkbox, Chip, Stack } from '@mui/material';
 import React from 'react';
 import {
   Datagrid,
@@ -7,6 +7,7 @@ import {
   List,
   TextField,
   DateField,
+  FunctionField,
 } from 'react-admin';
 import { useNavigate } from 'react-router-dom';
 
@@ -46,6 +47,33 @@ const MyDatagridRow = (props: {
   );
 };
 
+type AgentStatus = {
+  online: boolean;
+  flagged: boolean;
+  failing_files: number;
+  unreachable_files: number;
+};
+
+const AgentStatusField = (props: { record: { agent: AgentStatus } }) => {
+  const { agent } = props.record;
+  return (
+    <Stack direction="row" spacing={1}>
+      <Chip
+        size="small"
+        label={agent.online ? 'Online' : 'Offline'}
+        color={agent.online ? 'success' : 'default'}
+      />
+      {agent.flagged && (
+        <Chip
+          size="small"
+          color="warning"
+          label={`${agent.failing_files} failing, ${agent.unreachable_files} unreachable`}
+        />
+      )}
+    </Stack>
+  );
+};
+
 const MyDatagridBody = (props: any) => (
   <DatagridBody {...props} row={<MyDatagridRow {...props} />} />
 );
@@ -64,6 +92,13 @@ export const SourceServerList = () => {
       <MyDatagrid>
         <TextField source="id" label="ID" />
         <TextField source="name" label="Name" />
+        <FunctionField
+          source="agent"
+          label="Agent"
+          render={(record: { agent: AgentStatus }) => (
+            <AgentStatusField record={record} />
+          )}
+        />
         <DateField
           source="created_at"
           label="Created at"
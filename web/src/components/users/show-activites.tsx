@@ -22,6 +22,7 @@ export const ShowUserActivities = () => {
         <Datagrid size="medium">
           <TextField source="id" label="ID" />
           <TextField source="act" label="Activity" />
+          <TextField source="ip" label="IP" />
           <DateField
             source="created_at"
             label="Created At"